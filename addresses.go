@@ -0,0 +1,108 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddressPreference selects which address of an instance Address returns.
+type AddressPreference string
+
+// Address preferences. If the preferred kind of address is not available,
+// for example an IPv4 address in an IPv6-only subnet, Address falls back to
+// the private IPv4 address, then the first IPv6 address, then the dual-stack
+// DNS name.
+const (
+	// AddressPreferenceIPv4 prefers the private IPv4 address. It is the
+	// default.
+	AddressPreferenceIPv4 AddressPreference = "ipv4"
+
+	// AddressPreferenceIPv6 prefers the first IPv6 address.
+	AddressPreferenceIPv6 AddressPreference = "ipv6"
+
+	// AddressPreferenceDualStack prefers the dual-stack DNS name, which
+	// resolves to both the IPv4 and IPv6 addresses.
+	AddressPreferenceDualStack AddressPreference = "dual-stack"
+)
+
+// Address returns the address that peers should use to reach instance,
+// according to AddressPreference. It returns the empty string if the
+// instance has no address yet.
+func (s *Cluster) Address(instance *ec2.Instance) string {
+	ipv4 := aws.StringValue(instance.PrivateIpAddress)
+	ipv6 := ""
+	if ipv6Addresses := InstanceIPv6Addresses(instance); len(ipv6Addresses) > 0 {
+		ipv6 = ipv6Addresses[0]
+	}
+	dnsName := DualStackDNSName(instance)
+
+	switch s.AddressPreference {
+	case AddressPreferenceIPv6:
+		if ipv6 != "" {
+			return ipv6
+		}
+	case AddressPreferenceDualStack:
+		if dnsName != "" {
+			return dnsName
+		}
+	}
+	for _, address := range []string{ipv4, ipv6, dnsName} {
+		if address != "" {
+			return address
+		}
+	}
+	return ""
+}
+
+// InstanceIPv6Addresses returns the IPv6 addresses of instance, starting with
+// its primary IPv6 address, if it has one.
+func InstanceIPv6Addresses(instance *ec2.Instance) []string {
+	rv := []string{}
+	seen := map[string]bool{}
+	add := func(address string) {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			rv = append(rv, address)
+		}
+	}
+
+	add(aws.StringValue(instance.Ipv6Address))
+	for _, networkInterface := range instance.NetworkInterfaces {
+		for _, address := range networkInterface.Ipv6Addresses {
+			add(aws.StringValue(address.Ipv6Address))
+		}
+	}
+	return rv
+}
+
+// DualStackDNSName returns the private DNS name of instance if it resolves
+// to both its IPv4 and IPv6 addresses, which is the case when the subnet
+// uses resource-based names with both A and AAAA records enabled. Otherwise
+// it returns the empty string.
+func DualStackDNSName(instance *ec2.Instance) string {
+	options := instance.PrivateDnsNameOptions
+	if options == nil ||
+		aws.StringValue(options.HostnameType) != ec2.HostnameTypeResourceName ||
+		!aws.BoolValue(options.EnableResourceNameDnsARecord) ||
+		!aws.BoolValue(options.EnableResourceNameDnsAAAARecord) {
+		return ""
+	}
+	return aws.StringValue(instance.PrivateDnsName)
+}
+
+// MemberAddresses returns the address, as returned by Address, of each
+// running member of the cluster, oldest first. Members without an address
+// are skipped.
+func (s *Cluster) MemberAddresses() ([]string, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	rv := []string{}
+	for _, instance := range runningInstances(members) {
+		if address := s.Address(instance); address != "" {
+			rv = append(rv, address)
+		}
+	}
+	return rv, nil
+}
@@ -0,0 +1,43 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type AddressesTest struct {
+}
+
+var _ = Suite(&AddressesTest{})
+
+func (s *AddressesTest) TestAddress(c *C) {
+	dualStack := fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now())
+	dualStack.Ipv6Address = aws.String("2600:1f14::1")
+	dualStack.NetworkInterfaces = []*ec2.InstanceNetworkInterface{{
+		Ipv6Addresses: []*ec2.InstanceIpv6Address{
+			{Ipv6Address: aws.String("2600:1f14::1")},
+			{Ipv6Address: aws.String("2600:1f14::2")},
+		},
+	}}
+	dualStack.PrivateDnsName = aws.String("i-00000001.us-east-1.compute.internal")
+	dualStack.PrivateDnsNameOptions = &ec2.PrivateDnsNameOptionsResponse{
+		HostnameType:                    aws.String(ec2.HostnameTypeResourceName),
+		EnableResourceNameDnsARecord:    aws.Bool(true),
+		EnableResourceNameDnsAAAARecord: aws.Bool(true),
+	}
+	c.Assert(InstanceIPv6Addresses(dualStack), DeepEquals, []string{"2600:1f14::1", "2600:1f14::2"})
+
+	c.Assert((&Cluster{}).Address(dualStack), Equals, "10.0.0.1")
+	c.Assert((&Cluster{AddressPreference: AddressPreferenceIPv6}).Address(dualStack), Equals, "2600:1f14::1")
+	c.Assert((&Cluster{AddressPreference: AddressPreferenceDualStack}).Address(dualStack), Equals, "i-00000001.us-east-1.compute.internal")
+
+	// An instance in an IPv6-only subnet has no IPv4 address.
+	ipv6Only := fakeInstance("i-00000002", ec2.InstanceStateNameRunning, time.Now())
+	ipv6Only.PrivateIpAddress = nil
+	ipv6Only.Ipv6Address = aws.String("2600:1f14::3")
+	c.Assert((&Cluster{}).Address(ipv6Only), Equals, "2600:1f14::3")
+	c.Assert(DualStackDNSName(ipv6Only), Equals, "")
+}
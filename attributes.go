@@ -0,0 +1,33 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// messageAttributes returns the String and Number message attributes of
+// message. Binary attributes are omitted.
+func messageAttributes(message *sqs.Message) map[string]string {
+	if len(message.MessageAttributes) == 0 {
+		return nil
+	}
+	rv := map[string]string{}
+	for name, value := range message.MessageAttributes {
+		if value.StringValue != nil {
+			rv[name] = aws.StringValue(value.StringValue)
+		}
+	}
+	return rv
+}
+
+// matchesAttributeFilter returns true if message has every attribute in
+// filter with the same value.
+func matchesAttributeFilter(message *sqs.Message, filter map[string]string) bool {
+	for name, value := range filter {
+		attribute, ok := message.MessageAttributes[name]
+		if !ok || aws.StringValue(attribute.StringValue) != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// States that a Bootstrap passes through, in order.
+const (
+	BootstrapWaitingForCapacity = "WaitingForCapacity"
+	BootstrapDiscoveringPeers   = "DiscoveringPeers"
+	BootstrapJoining            = "Joining"
+	BootstrapMarkingHealthy     = "MarkingHealthy"
+	BootstrapCompletingLaunch   = "CompletingLaunch"
+	BootstrapComplete           = "Complete"
+	BootstrapFailed             = "Failed"
+)
+
+// Bootstrap sequences the start up of a new cluster member. It waits for
+// the autoscaling group to reach its desired capacity, waits until enough
+// peers are running, invokes Join with the peers, and then optionally
+// marks the instance healthy and completes its launch lifecycle action.
+// This replaces ad-hoc "sleep until N peers are visible" logic.
+type Bootstrap struct {
+	Cluster *Cluster
+
+	// MinPeers is the number of running cluster members, including the
+	// current instance, required before Join is invoked. If zero, the
+	// desired capacity of the autoscaling group is used.
+	MinPeers int
+
+	// Join is invoked with the running members of the cluster, oldest
+	// first, once enough of them are visible.
+	Join func(peers []*ec2.Instance) error
+
+	// MarkHealthy, if true, reports the current instance as Healthy to the
+	// autoscaling group once Join succeeds.
+	MarkHealthy bool
+
+	// LifecycleHookName, if not empty, is the name of a launch lifecycle
+	// hook. Once Join succeeds, the lifecycle action for the current
+	// instance is completed with CONTINUE. If Join fails, it is completed
+	// with ABANDON so that the instance is replaced.
+	LifecycleHookName string
+
+	// PollInterval is how often the autoscaling group and cluster members
+	// are checked while waiting. If zero, ten seconds is used.
+	PollInterval time.Duration
+
+	// OnStateChange, if not nil, is invoked as the bootstrap enters each
+	// state.
+	OnStateChange func(state string)
+}
+
+// Run performs the bootstrap sequence, returning when it is complete, when
+// a step fails or when ctx is cancelled.
+func (b *Bootstrap) Run(ctx context.Context) error {
+	asg, err := b.Cluster.AutoscalingGroup()
+	if err != nil {
+		return b.fail(err)
+	}
+	if asg == nil {
+		return b.fail(ErrNotInAutoscalingGroup)
+	}
+	groupName := *asg.AutoScalingGroupName
+
+	pollInterval := b.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 10 * time.Second
+	}
+
+	b.setState(BootstrapWaitingForCapacity)
+	desiredCapacity := 0
+	err = waitContext(ctx, pollInterval, func() (bool, error) {
+		group, err := b.Cluster.describeAutoscalingGroup(groupName)
+		if err != nil {
+			return false, err
+		}
+		desiredCapacity = int(aws.Int64Value(group.DesiredCapacity))
+		return countLaunchedInstances(group) >= desiredCapacity, nil
+	})
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.setState(BootstrapDiscoveringPeers)
+	minPeers := b.MinPeers
+	if minPeers == 0 {
+		minPeers = desiredCapacity
+	}
+	peers := []*ec2.Instance{}
+	err = waitContext(ctx, pollInterval, func() (bool, error) {
+		members, err := b.Cluster.Members()
+		if err != nil {
+			return false, err
+		}
+		peers = runningInstances(members)
+		return len(peers) >= minPeers, nil
+	})
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.setState(BootstrapJoining)
+	if err := b.Join(peers); err != nil {
+		if b.LifecycleHookName != "" {
+			if err := b.completeLaunch(groupName, ResultAbandon); err != nil {
+				log.Printf("ERROR: CompleteLifecycleAction: %s", err)
+			}
+		}
+		return b.fail(err)
+	}
+
+	autoscalingSvc := b.Cluster.autoscalingClient()
+	if b.MarkHealthy {
+		b.setState(BootstrapMarkingHealthy)
+		_, err := autoscalingSvc.SetInstanceHealth(&autoscaling.SetInstanceHealthInput{
+			InstanceId:   aws.String(b.Cluster.InstanceID),
+			HealthStatus: aws.String(healthStatusHealthy),
+		})
+		if err != nil {
+			return b.fail(err)
+		}
+	}
+
+	if b.LifecycleHookName != "" {
+		b.setState(BootstrapCompletingLaunch)
+		if err := b.completeLaunch(groupName, ResultContinue); err != nil {
+			return b.fail(err)
+		}
+	}
+
+	b.setState(BootstrapComplete)
+	return nil
+}
+
+func (b *Bootstrap) setState(state string) {
+	if b.OnStateChange != nil {
+		b.OnStateChange(state)
+	}
+}
+
+func (b *Bootstrap) fail(err error) error {
+	b.setState(BootstrapFailed)
+	return err
+}
+
+// completeLaunch completes the launch lifecycle action of the current
+// instance through completeLifecycleAction, so that BeforeComplete,
+// AfterComplete and Recorder see it. The action token is not known here,
+// so the action is identified by instance ID instead.
+func (b *Bootstrap) completeLaunch(groupName, result string) error {
+	m := &LifecycleMessage{
+		AutoScalingGroupName: groupName,
+		LifecycleHookName:    b.LifecycleHookName,
+		LifecycleTransition:  TransitionLaunching,
+		EC2InstanceID:        b.Cluster.InstanceID,
+	}
+	err := b.Cluster.completeLifecycleAction(b.Cluster.autoscalingClient(), m, result)
+	b.Cluster.record(m, result, nil, err)
+	return err
+}
+
+// countLaunchedInstances returns the number of instances in the group that
+// are launching or in service. Instances that are launching include those
+// waiting on a launch lifecycle hook, like the current instance while it
+// bootstraps.
+func countLaunchedInstances(group *autoscaling.Group) int {
+	count := 0
+	for _, instance := range group.Instances {
+		switch aws.StringValue(instance.LifecycleState) {
+		case autoscaling.LifecycleStatePending,
+			autoscaling.LifecycleStatePendingWait,
+			autoscaling.LifecycleStatePendingProceed,
+			autoscaling.LifecycleStateInService:
+			count++
+		}
+	}
+	return count
+}
+
+// runningInstances returns the instances that are in the running state.
+func runningInstances(instances []*ec2.Instance) []*ec2.Instance {
+	rv := []*ec2.Instance{}
+	for _, instance := range instances {
+		if instance.State != nil && aws.StringValue(instance.State.Name) == ec2.InstanceStateNameRunning {
+			rv = append(rv, instance)
+		}
+	}
+	return rv
+}
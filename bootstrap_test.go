@@ -0,0 +1,200 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type BootstrapTest struct {
+}
+
+var _ = Suite(&BootstrapTest{})
+
+// bootstrapCluster returns a cluster of one running instance in a group
+// with a desired capacity of one.
+func bootstrapCluster(autoscalingSvc *fakeAutoScaling) *Cluster {
+	autoscalingSvc.groups = []*autoscaling.Group{{
+		AutoScalingGroupName: aws.String("example"),
+		DesiredCapacity:      aws.Int64(1),
+		Instances: []*autoscaling.Instance{{
+			InstanceId:     aws.String("i-00000001"),
+			LifecycleState: aws.String(autoscaling.LifecycleStatePendingWait),
+		}},
+	}}
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()))
+	return &Cluster{
+		InstanceID:           "i-00000001",
+		TagName:              "app",
+		AutoScalingGroupName: "example",
+		AutoScaling:          autoscalingSvc,
+		EC2:                  ec2Svc,
+	}
+}
+
+func (s *BootstrapTest) TestCompleteLaunch(c *C) {
+	for _, joinErr := range []error{nil, errors.New("cannot join")} {
+		autoscalingSvc := &fakeAutoScaling{}
+		cluster := bootstrapCluster(autoscalingSvc)
+		recorder := &S3Recorder{Store: &StateStore{Cluster: &Cluster{S3: newFakeS3()}, Bucket: "bucket", Prefix: "example/"}}
+		cluster.Recorder = recorder
+		completed := []string{}
+		cluster.BeforeComplete = func(m *LifecycleMessage, result string) {
+			completed = append(completed, "before "+m.EC2InstanceID+" "+result)
+		}
+		cluster.AfterComplete = func(m *LifecycleMessage, result string, err error) {
+			completed = append(completed, "after "+m.EC2InstanceID+" "+result)
+		}
+
+		b := &Bootstrap{
+			Cluster:           cluster,
+			LifecycleHookName: "launch",
+			PollInterval:      time.Millisecond,
+			Join:              func(peers []*ec2.Instance) error { return joinErr },
+		}
+		err := b.Run(context.Background())
+		c.Assert(err, Equals, joinErr)
+
+		result := ResultContinue
+		if joinErr != nil {
+			result = ResultAbandon
+		}
+
+		// The launch is completed like any other lifecycle action, without
+		// a token, and passed to the hooks and the recorder.
+		c.Assert(autoscalingSvc.completed, HasLen, 1)
+		c.Assert(*autoscalingSvc.completed[0].AutoScalingGroupName, Equals, "example")
+		c.Assert(*autoscalingSvc.completed[0].LifecycleHookName, Equals, "launch")
+		c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000001")
+		c.Assert(*autoscalingSvc.completed[0].LifecycleActionResult, Equals, result)
+		c.Assert(autoscalingSvc.completed[0].LifecycleActionToken, IsNil)
+		c.Assert(completed, DeepEquals, []string{"before i-00000001 " + result, "after i-00000001 " + result})
+
+		records, err := recorder.Records()
+		c.Assert(err, IsNil)
+		c.Assert(records, HasLen, 1)
+		c.Assert(records[0].Message.LifecycleTransition, Equals, TransitionLaunching)
+		c.Assert(records[0].Result, Equals, result)
+	}
+}
+
+func (s *BootstrapTest) TestRun(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := bootstrapCluster(autoscalingSvc)
+	group := autoscalingSvc.groups[0]
+	group.DesiredCapacity = aws.Int64(2)
+	ec2Svc := cluster.EC2.(*fakeEC2)
+	now := time.Now()
+	peer := fakeInstance("i-00000002", ec2.InstanceStateNamePending, now.Add(-time.Minute))
+	ec2Svc.setInstances(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now), peer)
+
+	// The second instance joins the group on the second poll for capacity,
+	// and is running by the second poll for peers.
+	groupDescribes := 0
+	autoscalingSvc.onDescribeGroups = func() {
+		groupDescribes++
+		if groupDescribes == 3 {
+			group.Instances = append(group.Instances, &autoscaling.Instance{
+				InstanceId:     aws.String("i-00000002"),
+				LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+			})
+		}
+	}
+	memberDescribes := 0
+	ec2Svc.onDescribeInstances = func() {
+		memberDescribes++
+		if memberDescribes == 2 {
+			peer.State.Name = aws.String(ec2.InstanceStateNameRunning)
+		}
+	}
+
+	states := []string{}
+	joined := []string{}
+	b := &Bootstrap{
+		Cluster:       cluster,
+		MarkHealthy:   true,
+		PollInterval:  time.Millisecond,
+		OnStateChange: func(state string) { states = append(states, state) },
+		Join: func(peers []*ec2.Instance) error {
+			for _, peer := range peers {
+				joined = append(joined, *peer.InstanceId)
+			}
+			return nil
+		},
+	}
+	c.Assert(b.Run(context.Background()), IsNil)
+	c.Assert(states, DeepEquals, []string{
+		BootstrapWaitingForCapacity,
+		BootstrapDiscoveringPeers,
+		BootstrapJoining,
+		BootstrapMarkingHealthy,
+		BootstrapComplete,
+	})
+	c.Assert(groupDescribes, Equals, 3)
+	c.Assert(memberDescribes, Equals, 2)
+
+	// The peers are passed oldest first, and the launch is not completed
+	// since there is no lifecycle hook.
+	c.Assert(joined, DeepEquals, []string{"i-00000002", "i-00000001"})
+	c.Assert(autoscalingSvc.healthy, DeepEquals, []string{"i-00000001"})
+	c.Assert(autoscalingSvc.completed, HasLen, 0)
+}
+
+func (s *BootstrapTest) TestRunMinPeers(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := bootstrapCluster(autoscalingSvc)
+	autoscalingSvc.groups[0].DesiredCapacity = aws.Int64(0)
+
+	joined := 0
+	b := &Bootstrap{
+		Cluster:      cluster,
+		MinPeers:     1,
+		PollInterval: time.Millisecond,
+		Join: func(peers []*ec2.Instance) error {
+			joined = len(peers)
+			return nil
+		},
+	}
+	c.Assert(b.Run(context.Background()), IsNil)
+	c.Assert(joined, Equals, 1)
+}
+
+func (s *BootstrapTest) TestRunCancelled(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := bootstrapCluster(autoscalingSvc)
+	autoscalingSvc.groups[0].DesiredCapacity = aws.Int64(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	states := []string{}
+	b := &Bootstrap{
+		Cluster:      cluster,
+		PollInterval: time.Millisecond,
+		OnStateChange: func(state string) {
+			states = append(states, state)
+			cancel()
+		},
+		Join: func(peers []*ec2.Instance) error {
+			c.Fatalf("unexpected join")
+			return nil
+		},
+	}
+	c.Assert(b.Run(ctx), Equals, context.Canceled)
+	c.Assert(states, DeepEquals, []string{BootstrapWaitingForCapacity, BootstrapFailed})
+}
+
+func (s *BootstrapTest) TestRunNotInAutoscalingGroup(c *C) {
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()))
+	cluster := &Cluster{InstanceID: "i-00000001", TagName: "app", EC2: ec2Svc}
+
+	states := []string{}
+	b := &Bootstrap{Cluster: cluster, OnStateChange: func(state string) { states = append(states, state) }}
+	c.Assert(b.Run(context.Background()), Equals, ErrNotInAutoscalingGroup)
+	c.Assert(states, DeepEquals, []string{BootstrapFailed})
+}
@@ -1,20 +1,37 @@
 package ec2cluster
 
 import (
+	"errors"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 // Cluster represents a cluster of AWS nodes. Clusters are a group of
 // EC2 instances that share the same value for one of their EC2 instance
 // tags. You specify the tag as `TagName`. To specify a cluster as all
 // the instances in an autoscaling group, specify `aws:autoscaling:groupName`
-// as the TagName.
+// as the TagName. A cluster defined by any other tag may span several
+// autoscaling groups; see AutoscalingGroups.
 //
 // If you don't specify InstanceID, then the EC2 metadata service will
 // be used to discover the ID of the currently running instnace.
@@ -24,9 +41,287 @@ type Cluster struct {
 	TagName    string
 	TagValue   string
 
+	// Region, if not empty, is the region of the cluster, overriding the
+	// region of AwsSession, so that one session can be used for clusters
+	// in several regions. See MultiCluster.
+	Region string
+
+	// AddressPreference selects the address that Address and
+	// MemberAddresses return for each instance, for clusters in IPv6-only
+	// or dual-stack subnets. If empty, AddressPreferenceIPv4 is used.
+	AddressPreference AddressPreference
+
+	// AutoScalingGroupName is the name of the autoscaling group that the
+	// current instance is part of. If empty, it is determined from the
+	// tags of the current instance.
+	AutoScalingGroupName string
+
+	// MessageParser decodes messages received by WatchLifecycleEvents. If
+	// nil, DefaultMessageParser is used.
+	MessageParser MessageParser
+
+	// NotificationCallback, if not nil, is invoked by WatchLifecycleEvents
+	// for each message that is not a lifecycle action. If nil, such
+	// messages are removed from the queue.
+	NotificationCallback NotificationCallback
+
+	// TransitionFilter selects the lifecycle actions that are passed to
+	// lifecycle event callbacks. Actions it rejects are removed from the
+	// queue without completing them. If nil, DefaultTransitionFilter is
+	// used.
+	TransitionFilter TransitionFilter
+
+	// MessageAttributeFilter, if not empty, restricts the lifecycle event
+	// watchers to messages that have each of these SQS message attributes
+	// with the given value, for example `{"environment": "prod"}`, so that
+	// one queue can be shared by several clusters. Other messages are
+	// handed back to the queue for their own watchers.
+	MessageAttributeFilter map[string]string
+
+	// ReceiveWaitTime, ReceiveMaxMessages and ReceiveVisibilityTimeout tune
+	// the ReceiveMessage calls of the lifecycle event watchers. They are
+	// how long each call waits for messages, at most 20 seconds; how many
+	// messages it returns, at most ten; and, if not zero, the visibility
+	// timeout of the messages in place of that of the queue. If zero, the
+	// longest wait and the most messages are used.
+	ReceiveWaitTime          time.Duration
+	ReceiveMaxMessages       int64
+	ReceiveVisibilityTimeout time.Duration
+
+	// EmptyReceiveBackoff, if not zero, is the longest that the lifecycle
+	// event watchers pause between receives while the queue is empty. The
+	// pause starts at one second and doubles after each empty receive, and
+	// ends as soon as a message is received. This cuts the cost of polling
+	// in large fleets where lifecycle events are rare, at the price of
+	// handling the first event after a quiet period later.
+	EmptyReceiveBackoff time.Duration
+
+	// QueueURLRefreshInterval, if not zero, is how often the lifecycle
+	// event watchers look up the URL of the queue they are watching, so
+	// that they follow the lifecycle hook if it is changed to point to a
+	// different queue.
+	QueueURLRefreshInterval time.Duration
+
+	// VisibilityRenewalInterval, if not zero, is how often the lifecycle
+	// event watchers extend the visibility timeout of a message while its
+	// callback runs, along with that of the messages of the same batch that
+	// are waiting to be handled after it. Each renewal makes the messages
+	// invisible for twice this interval. If it is zero, the visibility
+	// timeout of the queue must be long enough to handle a whole batch; see
+	// ReceiveMaxMessages.
+	VisibilityRenewalInterval time.Duration
+
+	// VisibilityRenewalErrorCallback, if not nil, is invoked when extending
+	// the visibility timeout of a message fails. Failures are also logged
+	// and counted in Metrics.
+	VisibilityRenewalErrorCallback func(m *LifecycleMessage, err error)
+
+	// AbortOnVisibilityRenewalError, if true, cancels the context passed to
+	// a LifecycleEventContextCallback when the visibility timeout of its
+	// message cannot be extended, since the message may then be delivered
+	// to another consumer. The lifecycle action is not completed and the
+	// message is left in the queue.
+	AbortOnVisibilityRenewalError bool
+
+	// ProgressCallback, if not nil, is invoked by the lifecycle event
+	// watchers each time they receive from the queue and each time they
+	// extend the visibility timeout of a message whose callback is running,
+	// so that a daemon can tell a service watchdog that it is still alive.
+	// It may be invoked from several goroutines at once.
+	ProgressCallback func()
+
+	// BeforeComplete and AfterComplete, if not nil, are invoked before and
+	// after each lifecycle action is completed by the lifecycle event
+	// watchers or LifecycleEvent.Complete, with the result, ResultContinue
+	// or ResultAbandon, and the error from CompleteLifecycleAction. They can
+	// be used to write audit records or to alert on abandoned actions.
+	BeforeComplete func(m *LifecycleMessage, result string)
+	AfterComplete  func(m *LifecycleMessage, result string, err error)
+
+	// DryRun, if true, makes the lifecycle event watchers invoke their
+	// callback for each lifecycle action without completing it or removing
+	// its message from the queue, so that a new callback can be tried on
+	// production traffic. The result is logged and recorded with Recorder.
+	// Messages that would have been removed are instead hidden for
+	// DryRunVisibilityTimeout, or an hour if it is zero, so that they are
+	// not received again at once. Other consumers of the queue do not see
+	// them meanwhile, so a dry run is best pointed at a queue of its own
+	// that receives a copy of the lifecycle events, for example through an
+	// SNS topic.
+	DryRun                  bool
+	DryRunVisibilityTimeout time.Duration
+
+	// Metrics, if not nil, receives counters from the lifecycle event
+	// watchers.
+	Metrics Metrics
+
+	// Deduplicator, if not nil, is used by the lifecycle event watchers to
+	// invoke the callback at most once for each lifecycle action, even
+	// if its message is delivered more than once.
+	Deduplicator Deduplicator
+
+	// Recorder, if not nil, receives a record of each lifecycle action
+	// handled by the lifecycle event watchers or completed with
+	// LifecycleEvent.Complete.
+	Recorder Recorder
+
+	// SQS, AutoScaling, EC2, ECS, S3, DynamoDB, SSM and CloudWatchLogs, if
+	// not nil, are the clients used to call each service, for example to
+	// use a custom endpoint, add request handlers or substitute a fake in
+	// tests. If nil, a client is created from AwsSession.
+	SQS         sqsiface.SQSAPI
+	AutoScaling autoscalingiface.AutoScalingAPI
+	EC2         ec2iface.EC2API
+	ECS         ecsiface.ECSAPI
+	S3          s3iface.S3API
+	DynamoDB    dynamodbiface.DynamoDBAPI
+	SSM         ssmiface.SSMAPI
+
+	CloudWatchLogs cloudwatchlogsiface.CloudWatchLogsAPI
+
+	// AutoScalingRateLimiter, EC2RateLimiter and SQSRateLimiter, if not
+	// nil, limit the rate of calls to each service, so that a large fleet
+	// of instances using the package stays within the AWS API limits. They
+	// apply only to clients created from AwsSession; a client supplied
+	// above should be limited by its creator.
+	AutoScalingRateLimiter RateLimiter
+	EC2RateLimiter         RateLimiter
+	SQSRateLimiter         RateLimiter
+
+	// PollJitter, if not zero, is the fraction of each polling interval by
+	// which it is randomly lengthened or shortened, so that the members of
+	// a cluster started together do not poll in step. For example, 0.1
+	// varies the interval by up to 10%.
+	PollJitter float64
+
+	// StartupJitter, if not zero, is the longest time that the lifecycle
+	// event watchers wait before they first receive from the queue. Each
+	// waits a random time, so that the members of a fleet that is
+	// restarted at once do not all poll the queue together.
+	StartupJitter time.Duration
+
 	instance         *ec2.Instance
 	autoScalingGroup *autoscaling.Group
 	members          []*ec2.Instance
+	snapshot         *Snapshot
+}
+
+// ErrNotInAutoscalingGroup is returned when an operation requires the
+// current instance to be a member of an autoscaling group, but it is not.
+var ErrNotInAutoscalingGroup = errors.New("instance is not a member of an autoscaling group")
+
+// DiscoverCluster returns a Cluster made up of the members of the
+// autoscaling group of the current instance. The current instance is
+// determined from the EC2 metadata service and its autoscaling group is
+// looked up with DescribeAutoScalingInstances, so neither needs to be
+// passed to the instance by user data or tags.
+func DiscoverCluster(awsSession *session.Session) (*Cluster, error) {
+	instanceID, err := DiscoverInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &Cluster{
+		AwsSession: awsSession,
+		InstanceID: instanceID,
+		TagName:    autoscalingGroupNameTag,
+	}
+	autoscalingSvc := cluster.autoscalingClient()
+	resp, err := autoscalingSvc.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeAutoScalingInstances", err)
+	}
+	if len(resp.AutoScalingInstances) != 1 {
+		return nil, ErrNotInAutoscalingGroup
+	}
+	cluster.AutoScalingGroupName = *resp.AutoScalingInstances[0].AutoScalingGroupName
+	cluster.TagValue = cluster.AutoScalingGroupName
+	return cluster, nil
+}
+
+// awsConfig returns the configuration that overrides AwsSession for the
+// clients of the cluster.
+func (s *Cluster) awsConfig() *aws.Config {
+	config := aws.NewConfig()
+	if s.Region != "" {
+		config = config.WithRegion(s.Region)
+	}
+	return config
+}
+
+// region returns the region of the cluster.
+func (s *Cluster) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	if s.AwsSession != nil {
+		return aws.StringValue(s.AwsSession.Config.Region)
+	}
+	return ""
+}
+
+func (s *Cluster) sqsClient() sqsiface.SQSAPI {
+	if s.SQS != nil {
+		return s.SQS
+	}
+	sqsSvc := sqs.New(s.AwsSession, s.awsConfig())
+	limitRate(&sqsSvc.Handlers, s.SQSRateLimiter)
+	return sqsSvc
+}
+
+func (s *Cluster) autoscalingClient() autoscalingiface.AutoScalingAPI {
+	if s.AutoScaling != nil {
+		return s.AutoScaling
+	}
+	autoscalingSvc := autoscaling.New(s.AwsSession, s.awsConfig())
+	limitRate(&autoscalingSvc.Handlers, s.AutoScalingRateLimiter)
+	return autoscalingSvc
+}
+
+func (s *Cluster) ec2Client() ec2iface.EC2API {
+	if s.EC2 != nil {
+		return s.EC2
+	}
+	ec2Svc := ec2.New(s.AwsSession, s.awsConfig())
+	limitRate(&ec2Svc.Handlers, s.EC2RateLimiter)
+	return ec2Svc
+}
+
+func (s *Cluster) ecsClient() ecsiface.ECSAPI {
+	if s.ECS != nil {
+		return s.ECS
+	}
+	return ecs.New(s.AwsSession, s.awsConfig())
+}
+
+func (s *Cluster) s3Client() s3iface.S3API {
+	if s.S3 != nil {
+		return s.S3
+	}
+	return s3.New(s.AwsSession, s.awsConfig())
+}
+
+func (s *Cluster) dynamoDBClient() dynamodbiface.DynamoDBAPI {
+	if s.DynamoDB != nil {
+		return s.DynamoDB
+	}
+	return dynamodb.New(s.AwsSession, s.awsConfig())
+}
+
+func (s *Cluster) cloudWatchLogsClient() cloudwatchlogsiface.CloudWatchLogsAPI {
+	if s.CloudWatchLogs != nil {
+		return s.CloudWatchLogs
+	}
+	return cloudwatchlogs.New(s.AwsSession, s.awsConfig())
+}
+
+func (s *Cluster) ssmClient() ssmiface.SSMAPI {
+	if s.SSM != nil {
+		return s.SSM
+	}
+	return ssm.New(s.AwsSession, s.awsConfig())
 }
 
 // Instance returns the currently running EC2 instance.
@@ -35,50 +330,54 @@ func (s *Cluster) Instance() (*ec2.Instance, error) {
 		return s.instance, nil
 	}
 
-	ec2svc := ec2.New(s.AwsSession)
+	instance, err := s.describeInstance(s.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	s.instance = instance
+	return s.instance, nil
+}
+
+// describeInstance returns the EC2 instance with the specified ID.
+func (s *Cluster) describeInstance(instanceID string) (*ec2.Instance, error) {
+	ec2svc := s.ec2Client()
 	resp, err := ec2svc.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: []*string{aws.String(s.InstanceID)},
+		InstanceIds: []*string{aws.String(instanceID)},
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError("DescribeInstances", err)
 	}
 	if len(resp.Reservations) != 1 || len(resp.Reservations[0].Instances) != 1 {
-		return nil, fmt.Errorf("Cannot find instance %s", s.InstanceID)
+		return nil, &NotFoundError{Kind: "instance", ID: instanceID}
 	}
-	s.instance = resp.Reservations[0].Instances[0]
-	return s.instance, nil
+	return resp.Reservations[0].Instances[0], nil
 }
 
-type byLaunchTime []*ec2.Instance
-
-func (a byLaunchTime) Len() int           { return len(a) }
-func (a byLaunchTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byLaunchTime) Less(i, j int) bool { return a[i].LaunchTime.Before(*a[j].LaunchTime) }
+// sortByLaunchTime sorts instances from oldest to youngest. Instances
+// launched together by one scale out can share a launch time, so ties are
+// broken by instance ID, so that every member sorts the same instances in
+// the same order.
+func sortByLaunchTime(instances []*ec2.Instance) {
+	sort.SliceStable(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if !a.LaunchTime.Equal(*b.LaunchTime) {
+			return a.LaunchTime.Before(*b.LaunchTime)
+		}
+		return aws.StringValue(a.InstanceId) < aws.StringValue(b.InstanceId)
+	})
+}
 
 // Members returns a list of cluster members in order from
 // oldest to youngest.
 func (s *Cluster) Members() ([]*ec2.Instance, error) {
-
-	tagValue := s.TagValue
-	if tagValue == "" {
-		instance, err := s.Instance()
-		if err != nil {
-			return nil, err
-		}
-		for _, tag := range instance.Tags {
-			if *tag.Key == s.TagName {
-				tagValue = *tag.Value
-			}
-		}
-	}
-	if tagValue == "" {
-		return nil, fmt.Errorf("Current instance (%s) does not have a tag %s.",
-			s.InstanceID, s.TagName)
+	tagValue, err := s.tagValue()
+	if err != nil {
+		return nil, err
 	}
 
-	ec2svc := ec2.New(s.AwsSession)
+	ec2svc := s.ec2Client()
 	members := []*ec2.Instance{}
-	err := ec2svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+	err = ec2svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			&ec2.Filter{
 				Name:   aws.String(fmt.Sprintf("tag:%s", s.TagName)),
@@ -94,48 +393,105 @@ func (s *Cluster) Members() ([]*ec2.Instance, error) {
 		return true
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError("DescribeInstances", err)
 	}
 
-	sort.Sort(byLaunchTime(members))
+	sortByLaunchTime(members)
 	s.members = members
 	return s.members, nil
 }
 
-// AutoscalingGroup returns the autoscaling group that the current instance
-// is part of. If the current instance is not a member of any autoscaling
-// group, returns nil and a nil error.
-func (s *Cluster) AutoscalingGroup() (*autoscaling.Group, error) {
+// tagValue returns TagValue, or if it is empty, the value of the tag
+// TagName on the current instance.
+func (s *Cluster) tagValue() (string, error) {
+	if s.TagValue != "" {
+		return s.TagValue, nil
+	}
 	instance, err := s.Instance()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	tagValue := ""
+	for _, tag := range instance.Tags {
+		if *tag.Key == s.TagName {
+			tagValue = *tag.Value
+		}
+	}
+	if tagValue == "" {
+		return "", &NotFoundError{Kind: "tag " + s.TagName + " on instance", ID: s.InstanceID}
+	}
+	return tagValue, nil
+}
 
+// AutoscalingGroup returns the autoscaling group that the current instance
+// is part of. If the current instance is not a member of any autoscaling
+// group, returns nil and a nil error.
+func (s *Cluster) AutoscalingGroup() (*autoscaling.Group, error) {
 	if s.autoScalingGroup != nil {
 		return s.autoScalingGroup, nil
 	}
 
-	autoscalingGroupName := ""
-	for _, tag := range instance.Tags {
-		if *tag.Key == "aws:autoscaling:groupName" {
-			autoscalingGroupName = *tag.Value
+	autoscalingGroupName := s.AutoScalingGroupName
+	if autoscalingGroupName == "" {
+		instance, err := s.Instance()
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range instance.Tags {
+			if *tag.Key == autoscalingGroupNameTag {
+				autoscalingGroupName = *tag.Value
+			}
 		}
 	}
 	if autoscalingGroupName == "" {
 		return nil, nil
 	}
 
-	autoscalingService := autoscaling.New(s.AwsSession)
-	groupInfo, err := autoscalingService.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+	group, err := s.describeAutoscalingGroup(autoscalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+	s.autoScalingGroup = group
+	return s.autoScalingGroup, nil
+}
+
+// LaunchTemplate returns the launch template that the autoscaling group of
+// the current instance launches instances from. For groups with a mixed
+// instances policy, the template of the policy is returned. If the group
+// uses a launch configuration instead, returns nil and a nil error.
+func (s *Cluster) LaunchTemplate() (*autoscaling.LaunchTemplateSpecification, error) {
+	asg, err := s.AutoscalingGroup()
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
+		return nil, ErrNotInAutoscalingGroup
+	}
+	if asg.LaunchTemplate != nil {
+		return asg.LaunchTemplate, nil
+	}
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		return asg.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification, nil
+	}
+	return nil, nil
+}
+
+// describeAutoscalingGroup returns the current state of the named
+// autoscaling group.
+func (s *Cluster) describeAutoscalingGroup(autoscalingGroupName string) (*autoscaling.Group, error) {
+	autoscalingService := s.autoscalingClient()
+	groups := []*autoscaling.Group{}
+	err := autoscalingService.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String(autoscalingGroupName)},
-		MaxRecords:            aws.Int64(1),
+	}, func(resp *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		groups = append(groups, resp.AutoScalingGroups...)
+		return true
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError("DescribeAutoScalingGroups", err)
 	}
-	if len(groupInfo.AutoScalingGroups) != 1 {
-		return nil, fmt.Errorf("cannot find autoscaling group %s", autoscalingGroupName)
+	if len(groups) != 1 {
+		return nil, &NotFoundError{Kind: "autoscaling group", ID: autoscalingGroupName}
 	}
-	s.autoScalingGroup = groupInfo.AutoScalingGroups[0]
-	return s.autoScalingGroup, nil
+	return groups[0], nil
 }
@@ -1,6 +1,7 @@
 package ec2cluster
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -27,11 +28,11 @@ type ClusterTest struct {
 var _ = Suite(&ClusterTest{})
 
 func (s *ClusterTest) TestNotInEC2(c *C) {
-	t := *(http.DefaultTransport.(*http.Transport))
-	t.Dial = func(network, addr string) (net.Conn, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return net.Dial(network, "0.0.0.0:9")
 	}
-	http.DefaultClient.Transport = &t
+	http.DefaultClient.Transport = t
 	defer func() { http.DefaultClient.Transport = nil }()
 
 	addr, err := DiscoverAdvertiseAddress()
@@ -130,11 +131,11 @@ func (s *ClusterTest) TestDiscovery(c *C) {
 	serverURL, err := url.Parse(server.URL)
 	c.Assert(err, IsNil)
 
-	t := *(http.DefaultTransport.(*http.Transport))
-	t.Dial = func(network, addr string) (net.Conn, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return net.Dial(network, serverURL.Host)
 	}
-	http.DefaultClient.Transport = &t
+	http.DefaultClient.Transport = t
 	defer func() { http.DefaultClient.Transport = nil }()
 
 	awsConfig := aws.NewConfig()
@@ -153,3 +154,93 @@ func (s *ClusterTest) TestDiscovery(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(addr, Equals, "10.0.0.99")
 }
+
+func (s *ClusterTest) TestDiscoverCluster(c *C) {
+	for _, instances := range []string{
+		`<member><InstanceId>i-1a2b3c4d</InstanceId><AutoScalingGroupName>example</AutoScalingGroupName></member>`,
+		``,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Host == "169.254.169.254" {
+				c.Assert(r.URL.String(), Equals, "/latest/meta-data/instance-id")
+				fmt.Fprintf(w, "i-1a2b3c4d")
+				return
+			}
+			r.ParseForm()
+			c.Assert(r.Form.Get("Action"), Equals, "DescribeAutoScalingInstances")
+			c.Assert(r.Form.Get("InstanceIds.member.1"), Equals, "i-1a2b3c4d")
+			fmt.Fprintf(w, `<DescribeAutoScalingInstancesResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/">
+			  <DescribeAutoScalingInstancesResult>
+				<AutoScalingInstances>%s</AutoScalingInstances>
+			  </DescribeAutoScalingInstancesResult>
+			  <ResponseMetadata><RequestId>fdcdcab1-ae5c-489e-9c33-4637c5dda355</RequestId></ResponseMetadata>
+			</DescribeAutoScalingInstancesResponse>`, instances)
+		}))
+
+		serverURL, err := url.Parse(server.URL)
+		c.Assert(err, IsNil)
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, serverURL.Host)
+		}
+		http.DefaultClient.Transport = t
+
+		awsConfig := aws.NewConfig()
+		awsConfig.WithDisableSSL(true)
+		awsConfig.WithRegion("us-east-1")
+		awsConfig.WithCredentials(
+			credentials.NewStaticCredentials("AKIAJJJJJJJJJJJJJJJ", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", ""))
+
+		cluster, err := DiscoverCluster(session.New(awsConfig))
+		http.DefaultClient.Transport = nil
+		server.Close()
+
+		if instances == "" {
+			c.Assert(err, Equals, ErrNotInAutoscalingGroup)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(cluster.InstanceID, Equals, "i-1a2b3c4d")
+		c.Assert(cluster.AutoScalingGroupName, Equals, "example")
+		c.Assert(cluster.TagName, Equals, autoscalingGroupNameTag)
+		c.Assert(cluster.TagValue, Equals, "example")
+	}
+}
+
+func (s *ClusterTest) TestScheduledEvents(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.String(), Equals, "/latest/meta-data/events/maintenance/scheduled")
+		fmt.Fprintf(w, `[
+			{
+				"NotBefore" : "21 Jan 2019 09:00:43 GMT",
+				"Code" : "system-reboot",
+				"Description" : "scheduled reboot",
+				"EventId" : "instance-event-0d59937288b749b32",
+				"NotAfter" : "21 Jan 2019 09:17:23 GMT",
+				"State" : "active"
+			}
+		]`)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, IsNil)
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, serverURL.Host)
+	}
+	http.DefaultClient.Transport = t
+	defer func() { http.DefaultClient.Transport = nil }()
+
+	events, err := DiscoverScheduledEvents()
+	c.Assert(err, IsNil)
+	c.Assert(events, DeepEquals, []ScheduledEvent{{
+		Code:        "system-reboot",
+		Description: "scheduled reboot",
+		EventID:     "instance-event-0d59937288b749b32",
+		NotBefore:   "21 Jan 2019 09:00:43 GMT",
+		NotAfter:    "21 Jan 2019 09:17:23 GMT",
+		State:       "active",
+	}})
+}
@@ -1,49 +1,47 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/crewjam/awsregion"
 	"github.com/crewjam/ec2cluster"
 )
 
 func main() {
-	instanceID := flag.String("instance", "",
-		"The instance ID of the cluster member. If not supplied, then the instance ID is determined from EC2 metadata")
-	clusterTagName := flag.String("tag", "aws:autoscaling:groupName",
-		"The instance tag that is common to all members of the cluster")
-	clusterTagValue := flag.String("tag-value", "",
-		"The value of the tag used to describe cluster members. Default is the value of the tag in the current instance")
-	queueURL := flag.String("watch-queue", "",
-		"Monitor autoscaling lifecycle events for the current autoscaling group and print them to stdout as they occur")
-	flag.Parse()
-
-	if *instanceID == "" {
-		var err error
-		*instanceID, err = ec2cluster.DiscoverInstanceID()
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "watch":
+			watchMain(os.Args[2:])
+			return
+		case "members":
+			membersMain(os.Args[2:])
+			return
+		case "evacuate":
+			evacuateMain(os.Args[2:])
+			return
 		}
 	}
 
-	s := &ec2cluster.Cluster{
-		InstanceID: *instanceID,
-		TagName:    *clusterTagName,
-		TagValue:   *clusterTagValue,
-	}
-
-	s.AwsSession = session.New()
-	if region := os.Getenv("AWS_REGION"); region != "" {
-		s.AwsSession.Config.WithRegion(region)
-	}
-	awsregion.GuessRegion(s.AwsSession.Config)
+	newCluster := clusterFlags(flag.CommandLine)
+	queueURL := flag.String("watch-queue", "",
+		"Monitor autoscaling lifecycle events for the current autoscaling group and print them to stdout as they occur")
+	flag.Parse()
 
+	s := newCluster()
 	if *queueURL != "" {
 		err := s.WatchLifecycleEvents(*queueURL, func(m *ec2cluster.LifecycleMessage) (bool, error) {
 			fmt.Printf("%s\t%s\n", m.LifecycleTransition, m.EC2InstanceID)
@@ -63,8 +61,8 @@ func main() {
 	availabilityZone := *instance.Placement.AvailabilityZone
 	fmt.Printf("AVAILABILITY_ZONE=\"%s\"\n", availabilityZone)
 	fmt.Printf("REGION=\"%s\"\n", availabilityZone[:len(availabilityZone)-1])
-	if instance.PrivateIpAddress != nil {
-		fmt.Printf("ADVERTISE_ADDRESS=\"%s\"\n", *instance.PrivateIpAddress)
+	if address := s.Address(instance); address != "" {
+		fmt.Printf("ADVERTISE_ADDRESS=\"%s\"\n", address)
 	}
 	for _, tag := range instance.Tags {
 		fmt.Printf("TAG_%s=\"%s\"\n", strings.ToUpper(
@@ -89,9 +87,298 @@ func main() {
 
 	clusterVar := []string{}
 	for _, instance := range clusterInstances {
-		if instance.PrivateIpAddress != nil {
-			clusterVar = append(clusterVar, *instance.PrivateIpAddress)
+		if address := s.Address(instance); address != "" {
+			clusterVar = append(clusterVar, address)
 		}
 	}
 	fmt.Printf("CLUSTER=\"%s\"\n", strings.Join(clusterVar, " "))
 }
+
+// clusterFlags registers the flags that describe the cluster on fs and
+// returns a function that makes the Cluster once fs has been parsed.
+func clusterFlags(fs *flag.FlagSet) func() *ec2cluster.Cluster {
+	instanceID := fs.String("instance", "",
+		"The instance ID of the cluster member. If not supplied, then the instance ID is determined from EC2 metadata")
+	clusterTagName := fs.String("tag", "aws:autoscaling:groupName",
+		"The instance tag that is common to all members of the cluster")
+	clusterTagValue := fs.String("tag-value", "",
+		"The value of the tag used to describe cluster members. Default is the value of the tag in the current instance")
+
+	clusterRegion := fs.String("region", "",
+		"The region of the cluster. Default is $AWS_REGION or the region of the current instance")
+	addressPreference := fs.String("address-preference", string(ec2cluster.AddressPreferenceIPv4),
+		"The address to report for each instance: ipv4, ipv6 or dual-stack")
+
+	return func() *ec2cluster.Cluster {
+		if *instanceID == "" {
+			var err error
+			*instanceID, err = ec2cluster.DiscoverInstanceID()
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+		}
+
+		s := &ec2cluster.Cluster{
+			InstanceID: *instanceID,
+			TagName:    *clusterTagName,
+			TagValue:   *clusterTagValue,
+			Region:     *clusterRegion,
+
+			AddressPreference: ec2cluster.AddressPreference(*addressPreference),
+		}
+
+		s.AwsSession = session.New()
+		if region := os.Getenv("AWS_REGION"); region != "" {
+			s.AwsSession.Config.WithRegion(region)
+		}
+		awsregion.GuessRegion(s.AwsSession.Config)
+		return s
+	}
+}
+
+// watchMain implements `ec2cluster watch`, which handles lifecycle events
+// from the lifecycle hook queue of the current autoscaling group.
+func watchMain(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	newCluster := clusterFlags(fs)
+	queueURL := fs.String("queue", "",
+		"The URL of the lifecycle event queue. Default is the queue of the lifecycle hook of the current autoscaling group")
+	script := fs.String("exec", "",
+		"A program to run for each lifecycle event. If it exits with status 0 the lifecycle action is continued, otherwise it is abandoned. If not supplied, events are printed to stdout")
+	webhookURL := fs.String("webhook", "",
+		"A URL to POST each lifecycle event to. A 2xx response continues the lifecycle action, other 4xx responses abandon it, and 5xx responses are retried. Requests are signed with $EC2CLUSTER_WEBHOOK_SECRET if it is set")
+	attributeFilter := fs.String("attribute-filter", "",
+		"Only handle messages with these SQS message attributes, as comma-separated name=value pairs, for example environment=prod. Other messages are left for other watchers")
+	statusAddr := fs.String("status-addr", "",
+		"If set, the address to serve the cluster status on, for example :8080. See ec2cluster.StatusHandler")
+	leaderOnly := fs.Bool("leader-only", false,
+		"Only watch the queue while this instance is the leader of the cluster, so that one member of a fleet consumes the queue at a time")
+	leaderInterval := fs.Duration("leader-check-interval", 30*time.Second,
+		"How often to check whether this instance is the leader, with --leader-only")
+	startupJitter := fs.Duration("startup-jitter", 0,
+		"Wait a random time of up to this long before watching the queue")
+	dryRun := fs.Bool("dry-run", false,
+		"Handle each lifecycle event without completing its lifecycle action or removing it from the queue, to try out a program on production traffic")
+	emptyBackoff := fs.Duration("empty-receive-backoff", 0,
+		"If set, the longest to pause between receives while the queue is empty, to reduce the number of SQS requests")
+	renewInterval := fs.Duration("visibility-renewal-interval", 30*time.Second,
+		"How often to extend the visibility timeout of an event while the program runs")
+	fs.Parse(args)
+
+	s := newCluster()
+	s.VisibilityRenewalInterval = *renewInterval
+	s.AbortOnVisibilityRenewalError = true
+	s.ProgressCallback = sdWatchdog()
+	s.StartupJitter = *startupJitter
+	s.DryRun = *dryRun
+	s.EmptyReceiveBackoff = *emptyBackoff
+	if *attributeFilter != "" {
+		s.MessageAttributeFilter = map[string]string{}
+		for _, pair := range strings.Split(*attributeFilter, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				log.Fatalf("ERROR: --attribute-filter: expected name=value, got %q", pair)
+			}
+			s.MessageAttributeFilter[name] = value
+		}
+	}
+
+	if *queueURL == "" {
+		var err error
+		*queueURL, err = s.LifecycleEventQueueURL()
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+
+	// On SIGTERM or SIGINT, stop receiving events but let an event that is
+	// being handled finish, so that its lifecycle action is completed before
+	// the process exits.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		sdNotify("STOPPING=1")
+	}()
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("ERROR: sd_notify: %s", err)
+	}
+
+	status := &ec2cluster.StatusHandler{Cluster: s}
+	if *statusAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/cluster/", status)
+		go func() {
+			log.Fatalf("ERROR: %s", http.ListenAndServe(*statusAddr, mux))
+		}()
+	}
+
+	var webhook *ec2cluster.Webhook
+	if *webhookURL != "" {
+		webhook = &ec2cluster.Webhook{URL: *webhookURL}
+		if secret := os.Getenv("EC2CLUSTER_WEBHOOK_SECRET"); secret != "" {
+			webhook.Secret = []byte(secret)
+		}
+	}
+
+	watch := s.WatchLifecycleEventsContext
+	if *leaderOnly {
+		watch = func(ctx context.Context, queueURL string, cb ec2cluster.LifecycleEventContextCallback) error {
+			return s.WatchLifecycleEventsAsLeader(ctx, queueURL, *leaderInterval, cb)
+		}
+	}
+	err := watch(ctx, *queueURL, status.Watch(func(ctx context.Context, m *ec2cluster.LifecycleMessage) (bool, error) {
+		if webhook != nil {
+			return webhook.HandleLifecycleEvent(ctx, m)
+		}
+		if *script == "" {
+			fmt.Printf("%s\t%s\n", m.LifecycleTransition, m.EC2InstanceID)
+			return true, nil
+		}
+		return runScript(ctx, *script, m)
+	}))
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+// runScript runs script for the lifecycle event m, describing the event in
+// its environment. It returns true if the script exits with status 0 and an
+// error if the script cannot be run at all, which leaves the event in the
+// queue to be retried.
+func runScript(ctx context.Context, script string, m *ec2cluster.LifecycleMessage) (bool, error) {
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"INSTANCE_ID="+m.EC2InstanceID,
+		"TRANSITION="+m.LifecycleTransition,
+		"HOOK_NAME="+m.LifecycleHookName,
+		"AUTOSCALING_GROUP_NAME="+m.AutoScalingGroupName,
+		"LIFECYCLE_ACTION_TOKEN="+m.LifecycleActionToken,
+	)
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		log.Printf("%s: %s: %s, abandoning", m.EC2InstanceID, script, err)
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("ERROR: %s: %s", script, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// member is the JSON representation of a cluster member printed by
+// `ec2cluster members`.
+type member struct {
+	InstanceID       string    `json:"instance_id"`
+	State            string    `json:"state"`
+	AvailabilityZone string    `json:"availability_zone"`
+	PrivateIPAddress string    `json:"private_ip_address,omitempty"`
+	PublicIPAddress  string    `json:"public_ip_address,omitempty"`
+	IPv6Addresses    []string  `json:"ipv6_addresses,omitempty"`
+	DNSName          string    `json:"dns_name,omitempty"`
+	LaunchTime       time.Time `json:"launch_time"`
+
+	PlacementGroup        string `json:"placement_group,omitempty"`
+	PartitionNumber       int64  `json:"partition_number,omitempty"`
+	CapacityReservationID string `json:"capacity_reservation_id,omitempty"`
+}
+
+// membersMain implements `ec2cluster members`, which prints the members of
+// the cluster to stdout as a JSON array, oldest first.
+func membersMain(args []string) {
+	fs := flag.NewFlagSet("members", flag.ExitOnError)
+	newCluster := clusterFlags(fs)
+	fs.Parse(args)
+
+	s := newCluster()
+	instances, err := s.Members()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	members := []member{}
+	for _, instance := range instances {
+		m := member{
+			InstanceID:       aws.StringValue(instance.InstanceId),
+			PrivateIPAddress: aws.StringValue(instance.PrivateIpAddress),
+			PublicIPAddress:  aws.StringValue(instance.PublicIpAddress),
+			IPv6Addresses:    ec2cluster.InstanceIPv6Addresses(instance),
+			DNSName:          ec2cluster.DualStackDNSName(instance),
+			LaunchTime:       aws.TimeValue(instance.LaunchTime),
+		}
+		if instance.State != nil {
+			m.State = aws.StringValue(instance.State.Name)
+		}
+		if instance.Placement != nil {
+			m.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		placement := ec2cluster.InstancePlacement(instance)
+		m.PlacementGroup = placement.PlacementGroup
+		m.PartitionNumber = placement.PartitionNumber
+		m.CapacityReservationID = placement.CapacityReservationID
+		members = append(members, m)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(members); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+// evacuateMain implements `ec2cluster evacuate`, which moves the current
+// autoscaling group out of an availability zone and prints the result to
+// stdout as JSON. Passing that result to `--restore` adds the zone back.
+func evacuateMain(args []string) {
+	fs := flag.NewFlagSet("evacuate", flag.ExitOnError)
+	newCluster := clusterFlags(fs)
+	zone := fs.String("zone", "",
+		"The availability zone to move the autoscaling group out of, for example us-east-1a")
+	detach := fs.Bool("detach", false,
+		"Detach each instance and terminate it once its replacement is in service, rather than terminating it in the group, which runs the termination lifecycle hooks")
+	timeout := fs.Duration("timeout", 20*time.Minute,
+		"How long to wait for each instance to be replaced")
+	restore := fs.String("restore", "",
+		"The path of the output of an earlier evacuation. The zone is added back to the autoscaling group, rather than evacuated")
+	fs.Parse(args)
+
+	s := newCluster()
+	if *restore != "" {
+		buf, err := os.ReadFile(*restore)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		result := &ec2cluster.EvacuateResult{}
+		if err := json.Unmarshal(buf, result); err != nil {
+			log.Fatalf("ERROR: %s: %s", *restore, err)
+		}
+		if err := s.RestoreAZ(result); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		return
+	}
+	if *zone == "" {
+		log.Fatalf("ERROR: --zone is required")
+	}
+
+	result, err := s.EvacuateAZ(*zone, ec2cluster.EvacuateOptions{
+		Detach:  *detach,
+		Timeout: *timeout,
+		Progress: func(p ec2cluster.EvacuateProgress) {
+			log.Printf("%s %s %s (%d remaining)", p.Step, p.InstanceID, p.ReplacementID, p.Remaining)
+		},
+	})
+	if result != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
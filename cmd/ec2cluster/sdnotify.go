@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, such as "READY=1", to the service manager using the
+// sd_notify protocol. It does nothing unless the process was started by
+// systemd with NOTIFY_SOCKET set, i.e. with Type=notify or WatchdogSec.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns how often the service manager expects to be
+// told the process is alive, which is half the watchdog timeout, or zero if
+// the watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdWatchdog returns a function that sends WATCHDOG=1 to the service
+// manager, for use as the ProgressCallback of the cluster, so that the
+// service manager restarts the watcher if it stops making progress rather
+// than only if the process hangs. It returns nil if the watchdog is not
+// enabled.
+func sdWatchdog() func() {
+	if sdWatchdogInterval() == 0 {
+		return nil
+	}
+	return func() {
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("ERROR: sd_notify: %s", err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package ec2cluster
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Deduplicator records which lifecycle actions have been handled, so that a
+// message that SQS delivers more than once, for example because
+// CompleteLifecycleAction succeeded but deleting the message failed, is not
+// passed to the callback again. Actions are identified by their
+// LifecycleActionToken.
+//
+// An action is claimed before its callback is invoked and released again if
+// the callback fails, so that it can be retried. If the process stops while
+// a callback runs, the claim remains and the action is not retried by any
+// consumer sharing the Deduplicator; the lifecycle hook then times out with
+// its default result.
+type Deduplicator interface {
+	// Claim records token and returns true, or returns false if token has
+	// already been claimed.
+	Claim(token string) (bool, error)
+
+	// Release removes the claim on token.
+	Release(token string) error
+}
+
+// NewMemoryDeduplicator returns a Deduplicator that remembers up to size
+// tokens in memory, forgetting the oldest first. It deduplicates the
+// messages received by a single process. A size of less than one is taken
+// as one.
+func NewMemoryDeduplicator(size int) Deduplicator {
+	if size < 1 {
+		size = 1
+	}
+	return &memoryDeduplicator{tokens: newMessageSet(size)}
+}
+
+type memoryDeduplicator struct {
+	mu     sync.Mutex
+	tokens *messageSet
+}
+
+func (d *memoryDeduplicator) Claim(token string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tokens.add(token), nil
+}
+
+func (d *memoryDeduplicator) Release(token string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tokens.remove(token)
+	return nil
+}
+
+// DynamoDBDeduplicator is a Deduplicator that records tokens in a DynamoDB
+// table, so that the members of a cluster consuming the same queue
+// deduplicate each other's messages. The table must have a string hash key
+// named `LifecycleActionToken`. If TTL is set, each item also has an
+// `ExpiresAt` attribute, which can be used as the table's time to live
+// attribute.
+type DynamoDBDeduplicator struct {
+	Cluster   *Cluster
+	TableName string
+
+	// TTL, if not zero, is how long each token is kept. It should be longer
+	// than the heartbeat timeout of the lifecycle hooks.
+	TTL time.Duration
+}
+
+func (d *DynamoDBDeduplicator) Claim(token string) (bool, error) {
+	item := map[string]*dynamodb.AttributeValue{
+		"LifecycleActionToken": {S: aws.String(token)},
+	}
+	if d.TTL != 0 {
+		expiresAt := time.Now().Add(d.TTL).Unix()
+		item["ExpiresAt"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expiresAt, 10))}
+	}
+	_, err := d.Cluster.dynamoDBClient().PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(d.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(LifecycleActionToken)"),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *DynamoDBDeduplicator) Release(token string) error {
+	_, err := d.Cluster.dynamoDBClient().DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LifecycleActionToken": {S: aws.String(token)},
+		},
+	})
+	return err
+}
+
+// claimLifecycleAction claims m with Deduplicator and returns true if its
+// callback should be invoked. Actions without a token are always handled.
+func (s *Cluster) claimLifecycleAction(m *LifecycleMessage) (bool, error) {
+	if s.Deduplicator == nil || m.LifecycleActionToken == "" {
+		return true, nil
+	}
+	return s.Deduplicator.Claim(m.LifecycleActionToken)
+}
+
+// releaseLifecycleAction releases the claim on m, after its callback failed,
+// so that it is handled when it is delivered again.
+func (s *Cluster) releaseLifecycleAction(m *LifecycleMessage) {
+	if s.Deduplicator == nil || m.LifecycleActionToken == "" {
+		return
+	}
+	if err := s.Deduplicator.Release(m.LifecycleActionToken); err != nil {
+		log.Printf("ERROR: cannot release lifecycle action %s: %s", m.LifecycleActionToken, err)
+	}
+}
@@ -0,0 +1,83 @@
+package ec2cluster
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type DedupTest struct {
+}
+
+var _ = Suite(&DedupTest{})
+
+func (s *DedupTest) TestRedeliveredMessage(c *C) {
+	message := func(receiptHandle string) *sqs.Message {
+		return &sqs.Message{
+			ReceiptHandle: aws.String(receiptHandle),
+			Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleActionToken":"token"}`),
+		}
+	}
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{
+			{Messages: []*sqs.Message{message("first")}},
+			{Messages: []*sqs.Message{message("second")}},
+			{Messages: []*sqs.Message{message("third")}},
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc, Deduplicator: NewMemoryDeduplicator(10)}
+
+	calls := 0
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		calls++
+		if calls == 1 {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	// The failed first delivery is retried; the third is a duplicate.
+	c.Assert(calls, Equals, 2)
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(sqsSvc.deleted, HasLen, 2)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "second")
+	c.Assert(*sqsSvc.deleted[1].Entries[0].ReceiptHandle, Equals, "third")
+}
+
+func (s *DedupTest) TestDynamoDBDeduplicator(c *C) {
+	d := &DynamoDBDeduplicator{Cluster: &Cluster{DynamoDB: &fakeDynamoDB{}}, TableName: "actions"}
+
+	claimed, err := d.Claim("token")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, true)
+
+	claimed, err = d.Claim("token")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, false)
+
+	c.Assert(d.Release("token"), IsNil)
+	claimed, err = d.Claim("token")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, true)
+}
+
+func (s *DedupTest) TestMemoryDeduplicatorSize(c *C) {
+	// A size of zero remembers the last token rather than panicking.
+	d := NewMemoryDeduplicator(0)
+	claimed, err := d.Claim("first")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, true)
+	claimed, err = d.Claim("first")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, false)
+	claimed, err = d.Claim("second")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, true)
+	claimed, err = d.Claim("first")
+	c.Assert(err, IsNil)
+	c.Assert(claimed, Equals, true)
+}
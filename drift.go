@@ -0,0 +1,122 @@
+package ec2cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// DriftedInstance is an instance of the autoscaling group that was not
+// launched from the group's current configuration.
+type DriftedInstance struct {
+	InstanceID string
+
+	// Reason describes how the instance differs, for example
+	// "launch template version 3, want 4".
+	Reason string
+}
+
+// DriftedInstances returns the instances of the autoscaling group of the
+// current instance whose launch template version, launch configuration or
+// AMI differs from the group's current configuration, as returned by
+// LaunchSpec. Replacing these instances, for example with ReplaceInstance,
+// brings the group up to date.
+func (s *Cluster) DriftedInstances() ([]DriftedInstance, error) {
+	// Look up the group again so that configuration changes and new
+	// instances are seen.
+	s.autoScalingGroup = nil
+	asg, err := s.AutoscalingGroup()
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
+		return nil, ErrNotInAutoscalingGroup
+	}
+	spec, err := s.LaunchSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	imageIDs := map[string]string{}
+	for _, member := range members {
+		imageIDs[aws.StringValue(member.InstanceId)] = aws.StringValue(member.ImageId)
+	}
+	return driftedInstances(asg, spec, imageIDs), nil
+}
+
+// driftedInstances compares the instances of group with spec. imageIDs maps
+// instance IDs to the AMI they are running.
+func driftedInstances(group *autoscaling.Group, spec *LaunchSpec, imageIDs map[string]string) []DriftedInstance {
+	rv := []DriftedInstance{}
+	for _, instance := range group.Instances {
+		instanceID := aws.StringValue(instance.InstanceId)
+		if reason := driftReason(instance, spec, imageIDs[instanceID]); reason != "" {
+			rv = append(rv, DriftedInstance{InstanceID: instanceID, Reason: reason})
+		}
+	}
+	return rv
+}
+
+func driftReason(instance *autoscaling.Instance, spec *LaunchSpec, imageID string) string {
+	if spec.LaunchTemplateID != "" {
+		launchTemplate := instance.LaunchTemplate
+		if launchTemplate == nil {
+			return fmt.Sprintf("no launch template, want %s", spec.LaunchTemplateName)
+		}
+		if id := aws.StringValue(launchTemplate.LaunchTemplateId); id != "" && id != spec.LaunchTemplateID {
+			return fmt.Sprintf("launch template %s, want %s", id, spec.LaunchTemplateID)
+		}
+		if version := aws.StringValue(launchTemplate.Version); version != spec.LaunchTemplateVersion {
+			return fmt.Sprintf("launch template version %s, want %s", version, spec.LaunchTemplateVersion)
+		}
+	} else if name := aws.StringValue(instance.LaunchConfigurationName); name != spec.LaunchConfigurationName {
+		return fmt.Sprintf("launch configuration %s, want %s", name, spec.LaunchConfigurationName)
+	}
+
+	// Templates may name the AMI indirectly, for example with
+	// `resolve:ssm:`, in which case it cannot be compared.
+	if strings.HasPrefix(spec.ImageID, "ami-") && imageID != "" && imageID != spec.ImageID {
+		return fmt.Sprintf("image %s, want %s", imageID, spec.ImageID)
+	}
+	return ""
+}
+
+// DriftCallback is a function that is invoked by WatchDriftedInstances
+// with the instances that have drifted. If the function returns an error,
+// watching stops and the error is returned from WatchDriftedInstances.
+type DriftCallback func(drifted []DriftedInstance) error
+
+// WatchDriftedInstances polls DriftedInstances every `interval` and invokes
+// cb whenever the drifted instances change. The callback is always invoked
+// for the first poll. WatchDriftedInstances runs until ctx is cancelled or
+// an error occurs.
+func (s *Cluster) WatchDriftedInstances(ctx context.Context, interval time.Duration, cb DriftCallback) error {
+	var last []DriftedInstance
+	for {
+		drifted, err := s.DriftedInstances()
+		if err != nil {
+			return err
+		}
+		if last == nil || !reflect.DeepEqual(drifted, last) {
+			if err := cb(drifted); err != nil {
+				return err
+			}
+			last = drifted
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.jitter(interval)):
+		}
+	}
+}
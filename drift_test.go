@@ -0,0 +1,59 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	. "gopkg.in/check.v1"
+)
+
+type DriftTest struct {
+}
+
+var _ = Suite(&DriftTest{})
+
+func (s *DriftTest) TestLaunchTemplateDrift(c *C) {
+	spec := &LaunchSpec{
+		LaunchTemplateID:      "lt-0123456789abcdef0",
+		LaunchTemplateName:    "example",
+		LaunchTemplateVersion: "4",
+		ImageID:               "ami-00000004",
+	}
+	launchTemplate := func(version string) *autoscaling.LaunchTemplateSpecification {
+		return &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateId: aws.String("lt-0123456789abcdef0"),
+			Version:          aws.String(version),
+		}
+	}
+	group := &autoscaling.Group{
+		Instances: []*autoscaling.Instance{
+			{InstanceId: aws.String("i-00000001"), LaunchTemplate: launchTemplate("4")},
+			{InstanceId: aws.String("i-00000002"), LaunchTemplate: launchTemplate("3")},
+			{InstanceId: aws.String("i-00000003"), LaunchTemplate: launchTemplate("4")},
+			{InstanceId: aws.String("i-00000004"), LaunchConfigurationName: aws.String("example-1")},
+		},
+	}
+	imageIDs := map[string]string{
+		"i-00000001": "ami-00000004",
+		"i-00000002": "ami-00000003",
+		"i-00000003": "ami-00000003",
+	}
+
+	c.Assert(driftedInstances(group, spec, imageIDs), DeepEquals, []DriftedInstance{
+		{InstanceID: "i-00000002", Reason: "launch template version 3, want 4"},
+		{InstanceID: "i-00000003", Reason: "image ami-00000003, want ami-00000004"},
+		{InstanceID: "i-00000004", Reason: "no launch template, want example"},
+	})
+}
+
+func (s *DriftTest) TestLaunchConfigurationDrift(c *C) {
+	spec := &LaunchSpec{LaunchConfigurationName: "example-2", ImageID: "ami-00000002"}
+	group := &autoscaling.Group{
+		Instances: []*autoscaling.Instance{
+			{InstanceId: aws.String("i-00000001"), LaunchConfigurationName: aws.String("example-2")},
+			{InstanceId: aws.String("i-00000002"), LaunchConfigurationName: aws.String("example-1")},
+		},
+	}
+	c.Assert(driftedInstances(group, spec, map[string]string{}), DeepEquals, []DriftedInstance{
+		{InstanceID: "i-00000002", Reason: "launch configuration example-1, want example-2"},
+	})
+}
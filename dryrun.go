@@ -0,0 +1,51 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// defaultDryRunVisibilityTimeout is used when DryRunVisibilityTimeout is
+// zero. It is the default heartbeat timeout of a lifecycle hook, so that a
+// dry run usually receives each lifecycle action once.
+const defaultDryRunVisibilityTimeout = time.Hour
+
+// dryRunLifecycleAction invokes cb for the lifecycle action m, received in
+// messageWrapper, without completing the action. It returns false if cb
+// failed, so that the message is delivered again to retry it.
+func (s *Cluster) dryRunLifecycleAction(ctx context.Context, sqsSvc sqsiface.SQSAPI, queueURL string, messageWrapper *sqs.Message, pending []*sqs.Message, m *LifecycleMessage, cb LifecycleEventContextCallback) bool {
+	shouldContinue, err := s.runCallback(ctx, sqsSvc, queueURL, messageWrapper, pending, m, cb)
+	switch err {
+	case errEventCompleted:
+		return true
+	case errEventReleased:
+		return false
+	}
+	if err != nil {
+		log.Printf("dry run: %s: %s", m.EC2InstanceID, err)
+		s.record(m, "", err, nil)
+		return false
+	}
+	result := ResultContinue
+	if !shouldContinue {
+		result = ResultAbandon
+	}
+	log.Printf("dry run: %s: would complete lifecycle action with %s", m.EC2InstanceID, result)
+	s.record(m, result, nil, nil)
+	return true
+}
+
+// hideMessages extends the visibility timeout of messages to
+// DryRunVisibilityTimeout, in place of removing them from the queue.
+func (s *Cluster) hideMessages(sqsSvc sqsiface.SQSAPI, queueURL string, messages []*sqs.Message) {
+	timeout := s.DryRunVisibilityTimeout
+	if timeout == 0 {
+		timeout = defaultDryRunVisibilityTimeout
+	}
+	changeMessageVisibility(sqsSvc, queueURL, messages, aws.Int64(int64(timeout/time.Second)))
+}
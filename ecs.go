@@ -0,0 +1,102 @@
+package ec2cluster
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ECSDrainer sets the ECS container instance of a terminating EC2 instance
+// to DRAINING and waits for its tasks to stop before the lifecycle action is
+// continued, so that ECS can reschedule the tasks elsewhere first.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents.
+type ECSDrainer struct {
+	Cluster *Cluster
+
+	// ECSCluster is the name or ARN of the ECS cluster that the instances
+	// are registered with.
+	ECSCluster string
+
+	// Timeout is how long to wait for running tasks to stop. When it
+	// elapses, the lifecycle action is continued anyway. If zero, five
+	// minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often the running task count is checked. If
+	// zero, ten seconds is used.
+	PollInterval time.Duration
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that drains the container
+// instance of each terminating instance. Other events are continued
+// immediately.
+func (d *ECSDrainer) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	if m.LifecycleTransition != TransitionTerminating {
+		return true, nil
+	}
+	err := d.Drain(m.EC2InstanceID)
+	if err == ErrTimeout {
+		log.Printf("timed out draining ECS tasks from %s, continuing", m.EC2InstanceID)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Drain sets the container instance for the EC2 instance to DRAINING and
+// waits until it has no running tasks. If the instance is not registered
+// with the ECS cluster, Drain returns nil.
+func (d *ECSDrainer) Drain(instanceID string) error {
+	ecsSvc := d.Cluster.ecsClient()
+	resp, err := ecsSvc.ListContainerInstances(&ecs.ListContainerInstancesInput{
+		Cluster: aws.String(d.ECSCluster),
+		Filter:  aws.String(fmt.Sprintf("ec2InstanceId == %s", instanceID)),
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.ContainerInstanceArns) == 0 {
+		log.Printf("%s is not registered with ECS cluster %s", instanceID, d.ECSCluster)
+		return nil
+	}
+	containerInstanceArns := resp.ContainerInstanceArns
+
+	_, err = ecsSvc.UpdateContainerInstancesState(&ecs.UpdateContainerInstancesStateInput{
+		Cluster:            aws.String(d.ECSCluster),
+		ContainerInstances: containerInstanceArns,
+		Status:             aws.String(ecs.ContainerInstanceStatusDraining),
+	})
+	if err != nil {
+		return err
+	}
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	pollInterval := d.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 10 * time.Second
+	}
+	return waitUntil(timeout, pollInterval, func() (bool, error) {
+		resp, err := ecsSvc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(d.ECSCluster),
+			ContainerInstances: containerInstanceArns,
+		})
+		if err != nil {
+			return false, err
+		}
+		for _, containerInstance := range resp.ContainerInstances {
+			if aws.Int64Value(containerInstance.RunningTasksCount) > 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,78 @@
+package ec2cluster
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ECSTest struct {
+}
+
+var _ = Suite(&ECSTest{})
+
+const containerInstanceArn = "arn:aws:ecs:us-east-1:012345678901:container-instance/example/0123456789abcdef"
+
+func ecsDrainer(ecsSvc *fakeECS) *ECSDrainer {
+	ecsSvc.containerInstanceArns = map[string]string{"i-00000001": containerInstanceArn}
+	return &ECSDrainer{
+		Cluster:      &Cluster{ECS: ecsSvc},
+		ECSCluster:   "example",
+		Timeout:      time.Second,
+		PollInterval: time.Millisecond,
+	}
+}
+
+func (s *ECSTest) TestDrain(c *C) {
+	ecsSvc := &fakeECS{runningTasks: []int64{2, 1, 0}}
+	d := ecsDrainer(ecsSvc)
+
+	shouldContinue, err := d.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(ecsSvc.listed, DeepEquals, []string{"ec2InstanceId == i-00000001"})
+	c.Assert(ecsSvc.draining, DeepEquals, []string{containerInstanceArn})
+
+	// The instance is continued only once its tasks have stopped.
+	c.Assert(ecsSvc.runningTasks, DeepEquals, []int64{0})
+}
+
+func (s *ECSTest) TestDrainTimeout(c *C) {
+	ecsSvc := &fakeECS{runningTasks: []int64{1}}
+	d := ecsDrainer(ecsSvc)
+	d.Timeout = 10 * time.Millisecond
+
+	c.Assert(d.Drain("i-00000001"), Equals, ErrTimeout)
+
+	// The instance is going away regardless, so the action is continued.
+	shouldContinue, err := d.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+}
+
+func (s *ECSTest) TestDrainUnregistered(c *C) {
+	ecsSvc := &fakeECS{}
+	d := ecsDrainer(ecsSvc)
+
+	c.Assert(d.Drain("i-00000002"), IsNil)
+	c.Assert(ecsSvc.draining, HasLen, 0)
+}
+
+func (s *ECSTest) TestLaunching(c *C) {
+	ecsSvc := &fakeECS{}
+	d := ecsDrainer(ecsSvc)
+
+	shouldContinue, err := d.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionLaunching,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(ecsSvc.listed, HasLen, 0)
+}
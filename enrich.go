@@ -0,0 +1,62 @@
+package ec2cluster
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// EnrichedLifecycleEvent is a lifecycle action together with details of the
+// instance that it concerns.
+type EnrichedLifecycleEvent struct {
+	*LifecycleMessage
+
+	// Instance is the instance as returned by DescribeInstances.
+	Instance *ec2.Instance
+
+	PrivateIPAddress string
+	IPv6Addresses    []string
+
+	// Address is the address that peers should use to reach the instance,
+	// according to AddressPreference.
+	Address string
+
+	AvailabilityZone string
+	InstanceType     string
+	Tags             map[string]string
+}
+
+// EnrichedLifecycleEventCallback is like LifecycleEventContextCallback, but
+// is passed an EnrichedLifecycleEvent.
+type EnrichedLifecycleEventCallback func(ctx context.Context, e *EnrichedLifecycleEvent) (shouldContinue bool, err error)
+
+// Enrich returns a callback, for use with WatchLifecycleEventsContext and
+// the other watchers, that describes the instance of each lifecycle action
+// and invokes cb with the details. If the instance cannot be described, the
+// error is returned so that the action is delivered again.
+func (s *Cluster) Enrich(cb EnrichedLifecycleEventCallback) LifecycleEventContextCallback {
+	return func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		instance, err := s.describeInstance(m.EC2InstanceID)
+		if err != nil {
+			return false, err
+		}
+
+		e := &EnrichedLifecycleEvent{
+			LifecycleMessage: m,
+			Instance:         instance,
+			PrivateIPAddress: aws.StringValue(instance.PrivateIpAddress),
+			IPv6Addresses:    InstanceIPv6Addresses(instance),
+			Address:          s.Address(instance),
+			InstanceType:     aws.StringValue(instance.InstanceType),
+			Tags:             map[string]string{},
+		}
+		if instance.Placement != nil {
+			e.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		for _, tag := range instance.Tags {
+			e.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return cb(ctx, e)
+	}
+}
@@ -0,0 +1,42 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type EnrichTest struct {
+}
+
+var _ = Suite(&EnrichTest{})
+
+func (s *EnrichTest) TestEnrich(c *C) {
+	instance := fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now())
+	instance.InstanceType = aws.String("m5.large")
+	cluster := &Cluster{EC2: &fakeEC2{instances: []*ec2.Instance{instance}}}
+
+	var event *EnrichedLifecycleEvent
+	cb := cluster.Enrich(func(ctx context.Context, e *EnrichedLifecycleEvent) (bool, error) {
+		event = e
+		return true, nil
+	})
+	shouldContinue, err := cb(context.Background(), &LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(event.LifecycleTransition, Equals, TransitionTerminating)
+	c.Assert(event.PrivateIPAddress, Equals, "10.0.0.1")
+	c.Assert(event.AvailabilityZone, Equals, "us-east-1a")
+	c.Assert(event.InstanceType, Equals, "m5.large")
+	c.Assert(event.Tags, DeepEquals, map[string]string{"app": "example"})
+
+	// Actions for instances that cannot be described are retried.
+	_, err = cb(context.Background(), &LifecycleMessage{EC2InstanceID: "i-00000002"})
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,133 @@
+package ec2cluster
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ErrNotFound matches, with errors.Is, every NotFoundError.
+var ErrNotFound = errors.New("not found")
+
+// ErrNoRunningMembers is returned by Leader when the cluster has no running
+// members.
+var ErrNoRunningMembers = errors.New("cluster has no running members")
+
+// ErrInvalidMessage is returned, wrapping the decoding error, when a message
+// received from a lifecycle event queue cannot be decoded.
+var ErrInvalidMessage = errors.New("cannot unmarshal event")
+
+// ErrMetadataUnavailable is returned when the EC2 metadata service responds
+// with an error status.
+var ErrMetadataUnavailable = errors.New("cannot fetch instance metadata")
+
+// NotFoundError is returned when a resource that the package looks up, such
+// as an instance or an autoscaling group, does not exist.
+type NotFoundError struct {
+	// Kind is the kind of resource, such as `instance`.
+	Kind string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return "cannot find " + e.Kind + " " + e.ID
+}
+
+// Is returns true for ErrNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// APIError is returned when an AWS API call fails. It wraps the error from
+// the AWS SDK, which is an awserr.Error, with the name of the operation.
+type APIError struct {
+	Op  string
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// wrapAPIError wraps err in an APIError for the operation op if it is an
+// error from the AWS SDK. Other errors are returned unchanged.
+func wrapAPIError(op string, err error) error {
+	if _, ok := err.(awserr.Error); !ok {
+		return err
+	}
+	return &APIError{Op: op, Err: err}
+}
+
+// IsThrottle returns true if err, or an error that it wraps, is an AWS
+// error reporting that requests are being throttled. Such requests can be
+// retried after backing off.
+func IsThrottle(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	if request.IsErrorThrottle(awsErr) {
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	return errors.As(err, &requestFailure) && requestFailure.StatusCode() == http.StatusTooManyRequests
+}
+
+// IsNotFound returns true if err reports that a resource does not exist:
+// ErrNotFound, ErrLifecycleHookNotFound, ErrStateNotFound, or an AWS error
+// such as `InvalidInstanceID.NotFound` or a 404 response.
+func IsNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrLifecycleHookNotFound) || errors.Is(err, ErrStateNotFound) {
+		return true
+	}
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch code := awsErr.Code(); {
+	case strings.Contains(code, "NotFound"),
+		code == sqs.ErrCodeQueueDoesNotExist,
+		code == s3.ErrCodeNoSuchKey,
+		code == s3.ErrCodeNoSuchBucket:
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	return errors.As(err, &requestFailure) && requestFailure.StatusCode() == http.StatusNotFound
+}
+
+// permissionErrorCodes are the codes of AWS errors that report missing or
+// invalid credentials or permissions.
+var permissionErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"AuthFailure":                 true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnauthorizedOperation":       true,
+	"UnrecognizedClientException": true,
+}
+
+// IsPermission returns true if err is an AWS error reporting that the
+// caller's credentials are missing, invalid or do not allow the operation.
+// Such errors are not resolved by retrying.
+func IsPermission(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	if permissionErrorCodes[awsErr.Code()] {
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	return errors.As(err, &requestFailure) && requestFailure.StatusCode() == http.StatusForbidden
+}
@@ -0,0 +1,61 @@
+package ec2cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	. "gopkg.in/check.v1"
+)
+
+type ErrorsTest struct {
+}
+
+var _ = Suite(&ErrorsTest{})
+
+func (s *ErrorsTest) TestClassification(c *C) {
+	throttle := wrapAPIError("DescribeInstances", awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))
+	notFound := wrapAPIError("DescribeInstances", awserr.New("InvalidInstanceID.NotFound", "The instance ID 'i-00000001' does not exist", nil))
+	denied := wrapAPIError("DescribeInstances", awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+	forbidden := awserr.NewRequestFailure(awserr.New("Forbidden", "Forbidden", nil), 403, "")
+	other := errors.New("connection reset by peer")
+
+	c.Assert(throttle, ErrorMatches, "DescribeInstances: RequestLimitExceeded: Request limit exceeded.")
+	apiErr := &APIError{}
+	c.Assert(errors.As(throttle, &apiErr), Equals, true)
+	c.Assert(apiErr.Op, Equals, "DescribeInstances")
+
+	c.Assert(IsThrottle(throttle), Equals, true)
+	c.Assert(IsThrottle(notFound), Equals, false)
+	c.Assert(IsThrottle(other), Equals, false)
+
+	c.Assert(IsNotFound(notFound), Equals, true)
+	c.Assert(IsNotFound(&NotFoundError{Kind: "instance", ID: "i-00000001"}), Equals, true)
+	c.Assert(IsNotFound(fmt.Errorf("bootstrap: %w", ErrLifecycleHookNotFound)), Equals, true)
+	c.Assert(IsNotFound(throttle), Equals, false)
+	c.Assert(IsNotFound(other), Equals, false)
+
+	c.Assert(IsPermission(denied), Equals, true)
+	c.Assert(IsPermission(forbidden), Equals, true)
+	c.Assert(IsPermission(throttle), Equals, false)
+	c.Assert(IsPermission(other), Equals, false)
+
+	// Errors that are not from the AWS SDK are not wrapped.
+	c.Assert(wrapAPIError("ReceiveMessage", other), Equals, other)
+}
+
+func (s *ErrorsTest) TestNotFoundError(c *C) {
+	cluster := &Cluster{AutoScaling: &fakeAutoScaling{
+		groups: []*autoscaling.Group{{AutoScalingGroupName: aws.String("example-a")}},
+	}}
+	_, err := cluster.describeAutoscalingGroup("example-b")
+	c.Assert(errors.Is(err, ErrNotFound), Equals, true)
+	notFoundErr := &NotFoundError{}
+	c.Assert(errors.As(err, &notFoundErr), Equals, true)
+	c.Assert(*notFoundErr, Equals, NotFoundError{Kind: "autoscaling group", ID: "example-b"})
+
+	_, _, err = parseMessage("not json")
+	c.Assert(errors.Is(err, ErrInvalidMessage), Equals, true)
+}
@@ -0,0 +1,378 @@
+package ec2cluster
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Steps reported by EvacuateAZ.
+const (
+	EvacuateStepSuspendingRebalance   = "SuspendingRebalance"
+	EvacuateStepRemovingZone          = "RemovingZone"
+	EvacuateStepProtectingPeers       = "ProtectingPeers"
+	EvacuateStepRemovingInstance      = "RemovingInstance"
+	EvacuateStepWaitingForReplacement = "WaitingForReplacement"
+	EvacuateStepReplacementInService  = "ReplacementInService"
+	EvacuateStepUnprotectingPeers     = "UnprotectingPeers"
+)
+
+// EvacuateProgress describes a step of EvacuateAZ. InstanceID and
+// ReplacementID are set for the steps that cycle an instance, and Remaining
+// is the number of instances in the zone that are still to be removed.
+type EvacuateProgress struct {
+	Step          string
+	InstanceID    string
+	ReplacementID string
+	Remaining     int
+}
+
+// EvacuateOptions controls how EvacuateAZ removes instances.
+type EvacuateOptions struct {
+	// Detach, if true, detaches each instance from the autoscaling group
+	// and terminates it once its replacement is in service. Otherwise each
+	// instance is terminated while it is still a member of the group, which
+	// runs the group's termination lifecycle hooks.
+	Detach bool
+
+	// Timeout is how long to wait, for each instance, for the instance to
+	// leave the group and for its replacement to reach the InService state.
+	// If zero, twenty minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often the autoscaling group is checked while
+	// waiting. If zero, fifteen seconds is used.
+	PollInterval time.Duration
+
+	// Progress, if not nil, is invoked as each step begins.
+	Progress func(p EvacuateProgress)
+}
+
+// EvacuateResult describes what EvacuateAZ changed, so that the zone can be
+// restored with RestoreAZ once it recovers.
+type EvacuateResult struct {
+	// AvailabilityZone is the zone that was evacuated.
+	AvailabilityZone string `json:"availability_zone"`
+
+	// Subnets are the subnets in the zone that were removed from the
+	// group. It is empty for groups that are not in a VPC, which list their
+	// availability zones instead.
+	Subnets []string `json:"subnets,omitempty"`
+
+	// Replacements maps the ID of each instance removed from the zone to
+	// the ID of its replacement.
+	Replacements map[string]string `json:"replacements"`
+
+	// RebalanceWasSuspended is true if the AZRebalance process was already
+	// suspended before EvacuateAZ, in which case RestoreAZ leaves it
+	// suspended.
+	RebalanceWasSuspended bool `json:"rebalance_was_suspended,omitempty"`
+}
+
+// EvacuateAZ moves the autoscaling group out of an availability zone, for
+// example while the zone is degraded. It:
+//
+//   - suspends the AZRebalance process, so that the group does not move
+//     instances between zones by itself;
+//   - removes the zone, or its subnets, from the group so that
+//     replacements are launched in the other zones;
+//   - protects the members in the other zones from scale in, and clears
+//     the protection of the members in the zone, so that a scale in while
+//     the zone is evacuated removes instances from the zone;
+//   - removes the members in the zone one at a time. Before moving on to
+//     the next, it waits for the instance to leave the group, which is once
+//     its termination lifecycle hooks have completed, and for its
+//     replacement to be in service.
+//
+// Before EvacuateAZ returns, the protection of the other members is cleared
+// and the members of the zone that have not been removed are protected
+// again. The AZRebalance process stays suspended and the zone stays removed
+// until RestoreAZ is called with the result, which is returned whenever
+// the zone has been removed, even if EvacuateAZ then fails. EvacuateAZ
+// fails without changing the group if the zone is its only availability
+// zone.
+func (s *Cluster) EvacuateAZ(availabilityZone string, opts EvacuateOptions) (*EvacuateResult, error) {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := func(p EvacuateProgress) {
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	group, err := s.describeAutoscalingGroup(groupName)
+	if err != nil {
+		return nil, err
+	}
+	result := &EvacuateResult{AvailabilityZone: availabilityZone, Replacements: map[string]string{}}
+	for _, process := range group.SuspendedProcesses {
+		if aws.StringValue(process.ProcessName) == ProcessAZRebalance {
+			result.RebalanceWasSuspended = true
+		}
+	}
+
+	progress(EvacuateProgress{Step: EvacuateStepSuspendingRebalance})
+	if err := s.SuspendProcesses(ProcessAZRebalance); err != nil {
+		return nil, err
+	}
+
+	progress(EvacuateProgress{Step: EvacuateStepRemovingZone})
+	result.Subnets, err = s.removeAvailabilityZone(group, availabilityZone)
+	if err != nil {
+		if !result.RebalanceWasSuspended {
+			if err := s.ResumeProcesses(ProcessAZRebalance); err != nil {
+				log.Printf("ERROR: ResumeProcesses: %s", err)
+			}
+		}
+		return nil, err
+	}
+
+	existing := map[string]bool{}
+	evacuating := []string{}
+	unprotect := []string{}
+	peers := []string{}
+	for _, instance := range group.Instances {
+		instanceID := *instance.InstanceId
+		existing[instanceID] = true
+		state := aws.StringValue(instance.LifecycleState)
+		if aws.StringValue(instance.AvailabilityZone) == availabilityZone {
+			if strings.HasPrefix(state, "Terminating") || state == autoscaling.LifecycleStateTerminated {
+				continue
+			}
+			evacuating = append(evacuating, instanceID)
+			if aws.BoolValue(instance.ProtectedFromScaleIn) {
+				unprotect = append(unprotect, instanceID)
+			}
+			continue
+		}
+		if state == autoscaling.LifecycleStateInService && !aws.BoolValue(instance.ProtectedFromScaleIn) {
+			peers = append(peers, instanceID)
+		}
+	}
+
+	// The protection is undone when EvacuateAZ returns, even if it fails
+	// part way: the peers are unprotected, and the members of the zone that
+	// are still in the group are protected again.
+	removed := map[string]bool{}
+	defer func() {
+		progress(EvacuateProgress{Step: EvacuateStepUnprotectingPeers})
+		if err := s.setInstanceProtection(groupName, peers, false); err != nil {
+			log.Printf("ERROR: SetInstanceProtection: %s", err)
+		}
+		reprotect := []string{}
+		for _, instanceID := range unprotect {
+			if !removed[instanceID] {
+				reprotect = append(reprotect, instanceID)
+			}
+		}
+		if err := s.setInstanceProtection(groupName, reprotect, true); err != nil {
+			log.Printf("ERROR: SetInstanceProtection: %s", err)
+		}
+	}()
+
+	progress(EvacuateProgress{Step: EvacuateStepProtectingPeers, Remaining: len(evacuating)})
+	if err := s.setInstanceProtection(groupName, unprotect, false); err != nil {
+		return result, err
+	}
+	if err := s.setInstanceProtection(groupName, peers, true); err != nil {
+		return result, err
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 20 * time.Minute
+	}
+	pollInterval := opts.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 15 * time.Second
+	}
+
+	for i, instanceID := range evacuating {
+		remaining := len(evacuating) - i
+		progress(EvacuateProgress{Step: EvacuateStepRemovingInstance, InstanceID: instanceID, Remaining: remaining})
+		if err := s.evacuateInstance(groupName, instanceID, opts.Detach); err != nil {
+			return result, err
+		}
+		removed[instanceID] = true
+
+		progress(EvacuateProgress{Step: EvacuateStepWaitingForReplacement, InstanceID: instanceID, Remaining: remaining})
+		replacementID := ""
+		err := waitUntil(timeout, pollInterval, func() (bool, error) {
+			group, err := s.describeAutoscalingGroup(groupName)
+			if err != nil {
+				return false, err
+			}
+			member := false
+			for _, instance := range group.Instances {
+				if *instance.InstanceId == instanceID {
+					member = true
+				}
+				if replacementID == "" && !existing[*instance.InstanceId] &&
+					aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+					replacementID = *instance.InstanceId
+				}
+			}
+			return !member && replacementID != "", nil
+		})
+		if err != nil {
+			return result, err
+		}
+		existing[replacementID] = true
+		result.Replacements[instanceID] = replacementID
+
+		if opts.Detach {
+			_, err := s.ec2Client().TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{aws.String(instanceID)},
+			})
+			if err != nil {
+				return result, wrapAPIError("TerminateInstances", err)
+			}
+		}
+
+		// The replacement is protected along with the other peers, so that
+		// a scale in still removes the instances left in the zone first.
+		peers = append(peers, replacementID)
+		if err := s.setInstanceProtection(groupName, []string{replacementID}, true); err != nil {
+			return result, err
+		}
+		progress(EvacuateProgress{Step: EvacuateStepReplacementInService, InstanceID: instanceID, ReplacementID: replacementID, Remaining: remaining - 1})
+	}
+	return result, nil
+}
+
+// evacuateInstance removes an instance from the group without reducing its
+// desired capacity. If detach is true, the instance is detached and left
+// running, for the caller to terminate.
+func (s *Cluster) evacuateInstance(groupName, instanceID string, detach bool) error {
+	autoscalingSvc := s.autoscalingClient()
+	if detach {
+		_, err := autoscalingSvc.DetachInstances(&autoscaling.DetachInstancesInput{
+			AutoScalingGroupName:           aws.String(groupName),
+			InstanceIds:                    []*string{aws.String(instanceID)},
+			ShouldDecrementDesiredCapacity: aws.Bool(false),
+		})
+		return wrapAPIError("DetachInstances", err)
+	}
+	_, err := autoscalingSvc.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String(instanceID),
+		ShouldDecrementDesiredCapacity: aws.Bool(false),
+	})
+	return wrapAPIError("TerminateInstanceInAutoScalingGroup", err)
+}
+
+// removeAvailabilityZone updates the group so that it no longer launches
+// instances in the zone, and returns the subnets that were removed.
+func (s *Cluster) removeAvailabilityZone(group *autoscaling.Group, availabilityZone string) ([]string, error) {
+	input := &autoscaling.UpdateAutoScalingGroupInput{AutoScalingGroupName: group.AutoScalingGroupName}
+	removed := []string{}
+	if vpcZoneIdentifier := aws.StringValue(group.VPCZoneIdentifier); vpcZoneIdentifier != "" {
+		subnetIDs := strings.Split(vpcZoneIdentifier, ",")
+		resp, err := s.ec2Client().DescribeSubnets(&ec2.DescribeSubnetsInput{
+			SubnetIds: aws.StringSlice(subnetIDs),
+		})
+		if err != nil {
+			return nil, wrapAPIError("DescribeSubnets", err)
+		}
+		zones := map[string]string{}
+		for _, subnet := range resp.Subnets {
+			zones[*subnet.SubnetId] = aws.StringValue(subnet.AvailabilityZone)
+		}
+		kept := []string{}
+		for _, subnetID := range subnetIDs {
+			if zones[subnetID] == availabilityZone {
+				removed = append(removed, subnetID)
+			} else {
+				kept = append(kept, subnetID)
+			}
+		}
+		if len(removed) == 0 {
+			return removed, nil
+		}
+		if len(kept) == 0 {
+			return nil, fmt.Errorf("%s is the only availability zone of %s", availabilityZone, *group.AutoScalingGroupName)
+		}
+		input.VPCZoneIdentifier = aws.String(strings.Join(kept, ","))
+	} else {
+		kept := []*string{}
+		for _, zone := range group.AvailabilityZones {
+			if *zone != availabilityZone {
+				kept = append(kept, zone)
+			}
+		}
+		if len(kept) == len(group.AvailabilityZones) {
+			return removed, nil
+		}
+		if len(kept) == 0 {
+			return nil, fmt.Errorf("%s is the only availability zone of %s", availabilityZone, *group.AutoScalingGroupName)
+		}
+		input.AvailabilityZones = kept
+	}
+
+	if _, err := s.autoscalingClient().UpdateAutoScalingGroup(input); err != nil {
+		return nil, wrapAPIError("UpdateAutoScalingGroup", err)
+	}
+	s.autoScalingGroup = nil
+	return removed, nil
+}
+
+// RestoreAZ adds the availability zone evacuated by EvacuateAZ, or its
+// subnets, back to the autoscaling group and resumes the AZRebalance
+// process, after which the group moves instances back into the zone. If
+// AZRebalance was suspended before the zone was evacuated, it is left
+// suspended.
+func (s *Cluster) RestoreAZ(result *EvacuateResult) error {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return err
+	}
+	group, err := s.describeAutoscalingGroup(groupName)
+	if err != nil {
+		return err
+	}
+
+	input := &autoscaling.UpdateAutoScalingGroupInput{AutoScalingGroupName: aws.String(groupName)}
+	if len(result.Subnets) > 0 {
+		subnetIDs := []string{}
+		current := map[string]bool{}
+		if vpcZoneIdentifier := aws.StringValue(group.VPCZoneIdentifier); vpcZoneIdentifier != "" {
+			subnetIDs = strings.Split(vpcZoneIdentifier, ",")
+		}
+		for _, subnetID := range subnetIDs {
+			current[subnetID] = true
+		}
+		for _, subnetID := range result.Subnets {
+			if !current[subnetID] {
+				subnetIDs = append(subnetIDs, subnetID)
+			}
+		}
+		if len(subnetIDs) > len(current) {
+			input.VPCZoneIdentifier = aws.String(strings.Join(subnetIDs, ","))
+		}
+	} else if aws.StringValue(group.VPCZoneIdentifier) == "" {
+		restored := false
+		for _, zone := range group.AvailabilityZones {
+			restored = restored || *zone == result.AvailabilityZone
+		}
+		if !restored {
+			input.AvailabilityZones = append(append([]*string{}, group.AvailabilityZones...), aws.String(result.AvailabilityZone))
+		}
+	}
+	if input.VPCZoneIdentifier != nil || input.AvailabilityZones != nil {
+		if _, err := s.autoscalingClient().UpdateAutoScalingGroup(input); err != nil {
+			return wrapAPIError("UpdateAutoScalingGroup", err)
+		}
+	}
+	if result.RebalanceWasSuspended {
+		s.autoScalingGroup = nil
+		return nil
+	}
+	return s.ResumeProcesses(ProcessAZRebalance)
+}
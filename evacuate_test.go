@@ -0,0 +1,206 @@
+package ec2cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type EvacuateTest struct {
+}
+
+var _ = Suite(&EvacuateTest{})
+
+func groupInstance(instanceID, availabilityZone string, protected bool) *autoscaling.Instance {
+	return &autoscaling.Instance{
+		InstanceId:           aws.String(instanceID),
+		AvailabilityZone:     aws.String(availabilityZone),
+		LifecycleState:       aws.String(autoscaling.LifecycleStateInService),
+		ProtectedFromScaleIn: aws.Bool(protected),
+	}
+}
+
+// replaceInZone makes autoscalingSvc replace each instance removed from group
+// with an instance in availabilityZone.
+func replaceInZone(autoscalingSvc *fakeAutoScaling, group *autoscaling.Group, availabilityZone string) {
+	autoscalingSvc.onRemove = func(instanceID string) {
+		instances := []*autoscaling.Instance{}
+		for _, instance := range group.Instances {
+			if *instance.InstanceId != instanceID {
+				instances = append(instances, instance)
+			}
+		}
+		replacement := groupInstance(fmt.Sprintf("i-r%s", instanceID[2:]), availabilityZone, false)
+		group.Instances = append(instances, replacement)
+	}
+}
+
+func (s *EvacuateTest) TestEvacuateAZ(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		VPCZoneIdentifier:    aws.String("subnet-a,subnet-b"),
+		Instances: []*autoscaling.Instance{
+			groupInstance("i-00000001", "us-east-1a", false),
+			groupInstance("i-00000002", "us-east-1a", true),
+			groupInstance("i-00000003", "us-east-1b", false),
+			groupInstance("i-00000004", "us-east-1b", true),
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	replaceInZone(autoscalingSvc, group, "us-east-1b")
+	cluster := &Cluster{
+		AutoScalingGroupName: "example",
+		AutoScaling:          autoscalingSvc,
+		EC2: &fakeEC2{subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+		}},
+	}
+
+	steps := []string{}
+	result, err := cluster.EvacuateAZ("us-east-1a", EvacuateOptions{
+		PollInterval: time.Millisecond,
+		Progress: func(p EvacuateProgress) {
+			steps = append(steps, p.Step+" "+p.InstanceID+" "+p.ReplacementID)
+			if p.Step == EvacuateStepRemovingInstance {
+				// The peers are protected while the zone is evacuated.
+				for _, instance := range group.Instances {
+					c.Assert(*instance.ProtectedFromScaleIn, Equals, *instance.AvailabilityZone != "us-east-1a")
+				}
+			}
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, &EvacuateResult{
+		AvailabilityZone: "us-east-1a",
+		Subnets:          []string{"subnet-a"},
+		Replacements:     map[string]string{"i-00000001": "i-r00000001", "i-00000002": "i-r00000002"},
+	})
+	c.Assert(autoscalingSvc.suspended, DeepEquals, []string{ProcessAZRebalance})
+	c.Assert(*group.VPCZoneIdentifier, Equals, "subnet-b")
+	c.Assert(autoscalingSvc.removed, DeepEquals, []string{"i-00000001", "i-00000002"})
+
+	// Each instance is removed only once the previous one has been replaced.
+	c.Assert(steps, DeepEquals, []string{
+		EvacuateStepSuspendingRebalance + "  ",
+		EvacuateStepRemovingZone + "  ",
+		EvacuateStepProtectingPeers + "  ",
+		EvacuateStepRemovingInstance + " i-00000001 ",
+		EvacuateStepWaitingForReplacement + " i-00000001 ",
+		EvacuateStepReplacementInService + " i-00000001 i-r00000001",
+		EvacuateStepRemovingInstance + " i-00000002 ",
+		EvacuateStepWaitingForReplacement + " i-00000002 ",
+		EvacuateStepReplacementInService + " i-00000002 i-r00000002",
+		EvacuateStepUnprotectingPeers + "  ",
+	})
+
+	// Only the instances that EvacuateAZ protected are unprotected.
+	protected := map[string]bool{}
+	for _, instance := range group.Instances {
+		protected[*instance.InstanceId] = *instance.ProtectedFromScaleIn
+	}
+	c.Assert(protected, DeepEquals, map[string]bool{
+		"i-00000003":  false,
+		"i-00000004":  true,
+		"i-r00000001": false,
+		"i-r00000002": false,
+	})
+
+	c.Assert(cluster.RestoreAZ(result), IsNil)
+	c.Assert(*group.VPCZoneIdentifier, Equals, "subnet-b,subnet-a")
+	c.Assert(autoscalingSvc.suspended, HasLen, 0)
+}
+
+func (s *EvacuateTest) TestEvacuateAZDetach(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		AvailabilityZones:    aws.StringSlice([]string{"us-east-1a", "us-east-1b"}),
+		Instances: []*autoscaling.Instance{
+			groupInstance("i-00000001", "us-east-1a", false),
+			groupInstance("i-00000002", "us-east-1b", false),
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	replaceInZone(autoscalingSvc, group, "us-east-1b")
+	ec2Svc := &fakeEC2{}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: ec2Svc}
+
+	result, err := cluster.EvacuateAZ("us-east-1a", EvacuateOptions{Detach: true, PollInterval: time.Millisecond})
+	c.Assert(err, IsNil)
+	c.Assert(result.Subnets, HasLen, 0)
+	c.Assert(aws.StringValueSlice(group.AvailabilityZones), DeepEquals, []string{"us-east-1b"})
+	c.Assert(autoscalingSvc.removed, DeepEquals, []string{"i-00000001"})
+	c.Assert(ec2Svc.terminated, DeepEquals, []string{"i-00000001"})
+
+	c.Assert(cluster.RestoreAZ(result), IsNil)
+	c.Assert(aws.StringValueSlice(group.AvailabilityZones), DeepEquals, []string{"us-east-1b", "us-east-1a"})
+}
+
+func (s *EvacuateTest) TestEvacuateOnlyAZ(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		AvailabilityZones:    aws.StringSlice([]string{"us-east-1a"}),
+		Instances:            []*autoscaling.Instance{groupInstance("i-00000001", "us-east-1a", false)},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	_, err := cluster.EvacuateAZ("us-east-1a", EvacuateOptions{})
+	c.Assert(err, ErrorMatches, "us-east-1a is the only availability zone of example")
+	c.Assert(autoscalingSvc.removed, HasLen, 0)
+	c.Assert(autoscalingSvc.suspended, HasLen, 0)
+}
+
+func (s *EvacuateTest) TestEvacuateAZProtectionFailure(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		AvailabilityZones:    aws.StringSlice([]string{"us-east-1a", "us-east-1b"}),
+		Instances: []*autoscaling.Instance{
+			groupInstance("i-00000001", "us-east-1a", true),
+			groupInstance("i-00000002", "us-east-1b", false),
+		},
+	}
+	protectErr := errors.New("throttled")
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}, protectionErrs: []error{nil, protectErr}}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	result, err := cluster.EvacuateAZ("us-east-1a", EvacuateOptions{})
+	c.Assert(err, Equals, protectErr)
+	c.Assert(autoscalingSvc.removed, HasLen, 0)
+
+	// The zone has been removed, so the result is returned for RestoreAZ,
+	// and the protection of the zone's member is restored.
+	c.Assert(result, NotNil)
+	c.Assert(result.AvailabilityZone, Equals, "us-east-1a")
+	c.Assert(*group.Instances[0].ProtectedFromScaleIn, Equals, true)
+	c.Assert(*group.Instances[1].ProtectedFromScaleIn, Equals, false)
+
+	c.Assert(cluster.RestoreAZ(result), IsNil)
+	c.Assert(aws.StringValueSlice(group.AvailabilityZones), DeepEquals, []string{"us-east-1b", "us-east-1a"})
+	c.Assert(autoscalingSvc.suspended, HasLen, 0)
+}
+
+func (s *EvacuateTest) TestRestoreAZLeavesRebalanceSuspended(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		AvailabilityZones:    aws.StringSlice([]string{"us-east-1a", "us-east-1b"}),
+		SuspendedProcesses:   []*autoscaling.SuspendedProcess{{ProcessName: aws.String(ProcessAZRebalance)}},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	result, err := cluster.EvacuateAZ("us-east-1a", EvacuateOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.RebalanceWasSuspended, Equals, true)
+
+	// An operator suspended AZRebalance before the evacuation, so it stays
+	// suspended.
+	c.Assert(cluster.RestoreAZ(result), IsNil)
+	c.Assert(aws.StringValueSlice(group.AvailabilityZones), DeepEquals, []string{"us-east-1b", "us-east-1a"})
+	c.Assert(autoscalingSvc.suspended, DeepEquals, []string{ProcessAZRebalance})
+}
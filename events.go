@@ -0,0 +1,171 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// eventVisibilityTimeout is how far Heartbeat extends the visibility timeout
+// of an event's message, in seconds.
+const eventVisibilityTimeout = 300
+
+// eventRetryInterval is how long Events waits before receiving again after
+// watching the queue fails.
+const eventRetryInterval = 5 * time.Second
+
+// errEventCompleted and errEventReleased are returned to the lifecycle event
+// watcher by the callback of Events once the consumer has called Complete or
+// Release. errEventCompleted means that the action has been completed and
+// its message should be removed, and errEventReleased that the message
+// should be left in the queue.
+var (
+	errEventCompleted = errors.New("lifecycle event completed")
+	errEventReleased  = errors.New("lifecycle event released")
+)
+
+// queuedMessageKey is the context key under which runCallback stores the
+// queuedMessage of the lifecycle action passed to the callback.
+type queuedMessageKey struct{}
+
+// queuedMessage is the SQS message of a lifecycle action, and the URL of
+// the queue it was received from.
+type queuedMessage struct {
+	queueURL       string
+	messageWrapper *sqs.Message
+}
+
+// messageFromContext returns the queuedMessage stored in ctx by runCallback.
+func messageFromContext(ctx context.Context) queuedMessage {
+	message, _ := ctx.Value(queuedMessageKey{}).(queuedMessage)
+	return message
+}
+
+// LifecycleEvent is a lifecycle action delivered by Events. The consumer of
+// the event must eventually call exactly one of Complete or Release.
+type LifecycleEvent struct {
+	Message *LifecycleMessage
+
+	cluster        *Cluster
+	queueURL       string
+	receiptHandle  *string
+	sqsSvc         sqsiface.SQSAPI
+	autoscalingSvc autoscalingiface.AutoScalingAPI
+
+	// handled receives errEventCompleted or errEventReleased when the
+	// consumer is done with the event.
+	handled chan<- error
+}
+
+// Complete completes the lifecycle action with result, which is
+// ResultContinue or ResultAbandon, and removes the event from the queue. If
+// DryRun is set, the action is not completed and the event is hidden rather
+// than removed. If the action cannot be completed, the event is left in the
+// queue and delivered again once its visibility timeout expires.
+func (e LifecycleEvent) Complete(result string) error {
+	if e.cluster.DryRun {
+		log.Printf("dry run: %s: would complete lifecycle action with %s", e.Message.EC2InstanceID, result)
+		e.cluster.record(e.Message, result, nil, nil)
+		e.handled <- errEventCompleted
+		return nil
+	}
+	err := e.cluster.completeLifecycleAction(e.autoscalingSvc, e.Message, result)
+	e.cluster.record(e.Message, result, nil, err)
+	if err != nil {
+		e.handled <- errEventReleased
+		return err
+	}
+	e.handled <- errEventCompleted
+	return nil
+}
+
+// Heartbeat extends the timeout of the lifecycle action and the visibility
+// timeout of the event's message, for consumers that need more time.
+func (e LifecycleEvent) Heartbeat() error {
+	if err := recordLifecycleActionHeartbeat(e.autoscalingSvc, e.Message); err != nil {
+		return err
+	}
+	_, err := e.sqsSvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &e.queueURL,
+		ReceiptHandle:     e.receiptHandle,
+		VisibilityTimeout: aws.Int64(eventVisibilityTimeout),
+	})
+	return err
+}
+
+// Release returns the event to the queue without completing the lifecycle
+// action, so that it is delivered again immediately.
+func (e LifecycleEvent) Release() error {
+	_, err := e.sqsSvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &e.queueURL,
+		ReceiptHandle:     e.receiptHandle,
+		VisibilityTimeout: aws.Int64(0),
+	})
+	e.handled <- errEventReleased
+	return err
+}
+
+// Events monitors the lifecycle event queue of the current autoscaling group
+// and delivers each lifecycle action on the returned channel. It is an
+// alternative to WatchLifecycleEvents for consumers that prefer to select
+// on a channel and to decide for themselves when each action is completed.
+//
+// Events are received and handled as they are by WatchLifecycleEvents,
+// including MessageAttributeFilter, Deduplicator, FIFO ordering, visibility
+// renewal and StartupJitter. Events are delivered one at a time: the next
+// event is delivered once the previous one has been completed or released.
+// Errors watching the queue are logged and retried. The channel is closed
+// when ctx is cancelled and no event is outstanding.
+func (s *Cluster) Events(ctx context.Context) (<-chan LifecycleEvent, error) {
+	queueURL, err := s.LifecycleEventQueueURL()
+	if err != nil {
+		return nil, err
+	}
+
+	sqsSvc := s.sqsClient()
+	autoscalingSvc := s.autoscalingClient()
+	events := make(chan LifecycleEvent)
+	cb := func(cbCtx context.Context, m *LifecycleMessage) (bool, error) {
+		message := messageFromContext(cbCtx)
+		handled := make(chan error, 1)
+		event := LifecycleEvent{
+			Message:        m,
+			cluster:        s,
+			queueURL:       message.queueURL,
+			receiptHandle:  message.messageWrapper.ReceiptHandle,
+			sqsSvc:         sqsSvc,
+			autoscalingSvc: autoscalingSvc,
+			handled:        handled,
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return false, errEventReleased
+		}
+		return false, <-handled
+	}
+
+	go func() {
+		defer close(events)
+		queue := s.newLifecycleQueue(queueURL, s.LifecycleEventQueueURL)
+		for {
+			err := s.watchLifecycleEvents(ctx, queue, nil, cb)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("ERROR: %s", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(eventRetryInterval):
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,155 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type EventsTest struct {
+}
+
+var _ = Suite(&EventsTest{})
+
+func terminatingMessage(receiptHandle, instanceID string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String(receiptHandle),
+		ReceiptHandle: aws.String(receiptHandle),
+		Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"` + instanceID + `","LifecycleHookName":"terminate"}`),
+	}
+}
+
+func eventsCluster(sqsSvc *fakeSQS, autoscalingSvc *fakeAutoScaling) *Cluster {
+	autoscalingSvc.hooks = []*autoscaling.LifecycleHook{{
+		LifecycleHookName:     aws.String("terminate"),
+		NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:012345678901:example"),
+	}}
+	return &Cluster{
+		SQS:              sqsSvc,
+		AutoScaling:      autoscalingSvc,
+		autoScalingGroup: &autoscaling.Group{AutoScalingGroupName: aws.String("example")},
+	}
+}
+
+// drainEvents waits for events to be closed, after which the fakes are no
+// longer used by Events.
+func drainEvents(c *C, events <-chan LifecycleEvent) {
+	for event := range events {
+		c.Errorf("unexpected event for %s", event.Message.EC2InstanceID)
+	}
+}
+
+func (s *EventsTest) TestEvents(c *C) {
+	sqsSvc := &fakeSQS{receives: []*sqs.ReceiveMessageOutput{{
+		Messages: []*sqs.Message{
+			terminatingMessage("first", "i-00000001"),
+			terminatingMessage("second", "i-00000002"),
+		},
+	}}}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := eventsCluster(sqsSvc, autoscalingSvc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := cluster.Events(ctx)
+	c.Assert(err, IsNil)
+
+	event := <-events
+	c.Assert(event.Message.EC2InstanceID, Equals, "i-00000001")
+	c.Assert(event.Complete(ResultContinue), IsNil)
+
+	// The next event is delivered once the previous one is completed.
+	event = <-events
+	c.Assert(event.Message.EC2InstanceID, Equals, "i-00000002")
+	c.Assert(event.Release(), IsNil)
+
+	cancel()
+	drainEvents(c, events)
+
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000001")
+	c.Assert(*autoscalingSvc.completed[0].LifecycleActionResult, Equals, ResultContinue)
+
+	// Only the completed event is removed from the queue; the released one
+	// is made visible again at once.
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "first")
+	c.Assert(sqsSvc.changed, HasLen, 1)
+	c.Assert(*sqsSvc.changed[0].ReceiptHandle, Equals, "second")
+	c.Assert(*sqsSvc.changed[0].VisibilityTimeout, Equals, int64(0))
+}
+
+func (s *EventsTest) TestEventsCompleteFailure(c *C) {
+	sqsSvc := &fakeSQS{receives: []*sqs.ReceiveMessageOutput{{
+		Messages: []*sqs.Message{terminatingMessage("first", "i-00000001")},
+	}}}
+	completeErr := errors.New("throttled")
+	autoscalingSvc := &fakeAutoScaling{completeErr: completeErr}
+	cluster := eventsCluster(sqsSvc, autoscalingSvc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := cluster.Events(ctx)
+	c.Assert(err, IsNil)
+
+	event := <-events
+	c.Assert(event.Complete(ResultContinue), Equals, completeErr)
+
+	cancel()
+	drainEvents(c, events)
+
+	// The event is left in the queue to be delivered again.
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(sqsSvc.deleted, HasLen, 0)
+}
+
+func (s *EventsTest) TestEventsMessageAttributeFilter(c *C) {
+	other := terminatingMessage("other", "i-00000002")
+	other.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+		"environment": {DataType: aws.String("String"), StringValue: aws.String("staging")},
+	}
+	local := terminatingMessage("local", "i-00000001")
+	local.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+		"environment": {DataType: aws.String("String"), StringValue: aws.String("prod")},
+	}
+	sqsSvc := &fakeSQS{receives: []*sqs.ReceiveMessageOutput{{Messages: []*sqs.Message{other, local}}}}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := eventsCluster(sqsSvc, autoscalingSvc)
+	cluster.MessageAttributeFilter = map[string]string{"environment": "prod"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := cluster.Events(ctx)
+	c.Assert(err, IsNil)
+
+	event := <-events
+	c.Assert(event.Message.EC2InstanceID, Equals, "i-00000001")
+	c.Assert(event.Complete(ResultContinue), IsNil)
+
+	cancel()
+	drainEvents(c, events)
+
+	// The message for the other cluster is handed back to the queue.
+	c.Assert(sqsSvc.released, HasLen, 1)
+	c.Assert(sqsSvc.released[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.released[0].Entries[0].ReceiptHandle, Equals, "other")
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "local")
+}
+
+func (s *EventsTest) TestEventsCancelled(c *C) {
+	cluster := eventsCluster(&fakeSQS{}, &fakeAutoScaling{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := cluster.Events(ctx)
+	c.Assert(err, IsNil)
+
+	// Receiving fails and is retried until ctx is cancelled.
+	cancel()
+	drainEvents(c, events)
+}
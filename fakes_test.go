@@ -0,0 +1,932 @@
+package ec2cluster
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// fakeSQS returns each of receives in turn from ReceiveMessage, then
+// errEndOfTest, and records the receive requests and the messages removed
+// from the queue. ChangeMessageVisibility records the visibility changes of
+// single messages in changed, and ChangeMessageVisibilityWithContext fails
+// with visibilityErr, if set.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	receives      []*sqs.ReceiveMessageOutput
+	received      []*sqs.ReceiveMessageInput
+	deleted       []*sqs.DeleteMessageBatchInput
+	released      []*sqs.ChangeMessageVisibilityBatchInput
+	changed       []*sqs.ChangeMessageVisibilityInput
+	visibilityErr error
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	f.released = append(f.released, input)
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+var errEndOfTest = errors.New("end of test")
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.received = append(f.received, input)
+	if len(f.receives) == 0 {
+		return nil, errEndOfTest
+	}
+	resp := f.receives[0]
+	f.receives = f.receives[1:]
+	return resp, nil
+}
+
+func (f *fakeSQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	f.deleted = append(f.deleted, input)
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func (f *fakeSQS) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{
+		QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/" + *input.QueueOwnerAWSAccountId + "/" + *input.QueueName),
+	}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.changed = append(f.changed, input)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if f.visibilityErr != nil {
+		return nil, f.visibilityErr
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// fakeAutoScaling returns hooks from DescribeLifecycleHooks,
+// launchConfigurations from DescribeLaunchConfigurations and groups, one per
+// page, from DescribeAutoScalingGroupsPages, and records
+// completed lifecycle actions and heartbeats. CompleteLifecycleAction fails
+// with completeErr, if set. The calls that change groups update them, and
+// instances removed with TerminateInstanceInAutoScalingGroup or
+// DetachInstances are passed to onRemove, if set. Each SetInstanceProtection
+// call fails with the next of protectionErrs, while there are any. Each
+// DescribeInstanceRefreshes call returns the next of refreshes, and then
+// the last one again. DescribeAutoScalingGroupsPages invokes
+// onDescribeGroups, if set, before describing the groups, and
+// SetInstanceHealth records the instances reported healthy.
+// DescribeScalingActivitiesPages returns activities, two per page, and
+// counts the pages read in activityPages. SuspendProcesses and
+// ResumeProcesses record their queries in processQueries; resuming without
+// ScalingProcesses resumes every process.
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	hooks                []*autoscaling.LifecycleHook
+	groups               []*autoscaling.Group
+	launchConfigurations []*autoscaling.LaunchConfiguration
+	completed            []*autoscaling.CompleteLifecycleActionInput
+	completeErr          error
+	suspended            []string
+	processQueries       []*autoscaling.ScalingProcessQuery
+	removed              []string
+	onRemove             func(instanceID string)
+	protectionErrs       []error
+	refreshes            []*autoscaling.InstanceRefresh
+	startedRefreshes     []*autoscaling.StartInstanceRefreshInput
+	onDescribeGroups     func()
+	healthy              []string
+	desiredCapacities    []int64
+	activities           []*autoscaling.Activity
+	activityPages        int
+
+	mu         sync.Mutex
+	heartbeats int
+}
+
+func (f *fakeAutoScaling) group(name *string) *autoscaling.Group {
+	for _, group := range f.groups {
+		if *group.AutoScalingGroupName == *name {
+			return group
+		}
+	}
+	return nil
+}
+
+func (f *fakeAutoScaling) SuspendProcesses(input *autoscaling.ScalingProcessQuery) (*autoscaling.SuspendProcessesOutput, error) {
+	f.processQueries = append(f.processQueries, input)
+	f.suspended = append(f.suspended, aws.StringValueSlice(input.ScalingProcesses)...)
+	return &autoscaling.SuspendProcessesOutput{}, nil
+}
+
+func (f *fakeAutoScaling) ResumeProcesses(input *autoscaling.ScalingProcessQuery) (*autoscaling.ResumeProcessesOutput, error) {
+	f.processQueries = append(f.processQueries, input)
+	if input.ScalingProcesses == nil {
+		f.suspended = nil
+		return &autoscaling.ResumeProcessesOutput{}, nil
+	}
+	resumed := map[string]bool{}
+	for _, process := range input.ScalingProcesses {
+		resumed[*process] = true
+	}
+	suspended := []string{}
+	for _, process := range f.suspended {
+		if !resumed[process] {
+			suspended = append(suspended, process)
+		}
+	}
+	f.suspended = suspended
+	return &autoscaling.ResumeProcessesOutput{}, nil
+}
+
+func (f *fakeAutoScaling) UpdateAutoScalingGroup(input *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	group := f.group(input.AutoScalingGroupName)
+	if input.VPCZoneIdentifier != nil {
+		group.VPCZoneIdentifier = input.VPCZoneIdentifier
+	}
+	if input.AvailabilityZones != nil {
+		group.AvailabilityZones = input.AvailabilityZones
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (f *fakeAutoScaling) SetInstanceProtection(input *autoscaling.SetInstanceProtectionInput) (*autoscaling.SetInstanceProtectionOutput, error) {
+	if len(f.protectionErrs) > 0 {
+		err := f.protectionErrs[0]
+		f.protectionErrs = f.protectionErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, instance := range f.group(input.AutoScalingGroupName).Instances {
+		for _, instanceID := range input.InstanceIds {
+			if *instance.InstanceId == *instanceID {
+				instance.ProtectedFromScaleIn = input.ProtectedFromScaleIn
+			}
+		}
+	}
+	return &autoscaling.SetInstanceProtectionOutput{}, nil
+}
+
+func (f *fakeAutoScaling) TerminateInstanceInAutoScalingGroup(input *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	f.remove(*input.InstanceId)
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DetachInstances(input *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+	for _, instanceID := range input.InstanceIds {
+		f.remove(*instanceID)
+	}
+	return &autoscaling.DetachInstancesOutput{}, nil
+}
+
+func (f *fakeAutoScaling) remove(instanceID string) {
+	f.removed = append(f.removed, instanceID)
+	if f.onRemove != nil {
+		f.onRemove(instanceID)
+	}
+}
+
+func (f *fakeAutoScaling) DescribeLaunchConfigurations(input *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	return &autoscaling.DescribeLaunchConfigurationsOutput{LaunchConfigurations: f.launchConfigurations}, nil
+}
+
+func (f *fakeAutoScaling) DescribeLifecycleHooks(input *autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	return &autoscaling.DescribeLifecycleHooksOutput{LifecycleHooks: f.hooks}, nil
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	if f.onDescribeGroups != nil {
+		f.onDescribeGroups()
+	}
+	groups := []*autoscaling.Group{}
+	for _, group := range f.groups {
+		match := len(input.AutoScalingGroupNames) == 0
+		for _, name := range input.AutoScalingGroupNames {
+			match = match || *name == *group.AutoScalingGroupName
+		}
+		if match {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		fn(&autoscaling.DescribeAutoScalingGroupsOutput{}, true)
+	}
+	for i, group := range groups {
+		if !fn(&autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{group}}, i == len(groups)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeAutoScaling) RecordLifecycleActionHeartbeat(input *autoscaling.RecordLifecycleActionHeartbeatInput) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heartbeats++
+	return &autoscaling.RecordLifecycleActionHeartbeatOutput{}, nil
+}
+
+func (f *fakeAutoScaling) SetInstanceHealth(input *autoscaling.SetInstanceHealthInput) (*autoscaling.SetInstanceHealthOutput, error) {
+	if *input.HealthStatus == "Healthy" {
+		f.healthy = append(f.healthy, *input.InstanceId)
+	}
+	return &autoscaling.SetInstanceHealthOutput{}, nil
+}
+
+func (f *fakeAutoScaling) SetDesiredCapacity(input *autoscaling.SetDesiredCapacityInput) (*autoscaling.SetDesiredCapacityOutput, error) {
+	f.desiredCapacities = append(f.desiredCapacities, *input.DesiredCapacity)
+	f.group(input.AutoScalingGroupName).DesiredCapacity = input.DesiredCapacity
+	return &autoscaling.SetDesiredCapacityOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DescribeScalingActivitiesPages(input *autoscaling.DescribeScalingActivitiesInput, fn func(*autoscaling.DescribeScalingActivitiesOutput, bool) bool) error {
+	for start := 0; start < len(f.activities); start += 2 {
+		end := start + 2
+		if end > len(f.activities) {
+			end = len(f.activities)
+		}
+		f.activityPages++
+		if !fn(&autoscaling.DescribeScalingActivitiesOutput{Activities: f.activities[start:end]}, end == len(f.activities)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeAutoScaling) StartInstanceRefresh(input *autoscaling.StartInstanceRefreshInput) (*autoscaling.StartInstanceRefreshOutput, error) {
+	f.startedRefreshes = append(f.startedRefreshes, input)
+	return &autoscaling.StartInstanceRefreshOutput{InstanceRefreshId: aws.String("refresh-1")}, nil
+}
+
+func (f *fakeAutoScaling) DescribeInstanceRefreshes(input *autoscaling.DescribeInstanceRefreshesInput) (*autoscaling.DescribeInstanceRefreshesOutput, error) {
+	refresh := f.refreshes[0]
+	if len(f.refreshes) > 1 {
+		f.refreshes = f.refreshes[1:]
+	}
+	return &autoscaling.DescribeInstanceRefreshesOutput{InstanceRefreshes: []*autoscaling.InstanceRefresh{refresh}}, nil
+}
+
+func (f *fakeAutoScaling) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	f.completed = append(f.completed, input)
+	if f.completeErr != nil {
+		return nil, f.completeErr
+	}
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+// fakeEC2 returns instances from DescribeInstances, ignoring filters other
+// than instance IDs, and volumes, networkInterfaces and addresses matching
+// the tag, zone, domain, status and attachment filters. The paginated calls return one item per page, and TerminateInstances
+// records the instances in terminated. DescribeInstancesPages records its
+// filters in describeFilters and invokes onDescribeInstances, if set, before
+// describing the instances. CreateTags records its requests in tagged.
+// Volumes, interfaces and addresses are attached and detached at once,
+// except that attaching those in attachErrs, by volume, interface or
+// allocation ID, fails with their error. DescribeInstanceStatusPages
+// returns instanceStatuses, after invoking onDescribeStatus, if set, which
+// may change them or fail the call.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	instances              []*ec2.Instance
+	volumes                []*ec2.Volume
+	networkInterfaces      []*ec2.NetworkInterface
+	addresses              []*ec2.Address
+	launchTemplateVersions []*ec2.LaunchTemplateVersion
+	placementGroups        []*ec2.PlacementGroup
+	subnets                []*ec2.Subnet
+	terminated             []string
+	describeFilters        [][]*ec2.Filter
+	onDescribeInstances    func()
+	tagged                 []*ec2.CreateTagsInput
+	attachErrs             map[string]error
+	instanceStatuses       []*ec2.InstanceStatus
+	onDescribeStatus       func() error
+
+	// mu guards instances for tests that replace them with setInstances
+	// while another goroutine describes them.
+	mu sync.Mutex
+}
+
+func (f *fakeEC2) setInstances(instances ...*ec2.Instance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances = instances
+}
+
+// DescribeLaunchTemplateVersions returns the versions in
+// launchTemplateVersions with the requested version number, treating
+// `$Latest` and `$Default` as the last version.
+func (f *fakeEC2) DescribeLaunchTemplateVersions(input *ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	resp := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	for i, version := range f.launchTemplateVersions {
+		for _, requested := range input.Versions {
+			latest := i == len(f.launchTemplateVersions)-1 && (*requested == "$Latest" || *requested == "$Default")
+			if latest || *requested == strconv.FormatInt(*version.VersionNumber, 10) {
+				resp.LaunchTemplateVersions = append(resp.LaunchTemplateVersions, version)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	reservation := &ec2.Reservation{}
+	for _, instance := range f.instances {
+		for _, instanceID := range input.InstanceIds {
+			if *instance.InstanceId == *instanceID {
+				reservation.Instances = append(reservation.Instances, instance)
+			}
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+func (f *fakeEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.describeFilters = append(f.describeFilters, input.Filters)
+	if f.onDescribeInstances != nil {
+		f.onDescribeInstances()
+	}
+	if len(f.instances) == 0 {
+		fn(&ec2.DescribeInstancesOutput{}, true)
+	}
+	for i, instance := range f.instances {
+		if !fn(&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+		}, i == len(f.instances)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// CreateTags adds tags to the matching instances and volumes, replacing the
+// values of tags they already have.
+func (f *fakeEC2) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.tagged = append(f.tagged, input)
+	for _, resource := range input.Resources {
+		for _, instance := range f.instances {
+			if *instance.InstanceId == *resource {
+				instance.Tags = setTags(instance.Tags, input.Tags)
+			}
+		}
+		for _, volume := range f.volumes {
+			if *volume.VolumeId == *resource {
+				volume.Tags = setTags(volume.Tags, input.Tags)
+			}
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeleteTags removes tags from the matching volumes.
+func (f *fakeEC2) DeleteTags(input *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	for _, resource := range input.Resources {
+		for _, volume := range f.volumes {
+			if *volume.VolumeId != *resource {
+				continue
+			}
+			tags := []*ec2.Tag{}
+			for _, tag := range volume.Tags {
+				deleted := false
+				for _, deleteTag := range input.Tags {
+					deleted = deleted || *deleteTag.Key == *tag.Key
+				}
+				if !deleted {
+					tags = append(tags, tag)
+				}
+			}
+			volume.Tags = tags
+		}
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// matchesFilters reports whether a resource with tags and the named field
+// values matches each of filters. Filters on fields that are not in fields
+// are ignored.
+func matchesFilters(filters []*ec2.Filter, tags []*ec2.Tag, fields map[string]string) bool {
+	for _, filter := range filters {
+		name := *filter.Name
+		value, ok := fields[name]
+		if strings.HasPrefix(name, "tag:") {
+			value, ok = "", true
+			for _, tag := range tags {
+				if "tag:"+*tag.Key == name {
+					value = *tag.Value
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		match := false
+		for _, filterValue := range filter.Values {
+			match = match || *filterValue == value
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func setTags(tags []*ec2.Tag, newTags []*ec2.Tag) []*ec2.Tag {
+	rv := []*ec2.Tag{}
+	for _, tag := range tags {
+		replaced := false
+		for _, newTag := range newTags {
+			replaced = replaced || *newTag.Key == *tag.Key
+		}
+		if !replaced {
+			rv = append(rv, tag)
+		}
+	}
+	return append(rv, newTags...)
+}
+
+// DescribeTags returns the tags of the instances, filtered by the
+// resource-id and key filters.
+func (f *fakeEC2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	matches := func(name, value string) bool {
+		for _, filter := range input.Filters {
+			if *filter.Name != name {
+				continue
+			}
+			for _, filterValue := range filter.Values {
+				if *filterValue == value {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}
+	resp := &ec2.DescribeTagsOutput{}
+	for _, instance := range f.instances {
+		if !matches("resource-id", *instance.InstanceId) {
+			continue
+		}
+		for _, tag := range instance.Tags {
+			if matches("key", *tag.Key) {
+				resp.Tags = append(resp.Tags, &ec2.TagDescription{
+					ResourceId:   instance.InstanceId,
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Key:          tag.Key,
+					Value:        tag.Value,
+				})
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) DescribePlacementGroups(input *ec2.DescribePlacementGroupsInput) (*ec2.DescribePlacementGroupsOutput, error) {
+	resp := &ec2.DescribePlacementGroupsOutput{}
+	for _, group := range f.placementGroups {
+		for _, name := range input.GroupNames {
+			if *name == *group.GroupName {
+				resp.PlacementGroups = append(resp.PlacementGroups, group)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	resp := &ec2.DescribeSubnetsOutput{}
+	for _, subnet := range f.subnets {
+		for _, subnetID := range input.SubnetIds {
+			if *subnet.SubnetId == *subnetID {
+				resp.Subnets = append(resp.Subnets, subnet)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) DescribeInstanceStatusPages(input *ec2.DescribeInstanceStatusInput, fn func(*ec2.DescribeInstanceStatusOutput, bool) bool) error {
+	if f.onDescribeStatus != nil {
+		if err := f.onDescribeStatus(); err != nil {
+			return err
+		}
+	}
+	fn(&ec2.DescribeInstanceStatusOutput{InstanceStatuses: f.instanceStatuses}, true)
+	return nil
+}
+
+func (f *fakeEC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.terminated = append(f.terminated, aws.StringValueSlice(input.InstanceIds)...)
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeVolumesPages(input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool) error {
+	volumes := []*ec2.Volume{}
+	for _, volume := range f.volumes {
+		fields := map[string]string{
+			"availability-zone": aws.StringValue(volume.AvailabilityZone),
+			"status":            aws.StringValue(volume.State),
+		}
+		if len(volume.Attachments) > 0 {
+			fields["attachment.instance-id"] = aws.StringValue(volume.Attachments[0].InstanceId)
+		} else {
+			fields["attachment.instance-id"] = ""
+		}
+		if matchesFilters(input.Filters, volume.Tags, fields) {
+			volumes = append(volumes, volume)
+		}
+	}
+	for i, volume := range volumes {
+		if !fn(&ec2.DescribeVolumesOutput{Volumes: []*ec2.Volume{volume}}, i == len(volumes)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeEC2) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	resp := &ec2.DescribeVolumesOutput{}
+	for _, volume := range f.volumes {
+		for _, volumeID := range input.VolumeIds {
+			if *volume.VolumeId == *volumeID {
+				resp.Volumes = append(resp.Volumes, volume)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) AttachVolume(input *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+	if err := f.attachErrs[*input.VolumeId]; err != nil {
+		return nil, err
+	}
+	for _, volume := range f.volumes {
+		if *volume.VolumeId == *input.VolumeId {
+			attachment := &ec2.VolumeAttachment{
+				Device:     input.Device,
+				InstanceId: input.InstanceId,
+				VolumeId:   input.VolumeId,
+				State:      aws.String(ec2.VolumeAttachmentStateAttached),
+			}
+			volume.State = aws.String(ec2.VolumeStateInUse)
+			volume.Attachments = []*ec2.VolumeAttachment{attachment}
+			return attachment, nil
+		}
+	}
+	return nil, errors.New("no such volume")
+}
+
+func (f *fakeEC2) DetachVolume(input *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
+	for _, volume := range f.volumes {
+		if *volume.VolumeId == *input.VolumeId {
+			volume.State = aws.String(ec2.VolumeStateAvailable)
+			volume.Attachments = nil
+			return &ec2.VolumeAttachment{VolumeId: input.VolumeId}, nil
+		}
+	}
+	return nil, errors.New("no such volume")
+}
+
+func (f *fakeEC2) DescribeNetworkInterfacesPages(input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool) error {
+	networkInterfaces := []*ec2.NetworkInterface{}
+	for _, networkInterface := range f.networkInterfaces {
+		fields := map[string]string{
+			"availability-zone":      aws.StringValue(networkInterface.AvailabilityZone),
+			"status":                 aws.StringValue(networkInterface.Status),
+			"attachment.instance-id": "",
+		}
+		if networkInterface.Attachment != nil {
+			fields["attachment.instance-id"] = aws.StringValue(networkInterface.Attachment.InstanceId)
+		}
+		if matchesFilters(input.Filters, networkInterface.TagSet, fields) {
+			networkInterfaces = append(networkInterfaces, networkInterface)
+		}
+	}
+	for i, networkInterface := range networkInterfaces {
+		if !fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{networkInterface}}, i == len(networkInterfaces)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeEC2) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	resp := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, networkInterface := range f.networkInterfaces {
+		for _, networkInterfaceID := range input.NetworkInterfaceIds {
+			if *networkInterface.NetworkInterfaceId == *networkInterfaceID {
+				resp.NetworkInterfaces = append(resp.NetworkInterfaces, networkInterface)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) AttachNetworkInterface(input *ec2.AttachNetworkInterfaceInput) (*ec2.AttachNetworkInterfaceOutput, error) {
+	if err := f.attachErrs[*input.NetworkInterfaceId]; err != nil {
+		return nil, err
+	}
+	for _, networkInterface := range f.networkInterfaces {
+		if *networkInterface.NetworkInterfaceId == *input.NetworkInterfaceId {
+			attachmentID := "attach-" + strings.TrimPrefix(*input.NetworkInterfaceId, "eni-")
+			networkInterface.Status = aws.String(ec2.NetworkInterfaceStatusInUse)
+			networkInterface.Attachment = &ec2.NetworkInterfaceAttachment{
+				AttachmentId: aws.String(attachmentID),
+				DeviceIndex:  input.DeviceIndex,
+				InstanceId:   input.InstanceId,
+				Status:       aws.String(ec2.AttachmentStatusAttached),
+			}
+			return &ec2.AttachNetworkInterfaceOutput{AttachmentId: aws.String(attachmentID)}, nil
+		}
+	}
+	return nil, errors.New("no such network interface")
+}
+
+func (f *fakeEC2) DetachNetworkInterface(input *ec2.DetachNetworkInterfaceInput) (*ec2.DetachNetworkInterfaceOutput, error) {
+	for _, networkInterface := range f.networkInterfaces {
+		if networkInterface.Attachment != nil && aws.StringValue(networkInterface.Attachment.AttachmentId) == *input.AttachmentId {
+			networkInterface.Status = aws.String(ec2.NetworkInterfaceStatusAvailable)
+			networkInterface.Attachment = nil
+			return &ec2.DetachNetworkInterfaceOutput{}, nil
+		}
+	}
+	return nil, errors.New("no such attachment")
+}
+
+func (f *fakeEC2) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	resp := &ec2.DescribeAddressesOutput{}
+	for _, address := range f.addresses {
+		fields := map[string]string{
+			"domain":      aws.StringValue(address.Domain),
+			"instance-id": aws.StringValue(address.InstanceId),
+		}
+		if matchesFilters(input.Filters, address.Tags, fields) {
+			resp.Addresses = append(resp.Addresses, address)
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEC2) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	if err := f.attachErrs[*input.AllocationId]; err != nil {
+		return nil, err
+	}
+	for _, address := range f.addresses {
+		if *address.AllocationId != *input.AllocationId {
+			continue
+		}
+		if address.AssociationId != nil && !aws.BoolValue(input.AllowReassociation) {
+			return nil, errors.New("Resource.AlreadyAssociated")
+		}
+		associationID := "eipassoc-" + strings.TrimPrefix(*input.AllocationId, "eipalloc-")
+		address.AssociationId = aws.String(associationID)
+		address.InstanceId = input.InstanceId
+		return &ec2.AssociateAddressOutput{AssociationId: aws.String(associationID)}, nil
+	}
+	return nil, errors.New("no such address")
+}
+
+func (f *fakeEC2) DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	for _, address := range f.addresses {
+		if aws.StringValue(address.AssociationId) == *input.AssociationId {
+			address.AssociationId = nil
+			address.InstanceId = nil
+			return &ec2.DisassociateAddressOutput{}, nil
+		}
+	}
+	return nil, errors.New("no such association")
+}
+
+func fakeInstance(instanceID string, state string, launchTime time.Time) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:       aws.String(instanceID),
+		State:            &ec2.InstanceState{Name: aws.String(state)},
+		LaunchTime:       aws.Time(launchTime),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		Placement:        &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("app"), Value: aws.String("example")},
+		},
+	}
+}
+
+// fakeECS lists containerInstanceArns for the instance in the filter of
+// ListContainerInstances, records the container instances set to DRAINING
+// and reports each of runningTasks in turn, then the last one again, as the
+// running task count of every container instance.
+type fakeECS struct {
+	ecsiface.ECSAPI
+	containerInstanceArns map[string]string
+	listed                []string
+	draining              []string
+	runningTasks          []int64
+}
+
+func (f *fakeECS) ListContainerInstances(input *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error) {
+	f.listed = append(f.listed, *input.Filter)
+	resp := &ecs.ListContainerInstancesOutput{}
+	instanceID := strings.TrimPrefix(*input.Filter, "ec2InstanceId == ")
+	if arn, ok := f.containerInstanceArns[instanceID]; ok {
+		resp.ContainerInstanceArns = []*string{aws.String(arn)}
+	}
+	return resp, nil
+}
+
+func (f *fakeECS) UpdateContainerInstancesState(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+	if *input.Status == ecs.ContainerInstanceStatusDraining {
+		f.draining = append(f.draining, aws.StringValueSlice(input.ContainerInstances)...)
+	}
+	return &ecs.UpdateContainerInstancesStateOutput{}, nil
+}
+
+func (f *fakeECS) DescribeContainerInstances(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+	runningTasks := f.runningTasks[0]
+	if len(f.runningTasks) > 1 {
+		f.runningTasks = f.runningTasks[1:]
+	}
+	resp := &ecs.DescribeContainerInstancesOutput{}
+	for _, arn := range input.ContainerInstances {
+		resp.ContainerInstances = append(resp.ContainerInstances, &ecs.ContainerInstance{
+			ContainerInstanceArn: arn,
+			RunningTasksCount:    aws.Int64(runningTasks),
+		})
+	}
+	return resp, nil
+}
+
+// fakeS3 stores objects in memory, honouring If-Match and If-None-Match on
+// PutObjectWithContext. ETags are a counter of writes.
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+	etags   map[string]string
+	writes  int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	value, ok := f.objects[*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(value)),
+		ETag: aws.String(f.etags[*input.Key]),
+	}, nil
+}
+
+func (f *fakeS3) put(input *s3.PutObjectInput) *s3.PutObjectOutput {
+	value, _ := ioutil.ReadAll(input.Body)
+	f.writes++
+	f.objects[*input.Key] = value
+	f.etags[*input.Key] = strconv.Itoa(f.writes)
+	return &s3.PutObjectOutput{ETag: aws.String(f.etags[*input.Key])}
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return f.put(input), nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	etag, exists := f.etags[*input.Key]
+	if r.HTTPRequest.Header.Get("If-None-Match") == "*" && exists {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	if ifMatch := r.HTTPRequest.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	return f.put(input), nil
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(f.objects, *input.Key)
+	delete(f.etags, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, *input.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	resp := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		resp.Contents = append(resp.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(resp, true)
+	return nil
+}
+
+// fakeDynamoDB stores the hash keys of items in memory, honouring
+// `attribute_not_exists` conditions on PutItem.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	keys map[string]bool
+}
+
+func (f *fakeDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	key := *input.Item["LifecycleActionToken"].S
+	if input.ConditionExpression != nil && f.keys[key] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	if f.keys == nil {
+		f.keys = map[string]bool{}
+	}
+	f.keys[key] = true
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.keys, *input.Key["LifecycleActionToken"].S)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// fakeSSM returns each of statuses in turn from GetCommandInvocation, after
+// reporting once that the invocation does not exist yet.
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	sent     []*ssm.SendCommandInput
+	statuses []string
+	polled   bool
+}
+
+func (f *fakeSSM) SendCommand(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+	f.sent = append(f.sent, input)
+	return &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("command")}}, nil
+}
+
+func (f *fakeSSM) GetCommandInvocation(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+	if !f.polled {
+		f.polled = true
+		return nil, awserr.New(ssm.ErrCodeInvocationDoesNotExist, "", nil)
+	}
+	status := f.statuses[0]
+	if len(f.statuses) > 1 {
+		f.statuses = f.statuses[1:]
+	}
+	return &ssm.GetCommandInvocationOutput{
+		Status:               aws.String(status),
+		StandardErrorContent: aws.String("exit status 1"),
+	}, nil
+}
+
+// fakeCloudWatchLogs stores the events of a single log stream in memory.
+type fakeCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	streams int
+	events  []*cloudwatchlogs.InputLogEvent
+}
+
+func (f *fakeCloudWatchLogs) CreateLogStream(input *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	f.streams++
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (f *fakeCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.events = append(f.events, input.LogEvents...)
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func (f *fakeCloudWatchLogs) GetLogEventsPages(input *cloudwatchlogs.GetLogEventsInput, fn func(*cloudwatchlogs.GetLogEventsOutput, bool) bool) error {
+	resp := &cloudwatchlogs.GetLogEventsOutput{}
+	for _, event := range f.events {
+		resp.Events = append(resp.Events, &cloudwatchlogs.OutputLogEvent{Message: event.Message, Timestamp: event.Timestamp})
+	}
+	fn(resp, true)
+	return nil
+}
@@ -0,0 +1,32 @@
+package ec2cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// isFIFOQueue returns true if queueURL is the URL of an SQS FIFO queue.
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// messageGroupID returns the message group of a message received from a
+// FIFO queue, or the empty string.
+func messageGroupID(message *sqs.Message) string {
+	return aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+}
+
+// newReceiveAttemptID returns a random ReceiveRequestAttemptId.
+func newReceiveAttemptID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
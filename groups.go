@@ -0,0 +1,152 @@
+package ec2cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// autoscalingGroupNameTag is the tag that EC2 applies to every instance
+// launched by an autoscaling group.
+const autoscalingGroupNameTag = "aws:autoscaling:groupName"
+
+// AutoscalingGroups returns every autoscaling group that makes up the
+// cluster. A cluster defined by a tag such as `cluster=foo` may span
+// several autoscaling groups, for example one per availability zone; these
+// are the groups that carry the cluster tag. If TagName is
+// `aws:autoscaling:groupName`, this is the single group named by the tag.
+func (s *Cluster) AutoscalingGroups() ([]*autoscaling.Group, error) {
+	tagValue, err := s.tagValue()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+	if s.TagName == autoscalingGroupNameTag {
+		input.AutoScalingGroupNames = []*string{aws.String(tagValue)}
+	} else {
+		input.Filters = []*autoscaling.Filter{
+			&autoscaling.Filter{
+				Name:   aws.String(fmt.Sprintf("tag:%s", s.TagName)),
+				Values: []*string{aws.String(tagValue)},
+			},
+		}
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	groups := []*autoscaling.Group{}
+	err = autoscalingSvc.DescribeAutoScalingGroupsPages(input,
+		func(resp *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, resp.AutoScalingGroups...)
+			return true
+		})
+	if err != nil {
+		return nil, wrapAPIError("DescribeAutoScalingGroups", err)
+	}
+	return groups, nil
+}
+
+// LifecycleEventQueueURLs returns the URL of each distinct lifecycle hook
+// queue across all of the autoscaling groups that make up the cluster.
+func (s *Cluster) LifecycleEventQueueURLs() ([]string, error) {
+	groups, err := s.AutoscalingGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	rv := []string{}
+	for _, group := range groups {
+		queueURLs, err := s.lifecycleHookQueueURLs(*group.AutoScalingGroupName)
+		if err != nil {
+			return nil, err
+		}
+		for _, queueURL := range queueURLs {
+			if !seen[queueURL] {
+				seen[queueURL] = true
+				rv = append(rv, queueURL)
+			}
+		}
+	}
+	if len(rv) == 0 {
+		return nil, ErrLifecycleHookNotFound
+	}
+	return rv, nil
+}
+
+// WatchClusterLifecycleEvents invokes cb for each lifecycle event from every
+// lifecycle hook queue of the cluster, as returned by
+// LifecycleEventQueueURLs. Each queue is watched concurrently, so cb must be
+// safe to call from multiple goroutines. When watching any of the queues
+// fails, the other watchers are stopped, as described for
+// WatchLifecycleEventsContext, and the first error is returned once they
+// have all returned.
+func (s *Cluster) WatchClusterLifecycleEvents(cb LifecyleEventCallback) error {
+	queueURLs, err := s.LifecycleEventQueueURLs()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, len(queueURLs))
+	for _, queueURL := range queueURLs {
+		queue := s.newLifecycleQueue(queueURL, s.clusterQueueResolver(queueName(queueURL)))
+		go func() {
+			errCh <- s.watchLifecycleEvents(ctx, queue, nil, cb.withContext())
+		}()
+	}
+	err = <-errCh
+	cancel()
+	for range queueURLs[1:] {
+		<-errCh
+	}
+	return err
+}
+
+// clusterQueueResolver returns a function that looks up the URL of the named
+// lifecycle hook queue among the queues of the cluster. Queues are matched
+// by name, since each watcher follows its own queue if it is recreated.
+func (s *Cluster) clusterQueueResolver(name string) func() (string, error) {
+	return func() (string, error) {
+		queueURLs, err := s.LifecycleEventQueueURLs()
+		if err != nil {
+			return "", err
+		}
+		for _, queueURL := range queueURLs {
+			if queueName(queueURL) == name {
+				return queueURL, nil
+			}
+		}
+		return "", ErrLifecycleHookNotFound
+	}
+}
+
+// Leader returns the cluster member that should act as the leader of the
+// cluster, which is the oldest running member across all of the cluster's
+// autoscaling groups. Because every member sees the same list of members,
+// every member agrees on the leader without further coordination.
+func (s *Cluster) Leader() (*ec2.Instance, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	running := runningInstances(members)
+	if len(running) == 0 {
+		return nil, ErrNoRunningMembers
+	}
+	return running[0], nil
+}
+
+// IsLeader returns true if the current instance is the leader of the
+// cluster, as returned by Leader.
+func (s *Cluster) IsLeader() (bool, error) {
+	leader, err := s.Leader()
+	if err != nil {
+		return false, err
+	}
+	return aws.StringValue(leader.InstanceId) == s.InstanceID, nil
+}
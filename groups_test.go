@@ -0,0 +1,83 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type GroupsTest struct {
+}
+
+var _ = Suite(&GroupsTest{})
+
+// failingQueueSQS fails receives from the queue named failing with
+// errEndOfTest. Receives from other queues block until they are cancelled,
+// and then send the queue URL to stopped.
+type failingQueueSQS struct {
+	fakeSQS
+	failing string
+	stopped chan string
+}
+
+func (f *failingQueueSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if queueName(*input.QueueUrl) == f.failing {
+		return nil, errEndOfTest
+	}
+	<-ctx.Done()
+	f.stopped <- *input.QueueUrl
+	return nil, ctx.Err()
+}
+
+func (s *GroupsTest) TestWatchClusterLifecycleEventsStopsWatchers(c *C) {
+	sqsSvc := &failingQueueSQS{failing: "failing", stopped: make(chan string, 1)}
+	cluster := &Cluster{
+		TagName:  "cluster",
+		TagValue: "example",
+		SQS:      sqsSvc,
+		AutoScaling: &fakeAutoScaling{
+			groups: []*autoscaling.Group{{AutoScalingGroupName: aws.String("example")}},
+			hooks: []*autoscaling.LifecycleHook{
+				{NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:012345678901:failing")},
+				{NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:012345678901:working")},
+			},
+		},
+	}
+
+	err := cluster.WatchClusterLifecycleEvents(func(m *LifecycleMessage) (bool, error) {
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	// The watcher of the other queue was stopped before returning.
+	select {
+	case queueURL := <-sqsSvc.stopped:
+		c.Assert(queueURL, Equals, "https://sqs.us-east-1.amazonaws.com/012345678901/working")
+	default:
+		c.Fatal("the other watcher was not stopped")
+	}
+}
+
+func (s *GroupsTest) TestLeaderTieBreak(c *C) {
+	// Instances from one scale out share a launch time, so every member
+	// must break the tie the same way.
+	now := time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC)
+	for _, order := range [][]string{
+		{"i-00000003", "i-00000001", "i-00000002"},
+		{"i-00000002", "i-00000003", "i-00000001"},
+	} {
+		instances := []*ec2.Instance{}
+		for _, instanceID := range order {
+			instances = append(instances, fakeInstance(instanceID, ec2.InstanceStateNameRunning, now))
+		}
+		cluster := &Cluster{TagName: "app", TagValue: "example", EC2: &fakeEC2{instances: instances}}
+		leader, err := cluster.Leader()
+		c.Assert(err, IsNil)
+		c.Assert(*leader.InstanceId, Equals, "i-00000001")
+	}
+}
@@ -0,0 +1,140 @@
+package ec2cluster
+
+import (
+	"log"
+	"time"
+)
+
+// defaultHandoffTagKey is the tag that HandoffCoordinator sets on an
+// instance that is leaving the cluster if TagKey is empty.
+const defaultHandoffTagKey = "ec2cluster-leaving"
+
+// HandoffCoordinator delays the termination of a cluster member until a
+// peer has taken over its work, such as its shards or leases. When an
+// instance is terminating, the coordinator tags it to tell the other
+// members that it is leaving, then waits until Acknowledged reports that a
+// peer has taken over, extending the lifecycle action with heartbeats while
+// it waits.
+//
+// Peers find the members that are leaving with Leaving, or by watching the
+// tag with WatchTags.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents. Set
+// VisibilityRenewalInterval on the Cluster if the handoff may take longer
+// than the visibility timeout of the queue.
+type HandoffCoordinator struct {
+	Cluster *Cluster
+
+	// TagKey is the tag set on an instance that is leaving. Its value is
+	// the time that the handoff started. If empty, `ec2cluster-leaving` is
+	// used.
+	TagKey string
+
+	// Acknowledged returns true once a peer has taken over the work of
+	// instanceID.
+	Acknowledged func(instanceID string) (bool, error)
+
+	// Timeout is how long to wait for acknowledgment. When it elapses, the
+	// lifecycle action is continued anyway. If zero, 30 minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often Acknowledged is called. If zero, ten
+	// seconds is used.
+	PollInterval time.Duration
+
+	// HeartbeatInterval is how often the lifecycle action is extended while
+	// waiting. It must be shorter than the heartbeat timeout of the
+	// lifecycle hook. If zero, one minute is used.
+	HeartbeatInterval time.Duration
+}
+
+func (h *HandoffCoordinator) tagKey() string {
+	if h.TagKey != "" {
+		return h.TagKey
+	}
+	return defaultHandoffTagKey
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that hands off the work of
+// each terminating instance before its lifecycle action is continued. Other
+// events are continued immediately.
+func (h *HandoffCoordinator) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	if m.LifecycleTransition != TransitionTerminating {
+		return true, nil
+	}
+	err := h.Handoff(m)
+	if err == ErrTimeout {
+		log.Printf("timed out waiting for a peer to take over from %s, continuing", m.EC2InstanceID)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Handoff tags the instance of the lifecycle action m as leaving and waits
+// until Acknowledged returns true, recording a heartbeat for the action
+// every HeartbeatInterval. It returns ErrTimeout if Timeout elapses first.
+func (h *HandoffCoordinator) Handoff(m *LifecycleMessage) error {
+	err := h.Cluster.SetTags([]string{m.EC2InstanceID}, map[string]string{
+		h.tagKey(): time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Minute
+	}
+	pollInterval := h.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 10 * time.Second
+	}
+	heartbeatInterval := h.HeartbeatInterval
+	if heartbeatInterval == 0 {
+		heartbeatInterval = time.Minute
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		autoscalingSvc := h.Cluster.autoscalingClient()
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := recordLifecycleActionHeartbeat(autoscalingSvc, m); err != nil {
+				log.Printf("ERROR: RecordLifecycleActionHeartbeat: %s: %s", m.EC2InstanceID, err)
+			}
+		}
+	}()
+
+	return waitUntil(timeout, pollInterval, func() (bool, error) {
+		return h.Acknowledged(m.EC2InstanceID)
+	})
+}
+
+// Leaving returns the members of the cluster that are handing off their
+// work, with the time that each handoff started.
+func (h *HandoffCoordinator) Leaving() (map[string]time.Time, error) {
+	tags, err := h.Cluster.ListClusterTags(h.tagKey())
+	if err != nil {
+		return nil, err
+	}
+	rv := map[string]time.Time{}
+	for instanceID, value := range tags {
+		started, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("ERROR: %s: cannot parse tag %s: %s", instanceID, h.tagKey(), err)
+			continue
+		}
+		rv[instanceID] = started
+	}
+	return rv, nil
+}
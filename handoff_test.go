@@ -0,0 +1,76 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type HandoffTest struct {
+}
+
+var _ = Suite(&HandoffTest{})
+
+func (s *HandoffTest) TestHandoff(c *C) {
+	now := time.Now()
+	ec2Svc := &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now.Add(-time.Hour)),
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now),
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: ec2Svc, AutoScaling: autoscalingSvc}
+
+	// The peer takes over once it sees that i-00000002 is leaving.
+	var h *HandoffCoordinator
+	polls := 0
+	h = &HandoffCoordinator{
+		Cluster: cluster,
+		Acknowledged: func(instanceID string) (bool, error) {
+			polls++
+			leaving, err := h.Leaving()
+			if err != nil {
+				return false, err
+			}
+			_, ok := leaving[instanceID]
+			return ok && polls > 2, nil
+		},
+		PollInterval:      10 * time.Millisecond,
+		HeartbeatInterval: 5 * time.Millisecond,
+	}
+
+	shouldContinue, err := h.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(polls, Equals, 3)
+
+	autoscalingSvc.mu.Lock()
+	defer autoscalingSvc.mu.Unlock()
+	c.Assert(autoscalingSvc.heartbeats > 0, Equals, true)
+}
+
+func (s *HandoffTest) TestHandoffTimeout(c *C) {
+	cluster := &Cluster{EC2: &fakeEC2{}, AutoScaling: &fakeAutoScaling{}}
+	h := &HandoffCoordinator{
+		Cluster: cluster,
+		Acknowledged: func(instanceID string) (bool, error) {
+			return false, nil
+		},
+		Timeout:      20 * time.Millisecond,
+		PollInterval: 5 * time.Millisecond,
+	}
+	c.Assert(h.Handoff(&LifecycleMessage{EC2InstanceID: "i-00000001"}), Equals, ErrTimeout)
+
+	// The action is continued anyway.
+	shouldContinue, err := h.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+}
@@ -0,0 +1,143 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// HealthCheck is a function that checks the health of the application
+// running on the current instance. It returns a non-nil error if the
+// application is unhealthy.
+type HealthCheck func() error
+
+// HealthReporter periodically runs a set of health checks and reports the
+// current instance as Unhealthy to its autoscaling group when they fail
+// persistently, so that the autoscaling group replaces the instance.
+type HealthReporter struct {
+	Cluster *Cluster
+
+	// Checks are the health checks to run. The instance is considered
+	// healthy only if every check passes.
+	Checks []HealthCheck
+
+	// Interval is how often the checks are run. If zero, the checks are
+	// run every ten seconds.
+	Interval time.Duration
+
+	// FailureThreshold is the number of consecutive failed rounds of checks
+	// before the instance is reported Unhealthy. If zero, one failure is
+	// enough.
+	FailureThreshold int
+
+	// RecoveryThreshold is the number of consecutive successful rounds of
+	// checks before an instance that was reported Unhealthy is reported
+	// Healthy again. If zero, the instance is never reported Healthy again,
+	// leaving the autoscaling group to replace it.
+	RecoveryThreshold int
+
+	// ShouldRespectGracePeriod is passed to SetInstanceHealth. When true,
+	// reports made during the health check grace period of the autoscaling
+	// group are ignored.
+	ShouldRespectGracePeriod bool
+
+	state healthState
+}
+
+// Run runs the health checks until ctx is cancelled. Errors from
+// SetInstanceHealth are logged and the report is retried on the next round.
+func (h *HealthReporter) Run(ctx context.Context) error {
+	interval := h.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	h.state.failureThreshold = h.FailureThreshold
+	h.state.recoveryThreshold = h.RecoveryThreshold
+
+	for {
+		if status := h.state.observe(h.check()); status != "" {
+			if err := h.report(status); err != nil {
+				log.Printf("ERROR: SetInstanceHealth: %s", err)
+			} else {
+				h.state.reported = status
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.Cluster.jitter(interval)):
+		}
+	}
+}
+
+// check runs each health check and returns the first error.
+func (h *HealthReporter) check() error {
+	for _, check := range h.Checks {
+		if err := check(); err != nil {
+			log.Printf("health check failed: %s", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HealthReporter) report(status string) error {
+	autoscalingSvc := h.Cluster.autoscalingClient()
+	_, err := autoscalingSvc.SetInstanceHealth(&autoscaling.SetInstanceHealthInput{
+		InstanceId:               aws.String(h.Cluster.InstanceID),
+		HealthStatus:             aws.String(status),
+		ShouldRespectGracePeriod: aws.Bool(h.ShouldRespectGracePeriod),
+	})
+	return err
+}
+
+// Health statuses accepted by SetInstanceHealth.
+const (
+	healthStatusHealthy   = "Healthy"
+	healthStatusUnhealthy = "Unhealthy"
+)
+
+// healthState tracks consecutive health check results and decides when the
+// health status should be reported.
+type healthState struct {
+	failureThreshold  int
+	recoveryThreshold int
+
+	// reported is the last status successfully reported, or "" if nothing
+	// has been reported yet, which means the instance is assumed healthy.
+	reported  string
+	failures  int
+	successes int
+}
+
+// observe records the result of one round of checks and returns the status
+// that should be reported, or "" if nothing should be reported.
+func (hs *healthState) observe(err error) string {
+	if err != nil {
+		hs.failures++
+		hs.successes = 0
+	} else {
+		hs.successes++
+		hs.failures = 0
+	}
+
+	if hs.reported != healthStatusUnhealthy {
+		threshold := hs.failureThreshold
+		if threshold < 1 {
+			threshold = 1
+		}
+		if hs.failures >= threshold {
+			return healthStatusUnhealthy
+		}
+		return ""
+	}
+
+	if hs.recoveryThreshold > 0 && hs.successes >= hs.recoveryThreshold {
+		return healthStatusHealthy
+	}
+	return ""
+}
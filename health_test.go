@@ -0,0 +1,44 @@
+package ec2cluster
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type HealthTest struct {
+}
+
+var _ = Suite(&HealthTest{})
+
+func (s *HealthTest) TestFailureThreshold(c *C) {
+	failed := errors.New("failed")
+	hs := healthState{failureThreshold: 3}
+
+	c.Assert(hs.observe(failed), Equals, "")
+	c.Assert(hs.observe(failed), Equals, "")
+	c.Assert(hs.observe(nil), Equals, "")
+	c.Assert(hs.observe(failed), Equals, "")
+	c.Assert(hs.observe(failed), Equals, "")
+	c.Assert(hs.observe(failed), Equals, healthStatusUnhealthy)
+}
+
+func (s *HealthTest) TestRecovery(c *C) {
+	failed := errors.New("failed")
+	hs := healthState{recoveryThreshold: 2}
+
+	c.Assert(hs.observe(failed), Equals, healthStatusUnhealthy)
+	hs.reported = healthStatusUnhealthy
+	c.Assert(hs.observe(failed), Equals, "")
+	c.Assert(hs.observe(nil), Equals, "")
+	c.Assert(hs.observe(nil), Equals, healthStatusHealthy)
+	hs.reported = healthStatusHealthy
+	c.Assert(hs.observe(nil), Equals, "")
+}
+
+func (s *HealthTest) TestNoRecovery(c *C) {
+	hs := healthState{reported: healthStatusUnhealthy}
+	for i := 0; i < 10; i++ {
+		c.Assert(hs.observe(nil), Equals, "")
+	}
+}
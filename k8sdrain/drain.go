@@ -0,0 +1,215 @@
+// Package k8sdrain cordons and drains the Kubernetes node that runs on an
+// EC2 instance before the instance is terminated by its autoscaling group.
+//
+// It lives in its own package so that users of ec2cluster who don't run
+// Kubernetes don't need to depend on client-go. To use it, pass
+// HandleLifecycleEvent to WatchLifecycleEvents:
+//
+//	drainer := &k8sdrain.Drainer{Client: clientset, Timeout: 10 * time.Minute}
+//	err := cluster.WatchLifecycleEvents(queueURL, drainer.HandleLifecycleEvent)
+package k8sdrain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/crewjam/ec2cluster"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mirrorPodAnnotation marks static pods, which are managed by the kubelet
+// and cannot be evicted through the API.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// Drainer cordons and drains Kubernetes nodes. Pods are removed with the
+// eviction API, so PodDisruptionBudgets are respected: evictions that would
+// violate a budget are retried until they succeed or Timeout elapses.
+// Pods owned by a DaemonSet and mirror pods are left alone.
+type Drainer struct {
+	Client kubernetes.Interface
+
+	// Timeout is how long to wait for the node to drain. When it elapses,
+	// the lifecycle action is continued anyway, since the instance is going
+	// away regardless. If zero, five minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often evictions are retried and the node is
+	// checked for remaining pods. If zero, five seconds is used.
+	PollInterval time.Duration
+
+	// NodeName, if not nil, returns the name of the node running on the
+	// specified instance. Otherwise the node is found by matching the
+	// instance ID against the provider ID of each node.
+	NodeName func(instanceID string) (string, error)
+}
+
+// HandleLifecycleEvent is an ec2cluster.LifecyleEventCallback that drains the
+// node of each terminating instance. Other events are continued immediately.
+func (d *Drainer) HandleLifecycleEvent(m *ec2cluster.LifecycleMessage) (bool, error) {
+	if m.LifecycleTransition != ec2cluster.TransitionTerminating {
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+	defer cancel()
+
+	// Requests that were cut short by the timeout fail with errors that do
+	// not always wrap context.DeadlineExceeded, so the context is checked
+	// rather than the error.
+	err := d.Drain(ctx, m.EC2InstanceID)
+	if err != nil && ctx.Err() != nil {
+		log.Printf("k8sdrain: timed out draining %s, continuing", m.EC2InstanceID)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Drain cordons the node running on the instance and evicts its pods,
+// returning once the pods are gone or ctx is done. If there is no node for
+// the instance, Drain returns nil.
+func (d *Drainer) Drain(ctx context.Context, instanceID string) error {
+	nodeName, err := d.nodeName(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if nodeName == "" {
+		log.Printf("k8sdrain: no node found for %s", instanceID)
+		return nil
+	}
+
+	if err := d.cordon(ctx, nodeName); err != nil {
+		return err
+	}
+
+	for {
+		pods, err := d.evictablePods(ctx, nodeName)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			return nil
+		}
+		for _, pod := range pods {
+			if err := d.evict(ctx, pod); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.pollInterval()):
+		}
+	}
+}
+
+func (d *Drainer) timeout() time.Duration {
+	if d.Timeout == 0 {
+		return 5 * time.Minute
+	}
+	return d.Timeout
+}
+
+func (d *Drainer) pollInterval() time.Duration {
+	if d.PollInterval == 0 {
+		return 5 * time.Second
+	}
+	return d.PollInterval
+}
+
+// nodeName returns the name of the node running on the instance, or an
+// empty string if there is none.
+func (d *Drainer) nodeName(ctx context.Context, instanceID string) (string, error) {
+	if d.NodeName != nil {
+		return d.NodeName(instanceID)
+	}
+
+	// Provider IDs of EC2 nodes look like `aws:///us-west-2a/i-403e6d87`.
+	nodes, err := d.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		if strings.HasSuffix(node.Spec.ProviderID, "/"+instanceID) {
+			return node.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// cordon marks the node unschedulable so that no new pods are placed on it.
+func (d *Drainer) cordon(ctx context.Context, nodeName string) error {
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	_, err := d.Client.CoreV1().Nodes().Patch(ctx, nodeName,
+		types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("cordon %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// evictablePods returns the pods on the node that need to be evicted.
+func (d *Drainer) evictablePods(ctx context.Context, nodeName string) ([]corev1.Pod, error) {
+	pods, err := d.Client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rv := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		if isDaemonSetPod(pod) {
+			continue
+		}
+		rv = append(rv, pod)
+	}
+	return rv, nil
+}
+
+// evict requests eviction of the pod. Evictions refused because of a
+// PodDisruptionBudget are not an error; they are retried by Drain.
+func (d *Drainer) evict(ctx context.Context, pod corev1.Pod) error {
+	err := d.Client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	})
+	switch {
+	case err == nil, apierrors.IsNotFound(err):
+		return nil
+	case apierrors.IsTooManyRequests(err):
+		log.Printf("k8sdrain: cannot evict %s/%s yet: %s", pod.Namespace, pod.Name, err)
+		return nil
+	default:
+		return fmt.Errorf("evict %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+}
+
+func isDaemonSetPod(pod corev1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package k8sdrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/crewjam/ec2cluster"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type DrainTest struct {
+}
+
+var _ = Suite(&DrainTest{})
+
+func newPod(name string, ownerKind string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "ip-10-0-0-12"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: name}}
+	}
+	return pod
+}
+
+func (s *DrainTest) TestDrain(c *C) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-0-12"},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/i-1a2b3c4d"},
+	}
+	client := fake.NewSimpleClientset(node,
+		newPod("web", "ReplicaSet"),
+		newPod("agent", "DaemonSet"))
+
+	evicted := []string{}
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		evicted = append(evicted, eviction.Name)
+		err := client.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"),
+			eviction.Namespace, eviction.Name)
+		return true, nil, err
+	})
+
+	d := &Drainer{Client: client, PollInterval: time.Millisecond}
+	shouldContinue, err := d.HandleLifecycleEvent(&ec2cluster.LifecycleMessage{
+		LifecycleTransition: ec2cluster.TransitionTerminating,
+		EC2InstanceID:       "i-1a2b3c4d",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(evicted, DeepEquals, []string{"web"})
+
+	node, err = client.CoreV1().Nodes().Get(context.Background(), "ip-10-0-0-12", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(node.Spec.Unschedulable, Equals, true)
+}
+
+func (s *DrainTest) TestDrainTimeout(c *C) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-0-12"},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/i-1a2b3c4d"},
+	}
+	client := fake.NewSimpleClientset(node, newPod("web", "ReplicaSet"))
+
+	// The eviction outlasts the timeout and fails the way a request whose
+	// context expired does.
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+		return true, nil, context.DeadlineExceeded
+	})
+
+	d := &Drainer{Client: client, Timeout: 10 * time.Millisecond, PollInterval: time.Millisecond}
+	shouldContinue, err := d.HandleLifecycleEvent(&ec2cluster.LifecycleMessage{
+		LifecycleTransition: ec2cluster.TransitionTerminating,
+		EC2InstanceID:       "i-1a2b3c4d",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+}
+
+func (s *DrainTest) TestUnknownNode(c *C) {
+	client := fake.NewSimpleClientset()
+	d := &Drainer{Client: client}
+	shouldContinue, err := d.HandleLifecycleEvent(&ec2cluster.LifecycleMessage{
+		LifecycleTransition: ec2cluster.TransitionTerminating,
+		EC2InstanceID:       "i-fefefefe",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+}
@@ -0,0 +1,176 @@
+package ec2cluster
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// LaunchSpec describes how the autoscaling group of the current instance
+// launches new instances, whether from a launch template or a launch
+// configuration.
+type LaunchSpec struct {
+	// LaunchTemplateID, LaunchTemplateName and LaunchTemplateVersion
+	// identify the launch template, if the group uses one. The version is
+	// the number that `$Latest` or `$Default` resolved to.
+	LaunchTemplateID      string
+	LaunchTemplateName    string
+	LaunchTemplateVersion string
+
+	// LaunchConfigurationName is the name of the launch configuration, if
+	// the group uses one.
+	LaunchConfigurationName string
+
+	ImageID string
+
+	// InstanceTypes are the instance types that the group launches. Groups
+	// with a mixed instances policy may launch several.
+	InstanceTypes []string
+
+	// UserData is the decoded user data passed to new instances.
+	UserData string
+
+	// PlacementGroup is the placement group of the launch template, if
+	// any. See GroupPlacement, which also considers the placement group of
+	// the autoscaling group.
+	PlacementGroup string
+
+	// CapacityReservationPreference, CapacityReservationID and
+	// CapacityReservationResourceGroupARN are the capacity reservation
+	// targeting of the launch template, if any.
+	CapacityReservationPreference       string
+	CapacityReservationID               string
+	CapacityReservationResourceGroupARN string
+}
+
+// LaunchSpec returns a description of how the autoscaling group of the
+// current instance launches new instances, so that tooling can compare
+// running instances against it.
+func (s *Cluster) LaunchSpec() (*LaunchSpec, error) {
+	asg, err := s.AutoscalingGroup()
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
+		return nil, ErrNotInAutoscalingGroup
+	}
+
+	launchTemplate, err := s.LaunchTemplate()
+	if err != nil {
+		return nil, err
+	}
+	var spec *LaunchSpec
+	if launchTemplate != nil {
+		spec, err = s.launchTemplateSpec(launchTemplate)
+	} else {
+		spec, err = s.launchConfigurationSpec(aws.StringValue(asg.LaunchConfigurationName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		instanceTypes := []string{}
+		for _, override := range asg.MixedInstancesPolicy.LaunchTemplate.Overrides {
+			if override.InstanceType != nil {
+				instanceTypes = append(instanceTypes, *override.InstanceType)
+			}
+		}
+		if len(instanceTypes) > 0 {
+			spec.InstanceTypes = instanceTypes
+		}
+	}
+	return spec, nil
+}
+
+func (s *Cluster) launchTemplateSpec(launchTemplate *autoscaling.LaunchTemplateSpecification) (*LaunchSpec, error) {
+	version := aws.StringValue(launchTemplate.Version)
+	if version == "" {
+		version = "$Default"
+	}
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []*string{aws.String(version)},
+	}
+	if launchTemplate.LaunchTemplateId != nil {
+		input.LaunchTemplateId = launchTemplate.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = launchTemplate.LaunchTemplateName
+	}
+
+	resp, err := s.ec2Client().DescribeLaunchTemplateVersions(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.LaunchTemplateVersions) != 1 {
+		return nil, &NotFoundError{
+			Kind: "version " + version + " of launch template",
+			ID:   aws.StringValue(launchTemplate.LaunchTemplateName),
+		}
+	}
+	templateVersion := resp.LaunchTemplateVersions[0]
+
+	spec := &LaunchSpec{
+		LaunchTemplateID:      aws.StringValue(templateVersion.LaunchTemplateId),
+		LaunchTemplateName:    aws.StringValue(templateVersion.LaunchTemplateName),
+		LaunchTemplateVersion: strconv.FormatInt(aws.Int64Value(templateVersion.VersionNumber), 10),
+	}
+	if data := templateVersion.LaunchTemplateData; data != nil {
+		spec.ImageID = aws.StringValue(data.ImageId)
+		if data.InstanceType != nil {
+			spec.InstanceTypes = []string{*data.InstanceType}
+		}
+		spec.UserData, err = decodeUserData(aws.StringValue(data.UserData))
+		if err != nil {
+			return nil, err
+		}
+		if data.Placement != nil {
+			spec.PlacementGroup = aws.StringValue(data.Placement.GroupName)
+		}
+		if reservation := data.CapacityReservationSpecification; reservation != nil {
+			spec.CapacityReservationPreference = aws.StringValue(reservation.CapacityReservationPreference)
+			if target := reservation.CapacityReservationTarget; target != nil {
+				spec.CapacityReservationID = aws.StringValue(target.CapacityReservationId)
+				spec.CapacityReservationResourceGroupARN = aws.StringValue(target.CapacityReservationResourceGroupArn)
+			}
+		}
+	}
+	return spec, nil
+}
+
+func (s *Cluster) launchConfigurationSpec(name string) (*LaunchSpec, error) {
+	resp, err := s.autoscalingClient().DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.LaunchConfigurations) != 1 {
+		return nil, &NotFoundError{Kind: "launch configuration", ID: name}
+	}
+	launchConfiguration := resp.LaunchConfigurations[0]
+
+	spec := &LaunchSpec{
+		LaunchConfigurationName: name,
+		ImageID:                 aws.StringValue(launchConfiguration.ImageId),
+		InstanceTypes:           []string{aws.StringValue(launchConfiguration.InstanceType)},
+	}
+	spec.UserData, err = decodeUserData(aws.StringValue(launchConfiguration.UserData))
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// decodeUserData decodes user data as returned by the EC2 and autoscaling
+// APIs, which is base64 encoded.
+func decodeUserData(userData string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(userData)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode user data: %s", err)
+	}
+	return string(buf), nil
+}
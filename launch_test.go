@@ -0,0 +1,91 @@
+package ec2cluster
+
+import (
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type LaunchTest struct {
+}
+
+var _ = Suite(&LaunchTest{})
+
+func (s *LaunchTest) TestLaunchTemplate(c *C) {
+	cluster := &Cluster{
+		EC2: &fakeEC2{
+			launchTemplateVersions: []*ec2.LaunchTemplateVersion{
+				{
+					LaunchTemplateId:   aws.String("lt-0123456789abcdef0"),
+					LaunchTemplateName: aws.String("example"),
+					VersionNumber:      aws.Int64(1),
+				},
+				{
+					LaunchTemplateId:   aws.String("lt-0123456789abcdef0"),
+					LaunchTemplateName: aws.String("example"),
+					VersionNumber:      aws.Int64(2),
+					LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+						ImageId:      aws.String("ami-00000002"),
+						InstanceType: aws.String("m5.large"),
+						UserData:     aws.String(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n"))),
+					},
+				},
+			},
+		},
+		autoScalingGroup: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("example"),
+			MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+						LaunchTemplateId: aws.String("lt-0123456789abcdef0"),
+						Version:          aws.String("$Latest"),
+					},
+					Overrides: []*autoscaling.LaunchTemplateOverrides{
+						{InstanceType: aws.String("m5.large")},
+						{InstanceType: aws.String("m5a.large")},
+					},
+				},
+			},
+		},
+	}
+
+	spec, err := cluster.LaunchSpec()
+	c.Assert(err, IsNil)
+	c.Assert(spec, DeepEquals, &LaunchSpec{
+		LaunchTemplateID:      "lt-0123456789abcdef0",
+		LaunchTemplateName:    "example",
+		LaunchTemplateVersion: "2",
+		ImageID:               "ami-00000002",
+		InstanceTypes:         []string{"m5.large", "m5a.large"},
+		UserData:              "#!/bin/sh\n",
+	})
+}
+
+func (s *LaunchTest) TestLaunchConfiguration(c *C) {
+	cluster := &Cluster{
+		AutoScaling: &fakeAutoScaling{
+			launchConfigurations: []*autoscaling.LaunchConfiguration{{
+				LaunchConfigurationName: aws.String("example-1"),
+				ImageId:                 aws.String("ami-00000001"),
+				InstanceType:            aws.String("t3.micro"),
+				UserData:                aws.String(base64.StdEncoding.EncodeToString([]byte("hello"))),
+			}},
+		},
+		autoScalingGroup: &autoscaling.Group{
+			AutoScalingGroupName:    aws.String("example"),
+			LaunchConfigurationName: aws.String("example-1"),
+		},
+	}
+
+	spec, err := cluster.LaunchSpec()
+	c.Assert(err, IsNil)
+	c.Assert(spec, DeepEquals, &LaunchSpec{
+		LaunchConfigurationName: "example-1",
+		ImageID:                 "ami-00000001",
+		InstanceTypes:           []string{"t3.micro"},
+		UserData:                "hello",
+	})
+}
@@ -0,0 +1,80 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// WatchLifecycleEventsAsLeader is like WatchLifecycleEventsContext, but only
+// receives from the queue while the current instance is the leader of the
+// cluster, as returned by IsLeader. This suits fleets where every member
+// runs the watcher: only one of them consumes the shared queue at a time,
+// and another takes over when the leader leaves the cluster.
+//
+// Leadership is checked every interval. When the current instance stops
+// being the leader, the watcher stops receiving; a callback that is running
+// is allowed to finish and its lifecycle action is completed. Errors from
+// IsLeader are logged and the current role is kept until the next check.
+func (s *Cluster) WatchLifecycleEventsAsLeader(ctx context.Context, queueURL string, interval time.Duration, cb LifecycleEventContextCallback) error {
+	var stop context.CancelFunc
+	var done chan error
+	defer func() {
+		if stop != nil {
+			stop()
+			<-done
+		}
+	}()
+
+	for {
+		isLeader, err := s.IsLeader()
+		switch {
+		case err != nil:
+			log.Printf("ERROR: cannot determine the leader: %s", err)
+		case isLeader && stop == nil:
+			log.Printf("%s is the leader, watching %s", s.InstanceID, queueURL)
+			stop, done = s.startWatching(ctx, queueURL, cb)
+		case !isLeader && stop != nil:
+			log.Printf("%s is no longer the leader, stopping", s.InstanceID)
+			stop()
+			<-done
+			stop, done = nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-done:
+			stop()
+			stop, done = nil, nil
+			return err
+		case <-time.After(s.jitter(interval)):
+		}
+	}
+}
+
+// startWatching runs WatchLifecycleEventsContext in the background. It
+// returns a function that stops it and a channel that receives its result.
+func (s *Cluster) startWatching(ctx context.Context, queueURL string, cb LifecycleEventContextCallback) (context.CancelFunc, chan error) {
+	watchCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.WatchLifecycleEventsContext(watchCtx, queueURL, cb)
+	}()
+	return stop, done
+}
+
+// waitStartupJitter waits for a random time of up to StartupJitter, or until
+// ctx is done.
+func (s *Cluster) waitStartupJitter(ctx context.Context) error {
+	if s.StartupJitter <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(rand.Int63n(int64(s.StartupJitter)))):
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type LeaderTest struct {
+}
+
+var _ = Suite(&LeaderTest{})
+
+// blockingSQS signals receiving when a receive starts and stopped when it is
+// cancelled. Receives return no messages until they are cancelled.
+type blockingSQS struct {
+	fakeSQS
+	receiving chan struct{}
+	stopped   chan struct{}
+}
+
+func (f *blockingSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.receiving <- struct{}{}
+	<-ctx.Done()
+	f.stopped <- struct{}{}
+	return nil, ctx.Err()
+}
+
+func (s *LeaderTest) TestWatchLifecycleEventsAsLeader(c *C) {
+	now := time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC)
+	older := fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now.Add(-time.Hour))
+	self := fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now)
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(self)
+	sqsSvc := &blockingSQS{receiving: make(chan struct{}, 1), stopped: make(chan struct{}, 1)}
+	cluster := &Cluster{
+		InstanceID:  "i-00000002",
+		TagName:     "app",
+		TagValue:    "example",
+		EC2:         ec2Svc,
+		SQS:         sqsSvc,
+		AutoScaling: &fakeAutoScaling{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cluster.WatchLifecycleEventsAsLeader(ctx, "https://sqs.us-east-1.amazonaws.com/012345678901/example", time.Millisecond,
+			func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+				return true, nil
+			})
+	}()
+
+	// The current instance is the only member, so it watches the queue.
+	<-sqsSvc.receiving
+
+	// An older member becomes the leader.
+	ec2Svc.setInstances(older, self)
+	<-sqsSvc.stopped
+	select {
+	case <-sqsSvc.receiving:
+		c.Fatal("watching while not the leader")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The older member leaves and the current instance takes over.
+	ec2Svc.setInstances(self)
+	<-sqsSvc.receiving
+
+	cancel()
+	c.Assert(<-errCh, Equals, context.Canceled)
+}
+
+func (s *LeaderTest) TestStartupJitter(c *C) {
+	cluster := &Cluster{StartupJitter: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	c.Assert(cluster.waitStartupJitter(ctx), Equals, context.DeadlineExceeded)
+
+	cluster.StartupJitter = 0
+	c.Assert(cluster.waitStartupJitter(context.Background()), IsNil)
+}
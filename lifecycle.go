@@ -1,16 +1,18 @@
 package ec2cluster
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 // LifecycleMessage represents the message we receive from the
@@ -26,8 +28,50 @@ type LifecycleMessage struct {
 	LifecycleActionToken string    `json:",omitempty"`
 	EC2InstanceID        string    `json:"EC2InstanceID"`
 	LifecycleHookName    string    `json:",omitempty"`
+
+	// Origin and Destination are reported for autoscaling groups with a
+	// warm pool, and are one of OriginEC2, OriginAutoScalingGroup or
+	// OriginWarmPool. For example, an instance launched from the warm pool
+	// into the group has an Origin of OriginWarmPool and a Destination of
+	// OriginAutoScalingGroup.
+	Origin      string `json:",omitempty"`
+	Destination string `json:",omitempty"`
+
+	// MessageAttributes are the String and Number attributes of the SQS
+	// message that carried the lifecycle action, set by whoever sent it to
+	// the queue. They are not part of the lifecycle hook message.
+	MessageAttributes map[string]string `json:",omitempty"`
+}
+
+// Lifecycle transitions reported by autoscaling lifecycle hooks.
+const (
+	TransitionLaunching   = "autoscaling:EC2_INSTANCE_LAUNCHING"
+	TransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+)
+
+// Values of the Origin and Destination of a LifecycleMessage.
+const (
+	OriginEC2              = "EC2"
+	OriginAutoScalingGroup = "AutoScalingGroup"
+	OriginWarmPool         = "WarmPool"
+)
+
+// TransitionFilter returns true if lifecycle actions like m should be
+// passed to the lifecycle event callbacks.
+type TransitionFilter func(m *LifecycleMessage) bool
+
+// DefaultTransitionFilter accepts TransitionLaunching and
+// TransitionTerminating, including transitions to and from a warm pool.
+func DefaultTransitionFilter(m *LifecycleMessage) bool {
+	return m.LifecycleTransition == TransitionLaunching || m.LifecycleTransition == TransitionTerminating
 }
 
+// Results that a lifecycle action can be completed with.
+const (
+	ResultContinue = "CONTINUE"
+	ResultAbandon  = "ABANDON"
+)
+
 var ErrLifecycleHookNotFound = errors.New("cannot find a suitable lifecycle hook")
 
 // LifecyleEventCallback is a function that is invoked for each
@@ -37,6 +81,20 @@ var ErrLifecycleHookNotFound = errors.New("cannot find a suitable lifecycle hook
 // otherwise it is invoked with `ABANDON`.
 type LifecyleEventCallback func(m *LifecycleMessage) (shouldContinue bool, err error)
 
+// LifecycleEventContextCallback is like LifecyleEventCallback, but is passed
+// a context that is cancelled, when AbortOnVisibilityRenewalError is set, if
+// the message may have been delivered to another consumer. It is not
+// cancelled when the watcher is stopped, so that an action in progress can
+// finish.
+type LifecycleEventContextCallback func(ctx context.Context, m *LifecycleMessage) (shouldContinue bool, err error)
+
+// withContext adapts cb to a LifecycleEventContextCallback.
+func (cb LifecyleEventCallback) withContext() LifecycleEventContextCallback {
+	return func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		return cb(m)
+	}
+}
+
 // LifecycleEventQueueURL inspects the current autoscaling group and returns
 // the URL of the first suitable lifecycle hook queue.
 func (s *Cluster) LifecycleEventQueueURL() (string, error) {
@@ -44,18 +102,37 @@ func (s *Cluster) LifecycleEventQueueURL() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if asg == nil {
+		return "", ErrNotInAutoscalingGroup
+	}
 
-	autoscalingSvc := autoscaling.New(s.AwsSession)
+	queueURLs, err := s.lifecycleHookQueueURLs(*asg.AutoScalingGroupName)
+	if err != nil {
+		return "", err
+	}
+	if len(queueURLs) == 0 {
+		return "", ErrLifecycleHookNotFound
+	}
+	return queueURLs[0], nil
+}
+
+// lifecycleHookQueueURLs returns the URL of the SQS queue of each lifecycle
+// hook of the named autoscaling group. DescribeLifecycleHooks is not
+// paginated; it returns every hook of the group, of which there are at
+// most 50.
+func (s *Cluster) lifecycleHookQueueURLs(autoscalingGroupName string) ([]string, error) {
+	autoscalingSvc := s.autoscalingClient()
 	resp, err := autoscalingSvc.DescribeLifecycleHooks(&autoscaling.DescribeLifecycleHooksInput{
-		AutoScalingGroupName: asg.AutoScalingGroupName,
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
 	})
 	if err != nil {
-		return "", err
+		return nil, wrapAPIError("DescribeLifecycleHooks", err)
 	}
 
-	sqsSvc := sqs.New(s.AwsSession)
+	sqsSvc := s.sqsClient()
+	queueURLs := []string{}
 	for _, hook := range resp.LifecycleHooks {
-		if !strings.HasPrefix(*hook.NotificationTargetARN, "arn:aws:sqs:") {
+		if !strings.HasPrefix(aws.StringValue(hook.NotificationTargetARN), "arn:aws:sqs:") {
 			continue
 		}
 		arnParts := strings.Split(*hook.NotificationTargetARN, ":")
@@ -67,73 +144,357 @@ func (s *Cluster) LifecycleEventQueueURL() (string, error) {
 			QueueOwnerAWSAccountId: &queueOwnerAWSAccountID,
 		})
 		if err != nil {
-			return "", err
+			return nil, wrapAPIError("GetQueueUrl", err)
 		}
-		return *resp.QueueUrl, nil
+		queueURLs = append(queueURLs, *resp.QueueUrl)
 	}
-	return "", ErrLifecycleHookNotFound
+	return queueURLs, nil
 }
 
+// maxReceiveMessages is the largest number of messages that SQS will
+// return from a single ReceiveMessage call, and also the largest number
+// of entries accepted by DeleteMessageBatch.
+const maxReceiveMessages = 10
+
 // WatchLifecycleEvents monitors a lifecycle event SQS queue and invokes
-// cb for each event. If the callback returns an error, then the
-// lifecycle action is completed with ABANDON. On success, the event is
-// completed with CONTINUE.
+// cb for each event. If cb returns true, the lifecycle action is completed
+// with CONTINUE, and if it returns false, with ABANDON. If cb returns an
+// error, the action is not completed and its message is left in the queue,
+// so that it is delivered again once its visibility timeout expires.
+//
+// Messages are received in batches of up to ten. Once every message in
+// a batch has been handled, the ones that are finished are removed from
+// the queue with a single DeleteMessageBatch call.
+//
+// If the queue is deleted, for example because it is being recreated, the
+// URL of the queue with the same name is looked up again. If
+// QueueURLRefreshInterval is set, the URL is also looked up periodically.
+//
+// If VisibilityRenewalInterval is set, the visibility timeout of each
+// message is extended while cb runs, so that slow callbacks do not cause
+// the message to be delivered to another consumer.
+//
+// FIFO queues, whose names end in `.fifo`, are supported. Messages of the
+// same message group, for example the events of one instance, are handled
+// in order: if cb fails for a message, the later messages of its group are
+// left in the queue with it. Duplicate messages are removed by SQS when
+// they are sent, so the watcher does not deduplicate them.
+//
+// The String and Number attributes of each message are passed to cb as
+// MessageAttributes. If MessageAttributeFilter is set, messages without
+// matching attributes are handed back to the queue as described for
+// WatchOwnedLifecycleEvents.
+//
+// If DryRun is set, cb is invoked but lifecycle actions are not completed
+// and messages are not removed from the queue.
 func (s *Cluster) WatchLifecycleEvents(queueURL string, cb LifecyleEventCallback) error {
-	sqsSvc := sqs.New(s.AwsSession)
-	autoscalingSvc := autoscaling.New(s.AwsSession)
+	return s.WatchLifecycleEventsContext(context.Background(), queueURL, cb.withContext())
+}
+
+// WatchLifecycleEventsContext is like WatchLifecycleEvents, but passes a
+// context to cb and stops when ctx is cancelled. A callback that is running
+// when ctx is cancelled is allowed to finish and its lifecycle action is
+// completed before WatchLifecycleEventsContext returns ctx.Err(); the rest
+// of the batch is made visible again at once for another consumer.
+func (s *Cluster) WatchLifecycleEventsContext(ctx context.Context, queueURL string, cb LifecycleEventContextCallback) error {
+	return s.watchLifecycleEvents(ctx, s.newLifecycleQueue(queueURL, s.queueURLResolver(queueURL)), nil, cb)
+}
+
+// watchLifecycleEvents receives from queue and invokes cb for each
+// lifecycle action. If owns is not nil, actions for which it returns false
+// are not passed to cb; see releaseForeignMessages.
+func (s *Cluster) watchLifecycleEvents(ctx context.Context, queue *lifecycleQueue, owns func(m *LifecycleMessage) bool, cb LifecycleEventContextCallback) error {
+	sqsSvc := s.sqsClient()
+	autoscalingSvc := s.autoscalingClient()
+	foreign := newMessageSet(maxForeignMessages)
+	dryRun := newMessageSet(maxForeignMessages)
+	backoff := &receiveBackoff{cluster: s}
+	var receiveAttemptID string
+	if err := s.waitStartupJitter(ctx); err != nil {
+		return err
+	}
 
 	for {
-		resp, err := sqsSvc.ReceiveMessage(&sqs.ReceiveMessageInput{
-			QueueUrl:            &queueURL,
-			MaxNumberOfMessages: aws.Int64(1),
-			WaitTimeSeconds:     aws.Int64(20),
-		})
+		queue.refresh()
+		queueURL := queue.url
+		fifo := isFIFOQueue(queueURL)
+		input := s.receiveInput(queueURL)
+		if fifo {
+			// Retrying a receive with the same attempt ID returns the
+			// same messages, rather than hiding them until their
+			// visibility timeout expires.
+			if receiveAttemptID == "" {
+				receiveAttemptID = newReceiveAttemptID()
+			}
+			input.ReceiveRequestAttemptId = aws.String(receiveAttemptID)
+			input.AttributeNames = []*string{aws.String(sqs.MessageSystemAttributeNameMessageGroupId)}
+		}
+		resp, err := sqsSvc.ReceiveMessageWithContext(ctx, input)
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := queue.recover(ctx, err); err != nil {
+				return wrapAPIError("ReceiveMessage", err)
+			}
+			if queue.url != queueURL {
+				receiveAttemptID = ""
+			}
+			continue
+		}
+		receiveAttemptID = ""
+		queue.received()
+		s.progress()
+		if err := backoff.received(ctx, len(resp.Messages)); err != nil {
 			return err
 		}
-		for _, messageWrapper := range resp.Messages {
-			m := LifecycleMessage{}
-			if err := json.Unmarshal([]byte(*messageWrapper.Body), &m); err != nil {
-				return fmt.Errorf("cannot unmarshal event: %s", err)
+
+		// done holds the messages in this batch that have been handled
+		// completely and should be removed from the queue. Messages whose
+		// callback failed are left out so that they are delivered again.
+		// notOwned holds the messages that MessageAttributeFilter or owns
+		// rejected.
+		//
+		// On a FIFO queue, once a message of a message group is not
+		// removed, the later messages of the group in the batch are
+		// skipped as well, so that they are delivered again in order.
+		//
+		// unhandled holds the rest of the batch when ctx is cancelled,
+		// which is made visible again at once for another consumer.
+		done := []*sqs.Message{}
+		notOwned := []*sqs.Message{}
+		unhandled := []*sqs.Message{}
+		blockedGroups := map[string]bool{}
+		var handleErr error
+		for i, messageWrapper := range resp.Messages {
+			if ctx.Err() != nil {
+				unhandled = pendingMessages(resp.Messages[i:], fifo, blockedGroups)
+				handleErr = ctx.Err()
+				break
 			}
-			if m.LifecycleTransition != "autoscaling:EC2_INSTANCE_LAUNCHING" && m.LifecycleTransition != "autoscaling:EC2_INSTANCE_TERMINATING" {
-				_, err := sqsSvc.DeleteMessage(&sqs.DeleteMessageInput{
-					QueueUrl:      &queueURL,
-					ReceiptHandle: messageWrapper.ReceiptHandle,
-				})
-				if err != nil {
-					log.Printf("DeleteMessage: %s", err)
-				}
+			groupID := messageGroupID(messageWrapper)
+			if fifo && blockedGroups[groupID] {
 				continue
 			}
-
-			shouldContinue, err := cb(&m)
+			if !matchesAttributeFilter(messageWrapper, s.MessageAttributeFilter) {
+				notOwned = append(notOwned, messageWrapper)
+				blockedGroups[groupID] = true
+				continue
+			}
+			m, remove, err := s.lifecycleAction(messageWrapper)
 			if err != nil {
+				handleErr = err
+				break
+			}
+			if m == nil {
+				if remove {
+					done = append(done, messageWrapper)
+				} else {
+					blockedGroups[groupID] = true
+				}
 				continue
 			}
-			lifecycleActionResult := "CONTINUE"
-			if !shouldContinue {
-				lifecycleActionResult = "ABANDON"
+			if owns != nil && !owns(m) {
+				notOwned = append(notOwned, messageWrapper)
+				blockedGroups[groupID] = true
+				continue
 			}
-
-			_, err = autoscalingSvc.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
-				AutoScalingGroupName:  &m.AutoScalingGroupName,
-				LifecycleActionResult: aws.String(lifecycleActionResult),
-				LifecycleHookName:     &m.LifecycleHookName,
-				InstanceId:            &m.EC2InstanceID,
-				LifecycleActionToken:  &m.LifecycleActionToken,
-			})
-			if err != nil {
-				log.Printf("ERROR: CompleteLifecycleAction: %s", err)
+			pending := pendingMessages(resp.Messages[i+1:], fifo, blockedGroups)
+			if s.DryRun {
+				// Each lifecycle action is passed to cb once, even though
+				// its message stays in the queue.
+				if !dryRun.has(aws.StringValue(messageWrapper.MessageId)) {
+					if !s.dryRunLifecycleAction(context.WithoutCancel(ctx), sqsSvc, queueURL, messageWrapper, pending, m, cb) {
+						blockedGroups[groupID] = true
+						continue
+					}
+					dryRun.add(aws.StringValue(messageWrapper.MessageId))
+				}
+				done = append(done, messageWrapper)
+				continue
 			}
-
-			_, err = sqsSvc.DeleteMessage(&sqs.DeleteMessageInput{
-				QueueUrl:      &queueURL,
-				ReceiptHandle: messageWrapper.ReceiptHandle,
-			})
-			if err != nil {
-				return err
+			if s.handleLifecycleAction(context.WithoutCancel(ctx), sqsSvc, autoscalingSvc, queueURL, messageWrapper, pending, m, cb) {
+				done = append(done, messageWrapper)
+			} else {
+				blockedGroups[groupID] = true
 			}
 		}
+
+		s.releaseForeignMessages(sqsSvc, queueURL, foreign, notOwned)
+		changeMessageVisibility(sqsSvc, queueURL, unhandled, aws.Int64(0))
+		if s.DryRun {
+			s.hideMessages(sqsSvc, queueURL, done)
+		} else if err := deleteMessages(sqsSvc, queueURL, done); err != nil {
+			return err
+		}
+		if handleErr != nil {
+			return handleErr
+		}
+	}
+}
+
+// progress invokes ProgressCallback, if set.
+func (s *Cluster) progress() {
+	if s.ProgressCallback != nil {
+		s.ProgressCallback()
+	}
+}
+
+// pendingMessages returns the messages of a batch that are still to be
+// handled, leaving out those of blocked FIFO message groups, which are
+// left for redelivery.
+func pendingMessages(messages []*sqs.Message, fifo bool, blockedGroups map[string]bool) []*sqs.Message {
+	pending := []*sqs.Message{}
+	for _, message := range messages {
+		if !fifo || !blockedGroups[messageGroupID(message)] {
+			pending = append(pending, message)
+		}
+	}
+	return pending
+}
+
+// handleLifecycleAction invokes cb for the lifecycle action m, received in
+// messageWrapper, and completes the action. It returns true if the message
+// should be removed from the queue. pending are the messages of the batch
+// still to be handled, whose visibility is extended along with that of
+// messageWrapper. If cb is the callback of Events, the consumer of the
+// event has already completed or released the action.
+func (s *Cluster) handleLifecycleAction(ctx context.Context, sqsSvc sqsiface.SQSAPI, autoscalingSvc autoscalingiface.AutoScalingAPI, queueURL string, messageWrapper *sqs.Message, pending []*sqs.Message, m *LifecycleMessage, cb LifecycleEventContextCallback) bool {
+	claimed, err := s.claimLifecycleAction(m)
+	if err != nil {
+		log.Printf("ERROR: cannot claim lifecycle action %s: %s", m.LifecycleActionToken, err)
+		return false
+	}
+	if !claimed {
+		return true // already handled
+	}
+
+	shouldContinue, err := s.runCallback(ctx, sqsSvc, queueURL, messageWrapper, pending, m, cb)
+	switch err {
+	case errEventCompleted:
+		return true
+	case errEventReleased:
+		s.releaseLifecycleAction(m)
+		return false
+	}
+	if err != nil {
+		s.record(m, "", err, nil)
+		s.releaseLifecycleAction(m)
+		return false
+	}
+	lifecycleActionResult := ResultContinue
+	if !shouldContinue {
+		lifecycleActionResult = ResultAbandon
+	}
+
+	err = s.completeLifecycleAction(autoscalingSvc, m, lifecycleActionResult)
+	if err != nil {
+		log.Printf("ERROR: CompleteLifecycleAction: %s", err)
+	}
+	s.record(m, lifecycleActionResult, nil, err)
+	return true
+}
+
+// lifecycleAction parses messageWrapper and returns the lifecycle action it
+// describes. Messages that are not lifecycle actions are passed to
+// NotificationCallback, if set; for these the returned message is nil and
+// remove reports whether the message should be removed from the queue.
+func (s *Cluster) lifecycleAction(messageWrapper *sqs.Message) (m *LifecycleMessage, remove bool, err error) {
+	parser := s.MessageParser
+	if parser == nil {
+		parser = DefaultMessageParser
+	}
+	m, n, err := parser.ParseMessage(*messageWrapper.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if m != nil {
+		m.MessageAttributes = messageAttributes(messageWrapper)
+	}
+	if n != nil {
+		n.MessageAttributes = messageAttributes(messageWrapper)
+	}
+	if n != nil && s.NotificationCallback != nil {
+		if err := s.NotificationCallback(n); err != nil {
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+	if m == nil {
+		return nil, true, nil
+	}
+	filter := s.TransitionFilter
+	if filter == nil {
+		filter = DefaultTransitionFilter
+	}
+	if !filter(m) {
+		return nil, true, nil
+	}
+	return m, false, nil
+}
+
+// completeLifecycleAction completes the lifecycle action described by m
+// with the specified result, which is ResultContinue or ResultAbandon,
+// invoking BeforeComplete and AfterComplete around it. If m has no action
+// token, the action is identified by its instance ID alone.
+func (s *Cluster) completeLifecycleAction(autoscalingSvc autoscalingiface.AutoScalingAPI, m *LifecycleMessage, result string) error {
+	if s.BeforeComplete != nil {
+		s.BeforeComplete(m, result)
+	}
+	input := &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  &m.AutoScalingGroupName,
+		LifecycleActionResult: aws.String(result),
+		LifecycleHookName:     &m.LifecycleHookName,
+		InstanceId:            &m.EC2InstanceID,
+	}
+	if m.LifecycleActionToken != "" {
+		input.LifecycleActionToken = &m.LifecycleActionToken
+	}
+	_, err := autoscalingSvc.CompleteLifecycleAction(input)
+	if s.AfterComplete != nil {
+		s.AfterComplete(m, result, err)
+	}
+	return err
+}
+
+// recordLifecycleActionHeartbeat extends the timeout of the lifecycle action
+// described by m.
+func recordLifecycleActionHeartbeat(autoscalingSvc autoscalingiface.AutoScalingAPI, m *LifecycleMessage) error {
+	_, err := autoscalingSvc.RecordLifecycleActionHeartbeat(&autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: &m.AutoScalingGroupName,
+		LifecycleHookName:    &m.LifecycleHookName,
+		InstanceId:           &m.EC2InstanceID,
+		LifecycleActionToken: &m.LifecycleActionToken,
+	})
+	return err
+}
+
+// deleteMessages removes messages from the queue using DeleteMessageBatch.
+// Entries that SQS fails to delete are logged; they become visible again
+// once their visibility timeout expires.
+func deleteMessages(sqsSvc sqsiface.SQSAPI, queueURL string, messages []*sqs.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(messages))
+	for i, message := range messages {
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+	}
+	resp, err := sqsSvc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: &queueURL,
+		Entries:  entries,
+	})
+	if err != nil {
+		return wrapAPIError("DeleteMessageBatch", err)
+	}
+	for _, failed := range resp.Failed {
+		log.Printf("ERROR: DeleteMessageBatch: %s: %s: %s",
+			aws.StringValue(failed.Id), aws.StringValue(failed.Code),
+			aws.StringValue(failed.Message))
 	}
+	return nil
 }
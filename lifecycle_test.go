@@ -0,0 +1,476 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type LifecycleTest struct {
+}
+
+var _ = Suite(&LifecycleTest{})
+
+func (s *LifecycleTest) TestWatchLifecycleEvents(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				{
+					ReceiptHandle: aws.String("launching"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000001","LifecycleHookName":"launch"}`),
+				},
+				{
+					ReceiptHandle: aws.String("notification"),
+					Body:          aws.String(`{"Event":"autoscaling:TEST_NOTIFICATION","AutoScalingGroupName":"example"}`),
+				},
+				{
+					ReceiptHandle: aws.String("terminating"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleHookName":"terminate"}`),
+				},
+			},
+		}},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc}
+
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		if m.LifecycleTransition == TransitionTerminating {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000001")
+	c.Assert(*autoscalingSvc.completed[0].LifecycleActionResult, Equals, ResultContinue)
+
+	// The terminating message is left on the queue for redelivery.
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 2)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "launching")
+	c.Assert(*sqsSvc.deleted[0].Entries[1].ReceiptHandle, Equals, "notification")
+}
+
+// countingMetrics counts calls to IncrCounter by name.
+type countingMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *countingMetrics) IncrCounter(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = map[string]int{}
+	}
+	m.counts[name]++
+}
+
+func (s *LifecycleTest) TestVisibilityRenewalFailure(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{{
+				ReceiptHandle: aws.String("terminating"),
+				Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleHookName":"terminate"}`),
+			}},
+		}},
+		visibilityErr: errors.New("receipt handle has expired"),
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	metrics := &countingMetrics{}
+	renewalErrors := make(chan error, 10)
+	cluster := &Cluster{
+		SQS:                       sqsSvc,
+		AutoScaling:               autoscalingSvc,
+		Metrics:                   metrics,
+		VisibilityRenewalInterval: time.Millisecond,
+		VisibilityRenewalErrorCallback: func(m *LifecycleMessage, err error) {
+			renewalErrors <- err
+		},
+		AbortOnVisibilityRenewalError: true,
+	}
+
+	err := cluster.WatchLifecycleEventsContext(context.Background(), "https://sqs.us-east-1.amazonaws.com/012345678901/example", func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			c.Error("callback context was not cancelled")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	c.Assert(<-renewalErrors, Equals, sqsSvc.visibilityErr)
+	c.Assert(metrics.counts[MetricVisibilityRenewalFailed], Equals, 1)
+
+	// The aborted action is neither completed nor removed from the queue.
+	c.Assert(autoscalingSvc.completed, HasLen, 0)
+	c.Assert(sqsSvc.deleted, HasLen, 0)
+}
+
+func (s *LifecycleTest) TestBatch(c *C) {
+	messages := []*sqs.Message{}
+	for _, instanceID := range []string{"i-00000001", "i-00000002", "i-00000003"} {
+		messages = append(messages, &sqs.Message{
+			ReceiptHandle: aws.String(instanceID),
+			Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"` + instanceID + `","LifecycleHookName":"terminate"}`),
+		})
+	}
+	sqsSvc := &fakeSQS{receives: []*sqs.ReceiveMessageOutput{{Messages: messages}}}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc, VisibilityRenewalInterval: time.Millisecond}
+
+	handled := []string{}
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		handled = append(handled, m.EC2InstanceID)
+		if m.EC2InstanceID == "i-00000001" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(handled, DeepEquals, []string{"i-00000001", "i-00000002", "i-00000003"})
+	c.Assert(autoscalingSvc.completed, HasLen, 3)
+
+	// While the first callback runs, the visibility of the messages waiting
+	// behind it in the batch is extended too.
+	c.Assert(len(sqsSvc.released) > 0, Equals, true)
+	pending := []string{}
+	for _, entry := range sqsSvc.released[0].Entries {
+		pending = append(pending, *entry.ReceiptHandle)
+	}
+	c.Assert(pending, DeepEquals, []string{"i-00000002", "i-00000003"})
+
+	// The whole batch is removed with one call.
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 3)
+}
+
+func (s *LifecycleTest) TestWatchLifecycleEventsStop(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				{
+					ReceiptHandle: aws.String("first"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001","LifecycleHookName":"terminate"}`),
+				},
+				{
+					ReceiptHandle: aws.String("second"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleHookName":"terminate"}`),
+				},
+			},
+		}},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := cluster.WatchLifecycleEventsContext(ctx, "https://sqs.us-east-1.amazonaws.com/012345678901/example", func(cbCtx context.Context, m *LifecycleMessage) (bool, error) {
+		cancel()
+		c.Assert(cbCtx.Err(), IsNil)
+		return true, nil
+	})
+	c.Assert(err, Equals, context.Canceled)
+
+	// The action in progress is completed; the rest of the batch is made
+	// visible again for another consumer.
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000001")
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "first")
+	c.Assert(sqsSvc.released, HasLen, 1)
+	c.Assert(sqsSvc.released[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.released[0].Entries[0].ReceiptHandle, Equals, "second")
+	c.Assert(*sqsSvc.released[0].Entries[0].VisibilityTimeout, Equals, int64(0))
+}
+
+func (s *LifecycleTest) TestProgressCallback(c *C) {
+	sqsSvc := &fakeSQS{receives: []*sqs.ReceiveMessageOutput{
+		{},
+		{Messages: []*sqs.Message{{
+			ReceiptHandle: aws.String("first"),
+			Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001","LifecycleHookName":"terminate"}`),
+		}}},
+	}}
+	var mu sync.Mutex
+	progress := 0
+	cluster := &Cluster{
+		SQS:                       sqsSvc,
+		AutoScaling:               &fakeAutoScaling{},
+		VisibilityRenewalInterval: time.Millisecond,
+		ProgressCallback: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			progress++
+		},
+	}
+
+	// Progress is reported for each receive, and while the callback runs.
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		c.Assert(progress > 2, Equals, true)
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+}
+
+func (s *LifecycleTest) TestTransitionFilter(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				{
+					ReceiptHandle: aws.String("into-warm-pool"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000001","Origin":"EC2","Destination":"WarmPool"}`),
+				},
+				{
+					ReceiptHandle: aws.String("from-warm-pool"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000002","Origin":"WarmPool","Destination":"AutoScalingGroup"}`),
+				},
+			},
+		}},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{
+		SQS:         sqsSvc,
+		AutoScaling: autoscalingSvc,
+		TransitionFilter: func(m *LifecycleMessage) bool {
+			return DefaultTransitionFilter(m) && m.Destination != OriginWarmPool
+		},
+	}
+
+	handled := []string{}
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		handled = append(handled, m.EC2InstanceID)
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(handled, DeepEquals, []string{"i-00000002"})
+
+	// The filtered action is removed without being completed.
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 2)
+}
+
+func (s *LifecycleTest) TestFIFOQueue(c *C) {
+	fifoMessage := func(receiptHandle, groupID, body string) *sqs.Message {
+		return &sqs.Message{
+			ReceiptHandle: aws.String(receiptHandle),
+			Body:          aws.String(body),
+			Attributes:    map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: aws.String(groupID)},
+		}
+	}
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				fifoMessage("launching-1", "i-00000001", `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000001"}`),
+				fifoMessage("launching-2", "i-00000002", `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000002"}`),
+				fifoMessage("terminating-1", "i-00000001", `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001"}`),
+				fifoMessage("terminating-2", "i-00000002", `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002"}`),
+			},
+		}},
+	}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: &fakeAutoScaling{}}
+
+	handled := []string{}
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example.fifo", func(m *LifecycleMessage) (bool, error) {
+		handled = append(handled, m.LifecycleTransition+" "+m.EC2InstanceID)
+		if m.EC2InstanceID == "i-00000001" {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	// The terminating action of i-00000001 waits for its launching action
+	// to be delivered again.
+	c.Assert(handled, DeepEquals, []string{
+		TransitionLaunching + " i-00000001",
+		TransitionLaunching + " i-00000002",
+		TransitionTerminating + " i-00000002",
+	})
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 2)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "launching-2")
+	c.Assert(*sqsSvc.deleted[0].Entries[1].ReceiptHandle, Equals, "terminating-2")
+
+	// Each receive gets a new attempt ID once the previous one succeeded.
+	c.Assert(sqsSvc.received, HasLen, 2)
+	c.Assert(*sqsSvc.received[0].AttributeNames[0], Equals, sqs.MessageSystemAttributeNameMessageGroupId)
+	c.Assert(sqsSvc.received[0].ReceiveRequestAttemptId, NotNil)
+	c.Assert(*sqsSvc.received[1].ReceiveRequestAttemptId, Not(Equals), *sqsSvc.received[0].ReceiveRequestAttemptId)
+}
+
+func (s *LifecycleTest) TestCompletionHooks(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{{
+				ReceiptHandle: aws.String("terminating"),
+				Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleHookName":"terminate"}`),
+			}},
+		}},
+	}
+	completeErr := errors.New("no active lifecycle action")
+	before := []string{}
+	var afterErr error
+	cluster := &Cluster{
+		SQS:         sqsSvc,
+		AutoScaling: &fakeAutoScaling{completeErr: completeErr},
+		BeforeComplete: func(m *LifecycleMessage, result string) {
+			before = append(before, m.EC2InstanceID+" "+result)
+		},
+		AfterComplete: func(m *LifecycleMessage, result string, err error) {
+			c.Assert(result, Equals, ResultAbandon)
+			afterErr = err
+		},
+	}
+
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		return false, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(before, DeepEquals, []string{"i-00000002 " + ResultAbandon})
+	c.Assert(afterErr, Equals, completeErr)
+}
+
+func (s *LifecycleTest) TestCallbackPanic(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				{
+					ReceiptHandle: aws.String("panics"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001"}`),
+				},
+				{
+					ReceiptHandle: aws.String("terminating"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002"}`),
+				},
+			},
+		}},
+	}
+	metrics := &countingMetrics{}
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc, Metrics: metrics}
+
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		if m.EC2InstanceID == "i-00000001" {
+			panic("something went wrong")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(metrics.counts[MetricCallbackPanic], Equals, 1)
+
+	// The message whose callback panicked is left on the queue.
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000002")
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "terminating")
+}
+
+func (s *LifecycleTest) TestCallbackPanicError(c *C) {
+	cluster := &Cluster{}
+	_, err := cluster.callCallback(context.Background(), &LifecycleMessage{}, func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		var instance *LifecycleMessage
+		return instance.LifecycleTransition == "", nil
+	})
+	panicErr, ok := err.(*CallbackPanicError)
+	c.Assert(ok, Equals, true)
+	_, isRuntimeError := panicErr.Value.(runtime.Error)
+	c.Assert(isRuntimeError, Equals, true)
+	c.Assert(panicErr.Stack, Not(HasLen), 0)
+}
+
+func (s *LifecycleTest) TestMessageAttributeFilter(c *C) {
+	message := func(id, environment string) *sqs.Message {
+		return &sqs.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String(id),
+			Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001"}`),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"environment": {DataType: aws.String("String"), StringValue: aws.String(environment)},
+			},
+		}
+	}
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{message("prod", "prod"), message("staging", "staging")},
+		}},
+	}
+	cluster := &Cluster{
+		SQS:                    sqsSvc,
+		AutoScaling:            &fakeAutoScaling{},
+		MessageAttributeFilter: map[string]string{"environment": "prod"},
+	}
+
+	attributes := []map[string]string{}
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		attributes = append(attributes, m.MessageAttributes)
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(*sqsSvc.received[0].MessageAttributeNames[0], Equals, "All")
+	c.Assert(attributes, DeepEquals, []map[string]string{{"environment": "prod"}})
+
+	// The message for the other environment is handed back to the queue.
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "prod")
+	c.Assert(sqsSvc.released, HasLen, 1)
+	c.Assert(*sqsSvc.released[0].Entries[0].ReceiptHandle, Equals, "staging")
+}
+
+func (s *LifecycleTest) TestDryRun(c *C) {
+	message := func(id, instanceID string) *sqs.Message {
+		return &sqs.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String(id),
+			Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"` + instanceID + `"}`),
+		}
+	}
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{
+			{Messages: []*sqs.Message{message("m1", "i-00000001"), message("m2", "i-00000002")}},
+			{Messages: []*sqs.Message{message("m1", "i-00000001")}},
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{}
+	recorder := &S3Recorder{Store: &StateStore{Cluster: &Cluster{S3: newFakeS3()}, Bucket: "bucket", Prefix: "example/"}}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: autoscalingSvc, DryRun: true, Recorder: recorder}
+
+	handled := []string{}
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		handled = append(handled, m.EC2InstanceID)
+		if m.EC2InstanceID == "i-00000002" {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	// The action delivered again is not passed to the callback again.
+	c.Assert(handled, DeepEquals, []string{"i-00000001", "i-00000002"})
+	c.Assert(autoscalingSvc.completed, HasLen, 0)
+	c.Assert(sqsSvc.deleted, HasLen, 0)
+
+	// Handled messages are hidden rather than removed; the message whose
+	// callback failed is left to be delivered again.
+	c.Assert(sqsSvc.released, HasLen, 2)
+	c.Assert(sqsSvc.released[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.released[0].Entries[0].ReceiptHandle, Equals, "m1")
+	c.Assert(*sqsSvc.released[0].Entries[0].VisibilityTimeout, Equals, int64(3600))
+
+	records, err := recorder.Records()
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 2)
+	c.Assert(records[0].DryRun, Equals, true)
+	c.Assert(records[0].Result, Equals, ResultContinue)
+}
@@ -0,0 +1,145 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// maxForeignMessages is the number of messages for other consumers that a
+// lifecycle event watcher remembers.
+const maxForeignMessages = 1000
+
+// WatchLocalTermination watches the lifecycle event queue of the current
+// autoscaling group and invokes cb only when the current instance is
+// terminating. This suits the common deployment where the watcher runs on
+// every member and each member drains itself.
+//
+// Events for other instances, and launch events, are handed back to the
+// queue as described for WatchOwnedLifecycleEvents. Because they are not
+// removed, use a queue that only receives terminate events, or another
+// consumer for launch events.
+//
+// If InstanceID is empty it is discovered from the EC2 metadata service.
+func (s *Cluster) WatchLocalTermination(ctx context.Context, cb LifecycleEventContextCallback) error {
+	if s.InstanceID == "" {
+		instanceID, err := DiscoverInstanceID()
+		if err != nil {
+			return err
+		}
+		s.InstanceID = instanceID
+	}
+	return s.WatchOwnedLifecycleEvents(ctx, func(m *LifecycleMessage) bool {
+		return m.EC2InstanceID == s.InstanceID && m.LifecycleTransition == TransitionTerminating
+	}, cb)
+}
+
+// WatchOwnedLifecycleEvents watches the lifecycle event queue of the current
+// autoscaling group, which is shared by several consumers, and invokes cb
+// for the lifecycle actions for which owns returns true.
+//
+// The first time a consumer receives an action it does not own, it makes
+// the message visible again immediately, so that the consumer that owns it
+// receives it without waiting for the visibility timeout. If the same
+// message is received again, its owner is presumably not consuming, so it
+// is left to time out rather than churning between the consumers that do
+// not own it.
+//
+// WatchOwnedLifecycleEvents returns when ctx is cancelled, as described for
+// WatchLifecycleEventsContext.
+func (s *Cluster) WatchOwnedLifecycleEvents(ctx context.Context, owns func(m *LifecycleMessage) bool, cb LifecycleEventContextCallback) error {
+	queueURL, err := s.LifecycleEventQueueURL()
+	if err != nil {
+		return err
+	}
+	return s.watchLifecycleEvents(ctx, s.newLifecycleQueue(queueURL, s.LifecycleEventQueueURL), owns, cb)
+}
+
+// releaseForeignMessages makes the messages that have not been seen
+// before visible again, and records them in seen.
+func (s *Cluster) releaseForeignMessages(sqsSvc sqsiface.SQSAPI, queueURL string, seen *messageSet, messages []*sqs.Message) {
+	unseen := []*sqs.Message{}
+	for _, message := range messages {
+		if seen.add(aws.StringValue(message.MessageId)) {
+			unseen = append(unseen, message)
+		}
+	}
+	changeMessageVisibility(sqsSvc, queueURL, unseen, aws.Int64(0))
+}
+
+// changeMessageVisibility sets the visibility timeout of messages, in
+// seconds. Errors are logged.
+func changeMessageVisibility(sqsSvc sqsiface.SQSAPI, queueURL string, messages []*sqs.Message, visibilityTimeout *int64) {
+	entries := []*sqs.ChangeMessageVisibilityBatchRequestEntry{}
+	for i, message := range messages {
+		entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: visibilityTimeout,
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	resp, err := sqsSvc.ChangeMessageVisibilityBatch(&sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: &queueURL,
+		Entries:  entries,
+	})
+	if err != nil {
+		log.Printf("ERROR: ChangeMessageVisibilityBatch: %s", err)
+		return
+	}
+	for _, failed := range resp.Failed {
+		log.Printf("ERROR: ChangeMessageVisibilityBatch: %s: %s", aws.StringValue(failed.Id), aws.StringValue(failed.Message))
+	}
+}
+
+// messageSet is a set of message IDs that holds at most max IDs. When it is
+// full, the oldest ID is forgotten.
+type messageSet struct {
+	max   int
+	ids   map[string]bool
+	order []string
+}
+
+func newMessageSet(max int) *messageSet {
+	return &messageSet{max: max, ids: map[string]bool{}}
+}
+
+// add adds id to the set and returns true if it was not already present.
+func (a *messageSet) add(id string) bool {
+	if a.ids[id] {
+		return false
+	}
+	if len(a.order) >= a.max {
+		delete(a.ids, a.order[0])
+		a.order = a.order[1:]
+	}
+	a.ids[id] = true
+	a.order = append(a.order, id)
+	return true
+}
+
+// has returns true if id is in the set.
+func (a *messageSet) has(id string) bool {
+	return a.ids[id]
+}
+
+// remove removes id from the set.
+func (a *messageSet) remove(id string) {
+	if !a.ids[id] {
+		return
+	}
+	delete(a.ids, id)
+	for i, other := range a.order {
+		if other == id {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+}
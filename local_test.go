@@ -0,0 +1,81 @@
+package ec2cluster
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type LocalTest struct {
+}
+
+var _ = Suite(&LocalTest{})
+
+func (s *LocalTest) TestWatchLocalTermination(c *C) {
+	other := &sqs.Message{
+		MessageId:     aws.String("2"),
+		ReceiptHandle: aws.String("other"),
+		Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002","LifecycleHookName":"terminate"}`),
+	}
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{
+			{
+				Messages: []*sqs.Message{
+					other,
+					{
+						MessageId:     aws.String("1"),
+						ReceiptHandle: aws.String("local"),
+						Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000001","LifecycleHookName":"terminate"}`),
+					},
+				},
+			},
+			{Messages: []*sqs.Message{other}},
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{
+		hooks: []*autoscaling.LifecycleHook{{
+			LifecycleHookName:     aws.String("terminate"),
+			NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:012345678901:example"),
+		}},
+	}
+	cluster := &Cluster{
+		InstanceID:       "i-00000001",
+		SQS:              sqsSvc,
+		AutoScaling:      autoscalingSvc,
+		autoScalingGroup: &autoscaling.Group{AutoScalingGroupName: aws.String("example")},
+	}
+
+	handled := []string{}
+	err := cluster.WatchLocalTermination(context.Background(), func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		handled = append(handled, m.EC2InstanceID)
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+	c.Assert(handled, DeepEquals, []string{"i-00000001"})
+
+	c.Assert(autoscalingSvc.completed, HasLen, 1)
+	c.Assert(*autoscalingSvc.completed[0].InstanceId, Equals, "i-00000001")
+	c.Assert(sqsSvc.deleted, HasLen, 1)
+	c.Assert(sqsSvc.deleted[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.deleted[0].Entries[0].ReceiptHandle, Equals, "local")
+
+	// The other instance's message is released the first time it is
+	// received, and left to time out the second time.
+	c.Assert(sqsSvc.released, HasLen, 1)
+	c.Assert(sqsSvc.released[0].Entries, HasLen, 1)
+	c.Assert(*sqsSvc.released[0].Entries[0].ReceiptHandle, Equals, "other")
+	c.Assert(*sqsSvc.released[0].Entries[0].VisibilityTimeout, Equals, int64(0))
+}
+
+func (s *LocalTest) TestMessageSet(c *C) {
+	a := newMessageSet(2)
+	c.Assert(a.add("a"), Equals, true)
+	c.Assert(a.add("a"), Equals, false)
+	c.Assert(a.add("b"), Equals, true)
+	c.Assert(a.add("c"), Equals, true)
+	c.Assert(a.add("a"), Equals, true)
+	c.Assert(a.add("c"), Equals, false)
+}
@@ -0,0 +1,167 @@
+package ec2cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrMembershipStale is returned by MembershipSubscriber when the published
+// membership is older than its MaxAge, for example because no member has
+// been publishing.
+var ErrMembershipStale = errors.New("published membership is stale")
+
+// defaultMembershipKey is the key, within a StateStore, of the published
+// membership.
+const defaultMembershipKey = "membership"
+
+// MembershipPublisher publishes a Snapshot of the members of the cluster to
+// a StateStore, so that in large clusters only one member polls the EC2 and
+// autoscaling APIs and the others read the published view with a
+// MembershipSubscriber. Every member may run a publisher; only the leader,
+// as returned by Leader, publishes.
+type MembershipPublisher struct {
+	Cluster *Cluster
+	Store   *StateStore
+
+	// Key is the key of the published membership in Store. If empty,
+	// "membership" is used.
+	Key string
+}
+
+func (p *MembershipPublisher) key() string {
+	if p.Key != "" {
+		return p.Key
+	}
+	return defaultMembershipKey
+}
+
+// Publish publishes the current members of the cluster if the current
+// instance is the leader, and returns true if it did.
+func (p *MembershipPublisher) Publish() (bool, error) {
+	isLeader, err := p.Cluster.IsLeader()
+	if err != nil {
+		return false, err
+	}
+	if !isLeader {
+		return false, nil
+	}
+
+	// Continue the generation of the previous publisher, which may have
+	// been another instance that was the leader before.
+	if p.Cluster.snapshot == nil {
+		previous, err := readMembership(p.Store, p.key())
+		if err != nil && err != ErrStateNotFound {
+			return false, err
+		}
+		if previous != nil {
+			p.Cluster.RestoreSnapshot(previous)
+		}
+	}
+
+	snapshot, err := p.Cluster.Snapshot()
+	if err != nil {
+		return false, err
+	}
+	buf, err := json.Marshal(snapshot)
+	if err != nil {
+		return false, err
+	}
+	if err := p.Store.write(p.key(), buf); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run calls Publish every `interval` until ctx is cancelled or an error
+// occurs.
+func (p *MembershipPublisher) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		if _, err := p.Publish(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.Cluster.jitter(interval)):
+		}
+	}
+}
+
+// MembershipSubscriber reads the membership published by a
+// MembershipPublisher.
+type MembershipSubscriber struct {
+	Store *StateStore
+
+	// Key is the key of the published membership in Store. If empty,
+	// "membership" is used.
+	Key string
+
+	// MaxAge, if not zero, is how old the published membership may be
+	// before ErrMembershipStale is returned instead. It should be several
+	// times the publishing interval.
+	MaxAge time.Duration
+}
+
+// Snapshot returns the published membership. If nothing has been published
+// yet, ErrStateNotFound is returned.
+func (sub *MembershipSubscriber) Snapshot() (*Snapshot, error) {
+	key := sub.Key
+	if key == "" {
+		key = defaultMembershipKey
+	}
+	snapshot, err := readMembership(sub.Store, key)
+	if err != nil {
+		return nil, err
+	}
+	if sub.MaxAge != 0 && time.Since(snapshot.Time) > sub.MaxAge {
+		return nil, ErrMembershipStale
+	}
+	return snapshot, nil
+}
+
+// MembershipCallback is a function that is invoked by
+// MembershipSubscriber.Watch with the published membership. If the
+// function returns an error, watching stops and the error is returned from
+// Watch.
+type MembershipCallback func(snapshot *Snapshot) error
+
+// Watch reads the published membership every `interval` and invokes cb
+// whenever the members or their states change. The callback is always
+// invoked for the first read. Watch runs until ctx is cancelled or an error
+// occurs.
+func (sub *MembershipSubscriber) Watch(ctx context.Context, interval time.Duration, cb MembershipCallback) error {
+	var last *Snapshot
+	for {
+		snapshot, err := sub.Snapshot()
+		if err != nil {
+			return err
+		}
+		if last == nil || !sameMembers(last, snapshot) {
+			if err := cb(snapshot); err != nil {
+				return err
+			}
+			last = snapshot
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sub.Store.Cluster.jitter(interval)):
+		}
+	}
+}
+
+func readMembership(store *StateStore, key string) (*Snapshot, error) {
+	buf, _, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(buf, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
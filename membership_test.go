@@ -0,0 +1,67 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type MembershipTest struct {
+}
+
+var _ = Suite(&MembershipTest{})
+
+func (s *MembershipTest) TestPublishAndSubscribe(c *C) {
+	now := time.Now()
+	ec2Svc := &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now.Add(-time.Hour)),
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now),
+		},
+	}
+	s3Svc := newFakeS3()
+	newStore := func(instanceID string) *StateStore {
+		return &StateStore{
+			Cluster: &Cluster{InstanceID: instanceID, TagName: "app", TagValue: "example", EC2: ec2Svc, S3: s3Svc},
+			Bucket:  "bucket",
+		}
+	}
+	subscriber := &MembershipSubscriber{Store: newStore("i-00000002"), MaxAge: time.Minute}
+
+	_, err := subscriber.Snapshot()
+	c.Assert(err, Equals, ErrStateNotFound)
+
+	// Only the leader publishes.
+	follower := newStore("i-00000002")
+	published, err := (&MembershipPublisher{Cluster: follower.Cluster, Store: follower}).Publish()
+	c.Assert(err, IsNil)
+	c.Assert(published, Equals, false)
+
+	leader := newStore("i-00000001")
+	published, err = (&MembershipPublisher{Cluster: leader.Cluster, Store: leader}).Publish()
+	c.Assert(err, IsNil)
+	c.Assert(published, Equals, true)
+
+	snapshot, err := subscriber.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Generation, Equals, int64(1))
+	c.Assert(snapshot.Leader, Equals, "i-00000001")
+	c.Assert(snapshot.Members, HasLen, 2)
+
+	// A new leader continues the generation of the previous one.
+	ec2Svc.instances = ec2Svc.instances[1:]
+	leader = newStore("i-00000002")
+	published, err = (&MembershipPublisher{Cluster: leader.Cluster, Store: leader}).Publish()
+	c.Assert(err, IsNil)
+	c.Assert(published, Equals, true)
+
+	snapshot, err = subscriber.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Generation, Equals, int64(2))
+	c.Assert(snapshot.Leader, Equals, "i-00000002")
+
+	subscriber.MaxAge = time.Nanosecond
+	_, err = subscriber.Snapshot()
+	c.Assert(err, Equals, ErrMembershipStale)
+}
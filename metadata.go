@@ -1,6 +1,7 @@
 package ec2cluster
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -25,6 +26,33 @@ func DiscoverAvailabilityZone() (string, error) {
 	return readMetadata("placement/availability-zone")
 }
 
+// ScheduledEvent is an EC2 scheduled event affecting the current instance,
+// as reported by the EC2 metadata service.
+type ScheduledEvent struct {
+	Code        string
+	Description string
+	EventID     string `json:"EventId"`
+	NotBefore   string
+	NotAfter    string
+	State       string
+}
+
+// DiscoverScheduledEvents returns the scheduled events, such as system
+// reboots or instance retirement, that affect the current instance. Use
+// WatchScheduledEvents to be notified of the events affecting any member
+// of a cluster.
+func DiscoverScheduledEvents() ([]ScheduledEvent, error) {
+	body, err := readMetadata("events/maintenance/scheduled")
+	if err != nil {
+		return nil, err
+	}
+	events := []ScheduledEvent{}
+	if err := json.Unmarshal([]byte(body), &events); err != nil {
+		return nil, fmt.Errorf("cannot parse scheduled events: %s", err)
+	}
+	return events, nil
+}
+
 func readMetadata(suffix string) (string, error) {
 	// a nice short timeout so we don't hang too much on non-AWS boxes
 	client := *http.DefaultClient
@@ -35,7 +63,7 @@ func readMetadata(suffix string) (string, error) {
 		return "", err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("fetching metadata: %s", resp.Status)
+		return "", fmt.Errorf("%w: %s", ErrMetadataUnavailable, resp.Status)
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
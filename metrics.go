@@ -0,0 +1,21 @@
+package ec2cluster
+
+// Metrics receives counters from the package, for example to export them to
+// Prometheus or CloudWatch. IncrCounter may be called from several
+// goroutines at once.
+type Metrics interface {
+	IncrCounter(name string)
+}
+
+// Names of the counters passed to Metrics.
+const (
+	MetricVisibilityRenewalFailed = "visibility_renewal_failed"
+	MetricCallbackPanic           = "callback_panic"
+)
+
+// incrCounter increments the named counter if Metrics is set.
+func (s *Cluster) incrCounter(name string) {
+	if s.Metrics != nil {
+		s.Metrics.IncrCounter(name)
+	}
+}
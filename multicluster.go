@@ -0,0 +1,87 @@
+package ec2cluster
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// MultiCluster is a cluster that spans several regions, made up of one
+// Cluster per region, for globally distributed services. Each Cluster may
+// have its own session, or they may share one session and set Region.
+type MultiCluster struct {
+	Clusters []*Cluster
+}
+
+// NewMultiCluster returns a MultiCluster with a Cluster in each of regions
+// that uses awsSession and describes the cluster with tagName and tagValue.
+// The current instance, if any, is identified by instanceID.
+func NewMultiCluster(awsSession *session.Session, instanceID, tagName, tagValue string, regions []string) *MultiCluster {
+	m := &MultiCluster{}
+	for _, region := range regions {
+		m.Clusters = append(m.Clusters, &Cluster{
+			AwsSession: awsSession,
+			InstanceID: instanceID,
+			TagName:    tagName,
+			TagValue:   tagValue,
+			Region:     region,
+		})
+	}
+	return m
+}
+
+// Members returns the members of every cluster in order from oldest to
+// youngest. The clusters are queried concurrently.
+func (m *MultiCluster) Members() ([]*ec2.Instance, error) {
+	membersByRegion, err := m.MembersByRegion()
+	if err != nil {
+		return nil, err
+	}
+	members := []*ec2.Instance{}
+	for _, regionMembers := range membersByRegion {
+		members = append(members, regionMembers...)
+	}
+	sortByLaunchTime(members)
+	return members, nil
+}
+
+// MembersByRegion returns the members of each cluster, oldest first, by
+// region.
+func (m *MultiCluster) MembersByRegion() (map[string][]*ec2.Instance, error) {
+	var wg sync.WaitGroup
+	results := make([][]*ec2.Instance, len(m.Clusters))
+	errs := make([]error, len(m.Clusters))
+	for i, cluster := range m.Clusters {
+		wg.Add(1)
+		go func(i int, cluster *Cluster) {
+			defer wg.Done()
+			results[i], errs[i] = cluster.Members()
+		}(i, cluster)
+	}
+	wg.Wait()
+
+	rv := map[string][]*ec2.Instance{}
+	for i, cluster := range m.Clusters {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		region := cluster.region()
+		rv[region] = append(rv[region], results[i]...)
+	}
+	return rv, nil
+}
+
+// Leader returns the oldest running member across all regions. As with
+// Cluster.Leader, every member that sees the same clusters agrees on it.
+func (m *MultiCluster) Leader() (*ec2.Instance, error) {
+	members, err := m.Members()
+	if err != nil {
+		return nil, err
+	}
+	running := runningInstances(members)
+	if len(running) == 0 {
+		return nil, ErrNoRunningMembers
+	}
+	return running[0], nil
+}
@@ -0,0 +1,57 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type MultiClusterTest struct {
+}
+
+var _ = Suite(&MultiClusterTest{})
+
+func (s *MultiClusterTest) TestMembers(c *C) {
+	now := time.Now()
+	east := &Cluster{TagName: "app", TagValue: "example", Region: "us-east-1", EC2: &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000001", ec2.InstanceStateNameStopped, now.Add(-2*time.Hour)),
+			fakeInstance("i-00000003", ec2.InstanceStateNameRunning, now),
+		},
+	}}
+	west := &Cluster{TagName: "app", TagValue: "example", Region: "us-west-2", EC2: &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now.Add(-time.Hour)),
+		},
+	}}
+	multi := &MultiCluster{Clusters: []*Cluster{east, west}}
+
+	members, err := multi.Members()
+	c.Assert(err, IsNil)
+	instanceIDs := []string{}
+	for _, member := range members {
+		instanceIDs = append(instanceIDs, *member.InstanceId)
+	}
+	c.Assert(instanceIDs, DeepEquals, []string{"i-00000001", "i-00000002", "i-00000003"})
+
+	membersByRegion, err := multi.MembersByRegion()
+	c.Assert(err, IsNil)
+	c.Assert(membersByRegion["us-east-1"], HasLen, 2)
+	c.Assert(membersByRegion["us-west-2"], HasLen, 1)
+
+	leader, err := multi.Leader()
+	c.Assert(err, IsNil)
+	c.Assert(*leader.InstanceId, Equals, "i-00000002")
+}
+
+func (s *MultiClusterTest) TestRegion(c *C) {
+	awsSession := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+	multi := NewMultiCluster(awsSession, "i-00000001", "app", "example", []string{"eu-west-1", "ap-southeast-2"})
+	c.Assert(multi.Clusters, HasLen, 2)
+	c.Assert(multi.Clusters[1].region(), Equals, "ap-southeast-2")
+	c.Assert(*multi.Clusters[0].ec2Client().(*ec2.EC2).Config.Region, Equals, "eu-west-1")
+	c.Assert((&Cluster{AwsSession: awsSession}).region(), Equals, "us-east-1")
+}
@@ -0,0 +1,336 @@
+package ec2cluster
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrNoAddressAvailable is returned by AddressClaimer.Claim when every
+// Elastic IP address matching the selector is already associated.
+var ErrNoAddressAvailable = errors.New("no matching elastic IP address is available")
+
+// ErrNoInterfaceAvailable is returned by InterfaceClaimer.Claim when there
+// is no available network interface matching the selector in the
+// instance's availability zone.
+var ErrNoInterfaceAvailable = errors.New("no matching network interface is available")
+
+// AddressClaimer associates Elastic IP addresses from a tagged pool with
+// cluster instances as they launch and disassociates them as they terminate,
+// giving cluster nodes stable public addresses.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents to claim addresses
+// automatically, or call Claim and Release directly.
+type AddressClaimer struct {
+	Cluster *Cluster
+
+	// Selector is the set of tags that an address must have to be claimed.
+	Selector map[string]string
+}
+
+// Claim associates an unassociated address matching Selector with the
+// instance. If a matching address is already associated with the instance
+// then that address is returned.
+func (a *AddressClaimer) Claim(instanceID string) (*ec2.Address, error) {
+	ec2svc := a.Cluster.ec2Client()
+	resp, err := ec2svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: append(tagFilters(a.Selector), &ec2.Filter{
+			Name:   aws.String("domain"),
+			Values: []*string{aws.String(ec2.DomainTypeVpc)},
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	addresses := resp.Addresses
+	sort.Sort(byAllocationID(addresses))
+
+	for _, address := range addresses {
+		if aws.StringValue(address.InstanceId) == instanceID {
+			return address, nil
+		}
+	}
+
+	// AllowReassociation is false so that if another instance claims the
+	// same address first our associate fails and we try the next one.
+	for _, address := range addresses {
+		if address.AssociationId != nil {
+			continue
+		}
+		_, err := ec2svc.AssociateAddress(&ec2.AssociateAddressInput{
+			AllocationId:       address.AllocationId,
+			InstanceId:         aws.String(instanceID),
+			AllowReassociation: aws.Bool(false),
+		})
+		if err != nil {
+			log.Printf("AssociateAddress %s: %s", *address.AllocationId, err)
+			continue
+		}
+		return address, nil
+	}
+	return nil, ErrNoAddressAvailable
+}
+
+// Release disassociates each address matching Selector from the instance.
+func (a *AddressClaimer) Release(instanceID string) error {
+	ec2svc := a.Cluster.ec2Client()
+	resp, err := ec2svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: append(tagFilters(a.Selector), &ec2.Filter{
+			Name:   aws.String("instance-id"),
+			Values: []*string{aws.String(instanceID)},
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	for _, address := range resp.Addresses {
+		_, err := ec2svc.DisassociateAddress(&ec2.DisassociateAddressInput{
+			AssociationId: address.AssociationId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that claims an address for
+// launching instances and releases the addresses of terminating instances.
+// If no address can be claimed for a launching instance, the lifecycle
+// action is abandoned.
+func (a *AddressClaimer) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	switch m.LifecycleTransition {
+	case TransitionLaunching:
+		_, err := a.Claim(m.EC2InstanceID)
+		if err == ErrNoAddressAvailable {
+			log.Printf("ERROR: cannot claim address for %s: %s", m.EC2InstanceID, err)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	case TransitionTerminating:
+		if err := a.Release(m.EC2InstanceID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// InterfaceClaimer attaches pre-created elastic network interfaces from a
+// tagged pool to cluster instances as they launch and detaches them as they
+// terminate, giving cluster nodes stable private addresses.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents to claim interfaces
+// automatically, or call Claim and Release directly.
+type InterfaceClaimer struct {
+	Cluster *Cluster
+
+	// Selector is the set of tags that an interface must have to be claimed.
+	Selector map[string]string
+
+	// DeviceIndex is the index of the device on the instance that the
+	// interface is attached as. The primary interface is index 0, so this
+	// is usually 1.
+	DeviceIndex int64
+
+	// Timeout is how long to wait for an interface to attach or detach. If
+	// zero, five minutes is used.
+	Timeout time.Duration
+}
+
+const interfacePollInterval = 2 * time.Second
+
+func (n *InterfaceClaimer) timeout() time.Duration {
+	if n.Timeout == 0 {
+		return 5 * time.Minute
+	}
+	return n.Timeout
+}
+
+// Claim attaches an available interface matching Selector in the same
+// availability zone as the instance and waits for the attachment to
+// complete. If a matching interface is already attached to the instance
+// then that interface is returned.
+func (n *InterfaceClaimer) Claim(instanceID string) (*ec2.NetworkInterface, error) {
+	attached, err := n.attachedInterfaces(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(attached) > 0 {
+		return attached[0], nil
+	}
+
+	instance, err := n.Cluster.describeInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	ec2svc := n.Cluster.ec2Client()
+	candidates, err := n.describeInterfaces(append(tagFilters(n.Selector),
+		&ec2.Filter{
+			Name:   aws.String("availability-zone"),
+			Values: []*string{instance.Placement.AvailabilityZone},
+		},
+		&ec2.Filter{
+			Name:   aws.String("status"),
+			Values: []*string{aws.String(ec2.NetworkInterfaceStatusAvailable)},
+		}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byNetworkInterfaceID(candidates))
+
+	for _, networkInterface := range candidates {
+		_, err := ec2svc.AttachNetworkInterface(&ec2.AttachNetworkInterfaceInput{
+			DeviceIndex:        aws.Int64(n.DeviceIndex),
+			InstanceId:         aws.String(instanceID),
+			NetworkInterfaceId: networkInterface.NetworkInterfaceId,
+		})
+		if err != nil {
+			log.Printf("AttachNetworkInterface %s: %s", *networkInterface.NetworkInterfaceId, err)
+			continue
+		}
+
+		networkInterfaceID := *networkInterface.NetworkInterfaceId
+		err = waitUntil(n.timeout(), interfacePollInterval, func() (bool, error) {
+			current, err := n.describeInterface(networkInterfaceID)
+			if err != nil {
+				return false, err
+			}
+			return current.Attachment != nil &&
+				aws.StringValue(current.Attachment.InstanceId) == instanceID &&
+				aws.StringValue(current.Attachment.Status) == ec2.AttachmentStatusAttached, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return networkInterface, nil
+	}
+	return nil, ErrNoInterfaceAvailable
+}
+
+// Release detaches each interface matching Selector from the instance and
+// waits for the interfaces to become available.
+func (n *InterfaceClaimer) Release(instanceID string) error {
+	attached, err := n.attachedInterfaces(instanceID)
+	if err != nil {
+		return err
+	}
+
+	ec2svc := n.Cluster.ec2Client()
+	for _, networkInterface := range attached {
+		_, err := ec2svc.DetachNetworkInterface(&ec2.DetachNetworkInterfaceInput{
+			AttachmentId: networkInterface.Attachment.AttachmentId,
+		})
+		if err != nil {
+			return err
+		}
+
+		networkInterfaceID := *networkInterface.NetworkInterfaceId
+		err = waitUntil(n.timeout(), interfacePollInterval, func() (bool, error) {
+			current, err := n.describeInterface(networkInterfaceID)
+			if err != nil {
+				return false, err
+			}
+			return aws.StringValue(current.Status) == ec2.NetworkInterfaceStatusAvailable, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that claims an interface
+// for launching instances and releases the interfaces of terminating
+// instances. If no interface can be claimed for a launching instance, the
+// lifecycle action is abandoned.
+func (n *InterfaceClaimer) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	switch m.LifecycleTransition {
+	case TransitionLaunching:
+		_, err := n.Claim(m.EC2InstanceID)
+		if err == ErrNoInterfaceAvailable {
+			log.Printf("ERROR: cannot claim network interface for %s: %s", m.EC2InstanceID, err)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	case TransitionTerminating:
+		if err := n.Release(m.EC2InstanceID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// attachedInterfaces returns the interfaces matching Selector that are
+// attached to the instance.
+func (n *InterfaceClaimer) attachedInterfaces(instanceID string) ([]*ec2.NetworkInterface, error) {
+	networkInterfaces, err := n.describeInterfaces(append(tagFilters(n.Selector), &ec2.Filter{
+		Name:   aws.String("attachment.instance-id"),
+		Values: []*string{aws.String(instanceID)},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	rv := []*ec2.NetworkInterface{}
+	for _, networkInterface := range networkInterfaces {
+		if networkInterface.Attachment != nil {
+			rv = append(rv, networkInterface)
+		}
+	}
+	sort.Sort(byNetworkInterfaceID(rv))
+	return rv, nil
+}
+
+// describeInterfaces returns every interface matching filters.
+func (n *InterfaceClaimer) describeInterfaces(filters []*ec2.Filter) ([]*ec2.NetworkInterface, error) {
+	ec2svc := n.Cluster.ec2Client()
+	rv := []*ec2.NetworkInterface{}
+	err := ec2svc.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{
+		Filters: filters,
+	}, func(resp *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		rv = append(rv, resp.NetworkInterfaces...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func (n *InterfaceClaimer) describeInterface(networkInterfaceID string) (*ec2.NetworkInterface, error) {
+	ec2svc := n.Cluster.ec2Client()
+	resp, err := ec2svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []*string{aws.String(networkInterfaceID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.NetworkInterfaces) != 1 {
+		return nil, &NotFoundError{Kind: "network interface", ID: networkInterfaceID}
+	}
+	return resp.NetworkInterfaces[0], nil
+}
+
+type byAllocationID []*ec2.Address
+
+func (a byAllocationID) Len() int      { return len(a) }
+func (a byAllocationID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAllocationID) Less(i, j int) bool {
+	return aws.StringValue(a[i].AllocationId) < aws.StringValue(a[j].AllocationId)
+}
+
+type byNetworkInterfaceID []*ec2.NetworkInterface
+
+func (a byNetworkInterfaceID) Len() int      { return len(a) }
+func (a byNetworkInterfaceID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byNetworkInterfaceID) Less(i, j int) bool {
+	return *a[i].NetworkInterfaceId < *a[j].NetworkInterfaceId
+}
@@ -0,0 +1,193 @@
+package ec2cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type NetworkTest struct {
+}
+
+var _ = Suite(&NetworkTest{})
+
+var poolTags = []*ec2.Tag{{Key: aws.String("pool"), Value: aws.String("public")}}
+
+func poolAddress(allocationID, associatedWith string) *ec2.Address {
+	address := &ec2.Address{
+		AllocationId: aws.String(allocationID),
+		Domain:       aws.String(ec2.DomainTypeVpc),
+		Tags:         poolTags,
+	}
+	if associatedWith != "" {
+		address.AssociationId = aws.String("eipassoc-" + allocationID)
+		address.InstanceId = aws.String(associatedWith)
+	}
+	return address
+}
+
+func addressClaimer(addresses ...*ec2.Address) (*AddressClaimer, *fakeEC2) {
+	ec2Svc := &fakeEC2{addresses: addresses}
+	a := &AddressClaimer{
+		Cluster:  &Cluster{EC2: ec2Svc},
+		Selector: map[string]string{"pool": "public"},
+	}
+	return a, ec2Svc
+}
+
+func (s *NetworkTest) TestClaimAddress(c *C) {
+	associated := poolAddress("eipalloc-00000001", "i-00000003")
+	raced := poolAddress("eipalloc-00000002", "")
+	available := poolAddress("eipalloc-00000003", "")
+	untagged := poolAddress("eipalloc-00000000", "")
+	untagged.Tags = nil
+	a, ec2Svc := addressClaimer(available, raced, associated, untagged)
+
+	// Another instance associates the first candidate before us, so the
+	// next one is claimed.
+	ec2Svc.attachErrs = map[string]error{"eipalloc-00000002": errors.New("Resource.AlreadyAssociated")}
+
+	address, err := a.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*address.AllocationId, Equals, "eipalloc-00000003")
+	c.Assert(*available.InstanceId, Equals, "i-00000002")
+	c.Assert(*associated.InstanceId, Equals, "i-00000003")
+	c.Assert(raced.InstanceId, IsNil)
+	c.Assert(untagged.InstanceId, IsNil)
+}
+
+func (s *NetworkTest) TestClaimAddressAlreadyAssociated(c *C) {
+	associated := poolAddress("eipalloc-00000002", "i-00000002")
+	available := poolAddress("eipalloc-00000001", "")
+	a, _ := addressClaimer(available, associated)
+
+	address, err := a.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*address.AllocationId, Equals, "eipalloc-00000002")
+	c.Assert(available.InstanceId, IsNil)
+}
+
+func (s *NetworkTest) TestClaimAddressNoneAvailable(c *C) {
+	a, _ := addressClaimer(poolAddress("eipalloc-00000001", "i-00000003"))
+
+	_, err := a.Claim("i-00000002")
+	c.Assert(err, Equals, ErrNoAddressAvailable)
+
+	shouldContinue, err := a.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionLaunching,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, false)
+}
+
+func (s *NetworkTest) TestReleaseAddress(c *C) {
+	associated := poolAddress("eipalloc-00000001", "i-00000002")
+	other := poolAddress("eipalloc-00000002", "i-00000003")
+	a, _ := addressClaimer(associated, other)
+
+	shouldContinue, err := a.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(associated.AssociationId, IsNil)
+	c.Assert(*other.InstanceId, Equals, "i-00000003")
+}
+
+func poolInterface(networkInterfaceID, availabilityZone, attachedTo string) *ec2.NetworkInterface {
+	networkInterface := &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String(networkInterfaceID),
+		AvailabilityZone:   aws.String(availabilityZone),
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+		TagSet:             poolTags,
+	}
+	if attachedTo != "" {
+		networkInterface.Status = aws.String(ec2.NetworkInterfaceStatusInUse)
+		networkInterface.Attachment = &ec2.NetworkInterfaceAttachment{
+			AttachmentId: aws.String("attach-" + networkInterfaceID),
+			InstanceId:   aws.String(attachedTo),
+			Status:       aws.String(ec2.AttachmentStatusAttached),
+		}
+	}
+	return networkInterface
+}
+
+// interfaceClaimer returns an InterfaceClaimer for the interfaces of the
+// public pool. Claims are made for i-00000002, which is in us-east-1a.
+func interfaceClaimer(networkInterfaces ...*ec2.NetworkInterface) (*InterfaceClaimer, *fakeEC2) {
+	ec2Svc := &fakeEC2{networkInterfaces: networkInterfaces}
+	ec2Svc.setInstances(fakeInstance("i-00000002", ec2.InstanceStateNameRunning, time.Now()))
+	n := &InterfaceClaimer{
+		Cluster:     &Cluster{EC2: ec2Svc},
+		Selector:    map[string]string{"pool": "public"},
+		DeviceIndex: 1,
+	}
+	return n, ec2Svc
+}
+
+func (s *NetworkTest) TestClaimInterface(c *C) {
+	attached := poolInterface("eni-00000001", "us-east-1a", "i-00000003")
+	otherZone := poolInterface("eni-00000002", "us-east-1b", "")
+	raced := poolInterface("eni-00000003", "us-east-1a", "")
+	available := poolInterface("eni-00000004", "us-east-1a", "")
+	n, ec2Svc := interfaceClaimer(available, raced, otherZone, attached)
+
+	// Another instance attaches the first candidate before us, so the next
+	// one is claimed.
+	ec2Svc.attachErrs = map[string]error{"eni-00000003": errors.New("InvalidNetworkInterface.InUse")}
+
+	networkInterface, err := n.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*networkInterface.NetworkInterfaceId, Equals, "eni-00000004")
+	c.Assert(*available.Attachment.InstanceId, Equals, "i-00000002")
+	c.Assert(*available.Attachment.DeviceIndex, Equals, int64(1))
+	c.Assert(*otherZone.Status, Equals, ec2.NetworkInterfaceStatusAvailable)
+	c.Assert(*raced.Status, Equals, ec2.NetworkInterfaceStatusAvailable)
+	c.Assert(*attached.Attachment.InstanceId, Equals, "i-00000003")
+}
+
+func (s *NetworkTest) TestClaimInterfaceAlreadyAttached(c *C) {
+	attached := poolInterface("eni-00000002", "us-east-1a", "i-00000002")
+	available := poolInterface("eni-00000001", "us-east-1a", "")
+	n, _ := interfaceClaimer(available, attached)
+
+	networkInterface, err := n.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*networkInterface.NetworkInterfaceId, Equals, "eni-00000002")
+	c.Assert(*available.Status, Equals, ec2.NetworkInterfaceStatusAvailable)
+}
+
+func (s *NetworkTest) TestClaimInterfaceNoneAvailable(c *C) {
+	n, _ := interfaceClaimer(poolInterface("eni-00000001", "us-east-1b", ""))
+
+	_, err := n.Claim("i-00000002")
+	c.Assert(err, Equals, ErrNoInterfaceAvailable)
+
+	shouldContinue, err := n.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionLaunching,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, false)
+}
+
+func (s *NetworkTest) TestReleaseInterface(c *C) {
+	attached := poolInterface("eni-00000001", "us-east-1a", "i-00000002")
+	other := poolInterface("eni-00000002", "us-east-1a", "i-00000003")
+	n, _ := interfaceClaimer(attached, other)
+
+	shouldContinue, err := n.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(*attached.Status, Equals, ec2.NetworkInterfaceStatusAvailable)
+	c.Assert(attached.Attachment, IsNil)
+	c.Assert(*other.Attachment.InstanceId, Equals, "i-00000003")
+}
@@ -0,0 +1,78 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+// PaginationTest checks that results spread over several pages are
+// combined. The fakes return one item per page.
+type PaginationTest struct {
+}
+
+var _ = Suite(&PaginationTest{})
+
+func (s *PaginationTest) TestAutoscalingGroups(c *C) {
+	autoscalingSvc := &fakeAutoScaling{
+		groups: []*autoscaling.Group{
+			{AutoScalingGroupName: aws.String("example-a")},
+			{AutoScalingGroupName: aws.String("example-b")},
+			{AutoScalingGroupName: aws.String("example-c")},
+		},
+	}
+	cluster := &Cluster{TagName: "cluster", TagValue: "example", AutoScaling: autoscalingSvc}
+
+	groups, err := cluster.AutoscalingGroups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 3)
+
+	group, err := cluster.describeAutoscalingGroup("example-c")
+	c.Assert(err, IsNil)
+	c.Assert(*group.AutoScalingGroupName, Equals, "example-c")
+
+	_, err = cluster.describeAutoscalingGroup("example-d")
+	c.Assert(err, ErrorMatches, "cannot find autoscaling group example-d")
+}
+
+func (s *PaginationTest) TestMembers(c *C) {
+	now := time.Now()
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000003", ec2.InstanceStateNameRunning, now),
+			fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now.Add(-2*time.Hour)),
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now.Add(-time.Hour)),
+		},
+	}}
+
+	members, err := cluster.Members()
+	c.Assert(err, IsNil)
+	c.Assert(members, HasLen, 3)
+	c.Assert(*members[0].InstanceId, Equals, "i-00000001")
+}
+
+func (s *PaginationTest) TestAttachedVolumesAndInterfaces(c *C) {
+	ec2Svc := &fakeEC2{
+		volumes: []*ec2.Volume{
+			{VolumeId: aws.String("vol-00000002"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-00000001")}}},
+			{VolumeId: aws.String("vol-00000001"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-00000001")}}},
+		},
+		networkInterfaces: []*ec2.NetworkInterface{
+			{NetworkInterfaceId: aws.String("eni-00000001"), Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-00000001")}},
+			{NetworkInterfaceId: aws.String("eni-00000002"), Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-00000001")}},
+		},
+	}
+	cluster := &Cluster{EC2: ec2Svc}
+
+	volumes, err := (&VolumeClaimer{Cluster: cluster}).attachedVolumes("i-00000001")
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 2)
+	c.Assert(*volumes[0].VolumeId, Equals, "vol-00000001")
+
+	networkInterfaces, err := (&InterfaceClaimer{Cluster: cluster}).attachedInterfaces("i-00000001")
+	c.Assert(err, IsNil)
+	c.Assert(networkInterfaces, HasLen, 2)
+}
@@ -0,0 +1,102 @@
+package ec2cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Notification is a message received from a lifecycle event queue that
+// does not describe a lifecycle action. Queues that receive autoscaling
+// notifications carry events such as `autoscaling:EC2_INSTANCE_LAUNCH_ERROR`
+// and `autoscaling:TEST_NOTIFICATION`, and some deployments also publish
+// their own custom messages to the same queue.
+type Notification struct {
+	// Event is the type of the notification, for example
+	// `autoscaling:EC2_INSTANCE_LAUNCH_ERROR`. It is empty if the message
+	// does not have a recognizable type.
+	Event                string
+	AutoScalingGroupName string
+	EC2InstanceID        string
+	Time                 time.Time
+
+	// Body is the original text of the message.
+	Body string
+
+	// MessageAttributes are the String and Number attributes of the SQS
+	// message.
+	MessageAttributes map[string]string
+}
+
+// NotificationCallback is a function that is invoked for each message in a
+// lifecycle event queue that is not a lifecycle action. If the function
+// returns a non-nil error then the message remains in the queue.
+type NotificationCallback func(n *Notification) error
+
+// MessageParser decodes the body of a message received from a lifecycle event
+// queue. It returns either a lifecycle message or a notification. If both
+// are nil, the message is discarded. If an error is returned, watching stops.
+type MessageParser interface {
+	ParseMessage(body string) (*LifecycleMessage, *Notification, error)
+}
+
+// MessageParserFunc is an adapter to allow the use of an ordinary function
+// as a MessageParser.
+type MessageParserFunc func(body string) (*LifecycleMessage, *Notification, error)
+
+// ParseMessage calls f(body).
+func (f MessageParserFunc) ParseMessage(body string) (*LifecycleMessage, *Notification, error) {
+	return f(body)
+}
+
+// DefaultMessageParser is the MessageParser used when Cluster.MessageParser
+// is nil. It understands lifecycle hook messages and autoscaling
+// notifications, either delivered directly or wrapped in an SNS envelope.
+// Any other JSON message is returned as a Notification with an empty Event.
+var DefaultMessageParser MessageParser = MessageParserFunc(parseMessage)
+
+// snsEnvelope is the wrapper around messages that are delivered to SQS via
+// an SNS topic subscription.
+type snsEnvelope struct {
+	Type    string
+	Message string
+}
+
+// autoscalingNotification is the format of the notifications that an
+// autoscaling group sends to its notification targets.
+type autoscalingNotification struct {
+	Event                string
+	AutoScalingGroupName string
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	Time                 time.Time
+}
+
+func parseMessage(body string) (*LifecycleMessage, *Notification, error) {
+	envelope := snsEnvelope{}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	if envelope.Type == "Notification" && envelope.Message != "" {
+		body = envelope.Message
+	}
+
+	m := LifecycleMessage{}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	if m.LifecycleTransition != "" {
+		return &m, nil, nil
+	}
+
+	n := autoscalingNotification{}
+	if err := json.Unmarshal([]byte(body), &n); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	return nil, &Notification{
+		Event:                n.Event,
+		AutoScalingGroupName: n.AutoScalingGroupName,
+		EC2InstanceID:        n.EC2InstanceID,
+		Time:                 n.Time,
+		Body:                 body,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package ec2cluster
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type ParserTest struct {
+}
+
+var _ = Suite(&ParserTest{})
+
+func (s *ParserTest) TestLifecycleMessage(c *C) {
+	m, n, err := DefaultMessageParser.ParseMessage(`{
+		"AutoScalingGroupName": "example-Cluster1-Q0YWRWQJC5XL",
+		"Service": "AWS Auto Scaling",
+		"Time": "2016-02-26T21:09:59.517Z",
+		"AccountId": "012345678901",
+		"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING",
+		"RequestId": "74f5e1d3-2b9a-4b34-a1d7-0c3b7e0c0e3f",
+		"LifecycleActionToken": "0e7b1ab1-5e49-4a44-9a4e-3d43c8c0a2c1",
+		"EC2InstanceId": "i-403e6d87",
+		"LifecycleHookName": "example-TerminateHook"
+	}`)
+	c.Assert(err, IsNil)
+	c.Assert(n, IsNil)
+	c.Assert(m.LifecycleTransition, Equals, TransitionTerminating)
+	c.Assert(m.EC2InstanceID, Equals, "i-403e6d87")
+	c.Assert(m.LifecycleHookName, Equals, "example-TerminateHook")
+}
+
+func (s *ParserTest) TestWarmPoolMessage(c *C) {
+	m, _, err := DefaultMessageParser.ParseMessage(`{
+		"LifecycleTransition": "autoscaling:EC2_INSTANCE_LAUNCHING",
+		"EC2InstanceId": "i-403e6d87",
+		"Origin": "WarmPool",
+		"Destination": "AutoScalingGroup"
+	}`)
+	c.Assert(err, IsNil)
+	c.Assert(m.Origin, Equals, OriginWarmPool)
+	c.Assert(m.Destination, Equals, OriginAutoScalingGroup)
+}
+
+func (s *ParserTest) TestNotification(c *C) {
+	body := `{
+		"Event": "autoscaling:EC2_INSTANCE_LAUNCH_ERROR",
+		"AutoScalingGroupName": "example-Cluster1-Q0YWRWQJC5XL",
+		"EC2InstanceId": "i-403e6d87",
+		"Time": "2016-02-26T21:09:59.517Z"
+	}`
+	m, n, err := DefaultMessageParser.ParseMessage(body)
+	c.Assert(err, IsNil)
+	c.Assert(m, IsNil)
+	c.Assert(n.Event, Equals, "autoscaling:EC2_INSTANCE_LAUNCH_ERROR")
+	c.Assert(n.AutoScalingGroupName, Equals, "example-Cluster1-Q0YWRWQJC5XL")
+	c.Assert(n.EC2InstanceID, Equals, "i-403e6d87")
+	c.Assert(n.Body, Equals, body)
+}
+
+func (s *ParserTest) TestSNSEnvelope(c *C) {
+	m, n, err := DefaultMessageParser.ParseMessage(`{
+		"Type": "Notification",
+		"MessageId": "1c7e3b7e-2f4f-4d1b-9a0e-5a3f0e0b6c1d",
+		"Message": "{\"LifecycleTransition\":\"autoscaling:EC2_INSTANCE_LAUNCHING\",\"EC2InstanceId\":\"i-fefefefe\"}"
+	}`)
+	c.Assert(err, IsNil)
+	c.Assert(n, IsNil)
+	c.Assert(m.LifecycleTransition, Equals, TransitionLaunching)
+	c.Assert(m.EC2InstanceID, Equals, "i-fefefefe")
+}
+
+func (s *ParserTest) TestInvalid(c *C) {
+	_, _, err := DefaultMessageParser.ParseMessage(`not json`)
+	c.Assert(err, ErrorMatches, "cannot unmarshal event: .*")
+}
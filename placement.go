@@ -0,0 +1,118 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// GroupPlacement describes the placement group and capacity reservation
+// targeting that the autoscaling group of the current instance launches
+// instances into.
+type GroupPlacement struct {
+	// PlacementGroup is the placement group of the autoscaling group, or
+	// of its launch template, or empty if instances are not launched into
+	// a placement group.
+	PlacementGroup string
+
+	// Strategy is the strategy of the placement group: `cluster`, `spread`
+	// or `partition`. PartitionCount is the number of partitions of a
+	// `partition` placement group.
+	Strategy       string
+	PartitionCount int64
+
+	// CapacityReservationPreference, CapacityReservationID and
+	// CapacityReservationResourceGroupARN are the capacity reservation
+	// targeting of the launch template. The preference is `open` or `none`
+	// if the template does not target a specific reservation.
+	CapacityReservationPreference       string
+	CapacityReservationID               string
+	CapacityReservationResourceGroupARN string
+}
+
+// GroupPlacement returns the placement group and capacity reservation
+// targeting of the autoscaling group of the current instance, so that
+// partition-aware systems can map AWS topology into their configuration.
+func (s *Cluster) GroupPlacement() (*GroupPlacement, error) {
+	asg, err := s.AutoscalingGroup()
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
+		return nil, ErrNotInAutoscalingGroup
+	}
+	spec, err := s.LaunchSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	placement := &GroupPlacement{
+		PlacementGroup:                      aws.StringValue(asg.PlacementGroup),
+		CapacityReservationPreference:       spec.CapacityReservationPreference,
+		CapacityReservationID:               spec.CapacityReservationID,
+		CapacityReservationResourceGroupARN: spec.CapacityReservationResourceGroupARN,
+	}
+	if placement.PlacementGroup == "" {
+		placement.PlacementGroup = spec.PlacementGroup
+	}
+	if placement.PlacementGroup == "" {
+		return placement, nil
+	}
+
+	resp, err := s.ec2Client().DescribePlacementGroups(&ec2.DescribePlacementGroupsInput{
+		GroupNames: []*string{aws.String(placement.PlacementGroup)},
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribePlacementGroups", err)
+	}
+	if len(resp.PlacementGroups) != 1 {
+		return nil, &NotFoundError{Kind: "placement group", ID: placement.PlacementGroup}
+	}
+	placement.Strategy = aws.StringValue(resp.PlacementGroups[0].Strategy)
+	placement.PartitionCount = aws.Int64Value(resp.PlacementGroups[0].PartitionCount)
+	return placement, nil
+}
+
+// MemberPlacement describes where an instance was placed.
+type MemberPlacement struct {
+	PlacementGroup string
+
+	// PartitionNumber is the partition of a `partition` placement group
+	// that the instance is in, or zero. Partitions are numbered from one.
+	PartitionNumber int64
+
+	// CapacityReservationID is the capacity reservation that the instance
+	// is running in, or empty.
+	CapacityReservationID string
+}
+
+// InstancePlacement returns the placement group, partition and capacity
+// reservation of instance.
+func InstancePlacement(instance *ec2.Instance) MemberPlacement {
+	placement := MemberPlacement{
+		CapacityReservationID: aws.StringValue(instance.CapacityReservationId),
+	}
+	if instance.Placement != nil {
+		placement.PlacementGroup = aws.StringValue(instance.Placement.GroupName)
+		placement.PartitionNumber = aws.Int64Value(instance.Placement.PartitionNumber)
+	}
+	return placement
+}
+
+// MembersByPartition returns the running members of the cluster grouped by
+// the partition of their placement group, each oldest first. Members that
+// are not in a partition placement group are omitted.
+func (s *Cluster) MembersByPartition() (map[int64][]*ec2.Instance, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	rv := map[int64][]*ec2.Instance{}
+	for _, instance := range runningInstances(members) {
+		partition := InstancePlacement(instance).PartitionNumber
+		if partition == 0 {
+			continue
+		}
+		rv[partition] = append(rv[partition], instance)
+	}
+	return rv, nil
+}
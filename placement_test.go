@@ -0,0 +1,90 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type PlacementTest struct {
+}
+
+var _ = Suite(&PlacementTest{})
+
+func (s *PlacementTest) TestGroupPlacement(c *C) {
+	cluster := &Cluster{
+		EC2: &fakeEC2{
+			launchTemplateVersions: []*ec2.LaunchTemplateVersion{{
+				LaunchTemplateId:   aws.String("lt-0123456789abcdef0"),
+				LaunchTemplateName: aws.String("example"),
+				VersionNumber:      aws.Int64(1),
+				LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+					Placement: &ec2.LaunchTemplatePlacement{GroupName: aws.String("example-partitions")},
+					CapacityReservationSpecification: &ec2.LaunchTemplateCapacityReservationSpecificationResponse{
+						CapacityReservationTarget: &ec2.CapacityReservationTargetResponse{
+							CapacityReservationId: aws.String("cr-00000001"),
+						},
+					},
+				},
+			}},
+			placementGroups: []*ec2.PlacementGroup{{
+				GroupName:      aws.String("example-partitions"),
+				Strategy:       aws.String(ec2.PlacementStrategyPartition),
+				PartitionCount: aws.Int64(3),
+			}},
+		},
+		autoScalingGroup: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("example"),
+			LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-0123456789abcdef0"),
+			},
+		},
+	}
+
+	placement, err := cluster.GroupPlacement()
+	c.Assert(err, IsNil)
+	c.Assert(placement, DeepEquals, &GroupPlacement{
+		PlacementGroup:        "example-partitions",
+		Strategy:              ec2.PlacementStrategyPartition,
+		PartitionCount:        3,
+		CapacityReservationID: "cr-00000001",
+	})
+
+	// The placement group of the autoscaling group takes precedence, and
+	// must exist.
+	cluster.autoScalingGroup.PlacementGroup = aws.String("example-missing")
+	_, err = cluster.GroupPlacement()
+	c.Assert(IsNotFound(err), Equals, true)
+}
+
+func (s *PlacementTest) TestMembersByPartition(c *C) {
+	now := time.Now()
+	inPartition := func(instanceID string, partition int64) *ec2.Instance {
+		instance := fakeInstance(instanceID, ec2.InstanceStateNameRunning, now.Add(time.Duration(partition)*time.Minute))
+		instance.Placement.GroupName = aws.String("example-partitions")
+		instance.Placement.PartitionNumber = aws.Int64(partition)
+		return instance
+	}
+	reserved := fakeInstance("i-00000004", ec2.InstanceStateNameRunning, now)
+	reserved.CapacityReservationId = aws.String("cr-00000001")
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: &fakeEC2{
+		instances: []*ec2.Instance{
+			inPartition("i-00000001", 1),
+			inPartition("i-00000002", 2),
+			inPartition("i-00000003", 1),
+			reserved,
+		},
+	}}
+
+	partitions, err := cluster.MembersByPartition()
+	c.Assert(err, IsNil)
+	c.Assert(partitions, HasLen, 2)
+	c.Assert(partitions[1], HasLen, 2)
+	c.Assert(*partitions[2][0].InstanceId, Equals, "i-00000002")
+
+	c.Assert(InstancePlacement(reserved), Equals, MemberPlacement{CapacityReservationID: "cr-00000001"})
+	c.Assert(newSnapshotMember(partitions[2][0]).PartitionNumber, Equals, int64(2))
+}
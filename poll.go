@@ -0,0 +1,73 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// maxReceiveWaitTime is the longest that SQS allows a ReceiveMessage call
+// to wait for messages.
+const maxReceiveWaitTime = 20 * time.Second
+
+// receiveInput returns the ReceiveMessage request used to receive from
+// queueURL, tuned by ReceiveWaitTime, ReceiveMaxMessages and
+// ReceiveVisibilityTimeout.
+func (s *Cluster) receiveInput(queueURL string) *sqs.ReceiveMessageInput {
+	waitTime := s.ReceiveWaitTime
+	if waitTime <= 0 || waitTime > maxReceiveWaitTime {
+		waitTime = maxReceiveWaitTime
+	}
+	maxMessages := s.ReceiveMaxMessages
+	if maxMessages <= 0 || maxMessages > maxReceiveMessages {
+		maxMessages = maxReceiveMessages
+	}
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queueURL),
+		MaxNumberOfMessages: aws.Int64(maxMessages),
+		WaitTimeSeconds:     aws.Int64(int64(waitTime / time.Second)),
+
+		MessageAttributeNames: []*string{aws.String("All")},
+	}
+	if s.ReceiveVisibilityTimeout > 0 {
+		// Round up, so that a timeout of less than a second does not make
+		// the messages visible to other consumers at once.
+		input.VisibilityTimeout = aws.Int64(int64((s.ReceiveVisibilityTimeout + time.Second - 1) / time.Second))
+	}
+	return input
+}
+
+// receiveBackoff slows down receiving from a queue that has been empty for
+// a while, as configured by EmptyReceiveBackoff.
+type receiveBackoff struct {
+	cluster *Cluster
+	delay   time.Duration
+}
+
+// received records how many messages a receive returned. If there were none,
+// it waits before the next receive, twice as long as after the previous
+// empty receive, starting from one second, up to EmptyReceiveBackoff. It
+// returns early with ctx.Err() if ctx is done.
+func (b *receiveBackoff) received(ctx context.Context, count int) error {
+	maxDelay := b.cluster.EmptyReceiveBackoff
+	if count > 0 || maxDelay <= 0 {
+		b.delay = 0
+		return nil
+	}
+	if b.delay == 0 {
+		b.delay = time.Second
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > maxDelay {
+		b.delay = maxDelay
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(b.cluster.jitter(b.delay)):
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PollTest struct {
+}
+
+var _ = Suite(&PollTest{})
+
+func (s *PollTest) TestReceiveInput(c *C) {
+	cluster := &Cluster{}
+	input := cluster.receiveInput("https://sqs.us-east-1.amazonaws.com/012345678901/example")
+	c.Assert(*input.WaitTimeSeconds, Equals, int64(20))
+	c.Assert(*input.MaxNumberOfMessages, Equals, int64(10))
+	c.Assert(input.VisibilityTimeout, IsNil)
+
+	cluster = &Cluster{ReceiveWaitTime: 5 * time.Second, ReceiveMaxMessages: 1, ReceiveVisibilityTimeout: 10 * time.Minute}
+	input = cluster.receiveInput("https://sqs.us-east-1.amazonaws.com/012345678901/example")
+	c.Assert(*input.WaitTimeSeconds, Equals, int64(5))
+	c.Assert(*input.MaxNumberOfMessages, Equals, int64(1))
+	c.Assert(*input.VisibilityTimeout, Equals, int64(600))
+
+	// Visibility timeouts are rounded up to whole seconds.
+	cluster = &Cluster{ReceiveVisibilityTimeout: 500 * time.Millisecond}
+	input = cluster.receiveInput("https://sqs.us-east-1.amazonaws.com/012345678901/example")
+	c.Assert(*input.VisibilityTimeout, Equals, int64(1))
+
+	// Values beyond what SQS accepts are clamped.
+	cluster = &Cluster{ReceiveWaitTime: time.Minute, ReceiveMaxMessages: 100}
+	input = cluster.receiveInput("https://sqs.us-east-1.amazonaws.com/012345678901/example")
+	c.Assert(*input.WaitTimeSeconds, Equals, int64(20))
+	c.Assert(*input.MaxNumberOfMessages, Equals, int64(10))
+}
+
+func (s *PollTest) TestReceiveBackoff(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	backoff := &receiveBackoff{cluster: &Cluster{EmptyReceiveBackoff: 3 * time.Second}}
+	delays := []time.Duration{}
+	for i := 0; i < 4; i++ {
+		c.Assert(backoff.received(ctx, 0), Equals, context.Canceled)
+		delays = append(delays, backoff.delay)
+	}
+	c.Assert(delays, DeepEquals, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second})
+
+	// Receiving a message resets the backoff.
+	c.Assert(backoff.received(ctx, 1), IsNil)
+	c.Assert(backoff.delay, Equals, time.Duration(0))
+
+	// Without EmptyReceiveBackoff there is no pause.
+	backoff = &receiveBackoff{cluster: &Cluster{}}
+	c.Assert(backoff.received(ctx, 0), IsNil)
+}
@@ -0,0 +1,143 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// queueRetryInterval is how long to wait before looking up a lifecycle
+// queue again after SQS reports that it does not exist.
+var queueRetryInterval = 10 * time.Second
+
+// queueResolveAttempts is the number of consecutive times a missing queue
+// is looked up before giving up. Together with queueRetryInterval this
+// covers the minute that SQS takes to allow a deleted queue to be created
+// again.
+const queueResolveAttempts = 6
+
+// lifecycleQueue tracks the URL of a lifecycle event queue that is being
+// watched, so that the watcher follows the queue if it is recreated or if
+// the lifecycle hook is changed to point to a different queue.
+type lifecycleQueue struct {
+	url string
+
+	// resolve returns the current URL of the queue.
+	resolve func() (string, error)
+
+	// refreshInterval, if not zero, is how often resolve is invoked even if
+	// the queue still exists.
+	refreshInterval time.Duration
+
+	resolvedAt time.Time
+	failures   int
+}
+
+func (s *Cluster) newLifecycleQueue(queueURL string, resolve func() (string, error)) *lifecycleQueue {
+	return &lifecycleQueue{
+		url:             queueURL,
+		resolve:         resolve,
+		refreshInterval: s.QueueURLRefreshInterval,
+		resolvedAt:      time.Now(),
+	}
+}
+
+// refresh looks up the queue URL again if refreshInterval has elapsed. Errors
+// are logged and the current URL is kept.
+func (q *lifecycleQueue) refresh() {
+	if q.refreshInterval == 0 || time.Since(q.resolvedAt) < q.refreshInterval {
+		return
+	}
+	q.resolvedAt = time.Now()
+	queueURL, err := q.resolve()
+	if err != nil {
+		log.Printf("ERROR: cannot refresh lifecycle queue URL: %s", err)
+		return
+	}
+	if queueURL != q.url {
+		log.Printf("lifecycle queue changed from %s to %s", q.url, queueURL)
+		q.url = queueURL
+	}
+}
+
+// recover handles an error from receiving from the queue. If the error
+// indicates that the queue no longer exists, it waits and then looks up the
+// queue URL again, returning nil so that the caller retries. Otherwise, or
+// once the queue has been missing for too long, the error is returned. If
+// ctx is done while waiting, ctx.Err() is returned.
+func (q *lifecycleQueue) recover(ctx context.Context, err error) error {
+	if !isNonExistentQueue(err) {
+		return err
+	}
+	q.failures++
+	if q.failures > queueResolveAttempts {
+		return err
+	}
+	log.Printf("lifecycle queue %s does not exist, looking it up again", q.url)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(queueRetryInterval):
+	}
+
+	queueURL, resolveErr := q.resolve()
+	if resolveErr != nil {
+		log.Printf("ERROR: cannot find lifecycle queue: %s", resolveErr)
+		return nil
+	}
+	q.url = queueURL
+	q.resolvedAt = time.Now()
+	return nil
+}
+
+// received records that receiving from the queue succeeded.
+func (q *lifecycleQueue) received() {
+	q.failures = 0
+}
+
+// isNonExistentQueue returns true if err is the error SQS returns when a
+// queue has been deleted.
+func isNonExistentQueue(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == sqs.ErrCodeQueueDoesNotExist
+	}
+	return false
+}
+
+// queueName returns the name of the queue from its URL.
+func queueName(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
+}
+
+// queueOwner returns the AWS account ID of the owner of the queue from its
+// URL, or an empty string if the URL does not include it.
+func queueOwner(queueURL string) string {
+	parts := strings.Split(queueURL, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// queueURLResolver returns a function that looks up the URL of the queue
+// with the same name and owner as queueURL. It is used for queues that the
+// caller specified, so that a watcher follows that queue if it is recreated
+// rather than switching to the queue of the lifecycle hook.
+func (s *Cluster) queueURLResolver(queueURL string) func() (string, error) {
+	return func() (string, error) {
+		input := &sqs.GetQueueUrlInput{QueueName: aws.String(queueName(queueURL))}
+		if owner := queueOwner(queueURL); owner != "" {
+			input.QueueOwnerAWSAccountId = aws.String(owner)
+		}
+		resp, err := s.sqsClient().GetQueueUrl(input)
+		if err != nil {
+			return "", wrapAPIError("GetQueueUrl", err)
+		}
+		return *resp.QueueUrl, nil
+	}
+}
@@ -0,0 +1,110 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type QueueTest struct {
+}
+
+var _ = Suite(&QueueTest{})
+
+func (s *QueueTest) SetUpTest(c *C) {
+	queueRetryInterval = time.Millisecond
+}
+
+func (s *QueueTest) TearDownTest(c *C) {
+	queueRetryInterval = 10 * time.Second
+}
+
+func (s *QueueTest) TestRecoverNonExistentQueue(c *C) {
+	q := lifecycleQueue{
+		url: "https://sqs.us-east-1.amazonaws.com/012345678901/old",
+		resolve: func() (string, error) {
+			return "https://sqs.us-east-1.amazonaws.com/012345678901/new", nil
+		},
+	}
+	err := q.recover(context.Background(), awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil))
+	c.Assert(err, IsNil)
+	c.Assert(q.url, Equals, "https://sqs.us-east-1.amazonaws.com/012345678901/new")
+}
+
+func (s *QueueTest) TestRecoverGivesUp(c *C) {
+	q := lifecycleQueue{
+		url: "https://sqs.us-east-1.amazonaws.com/012345678901/old",
+		resolve: func() (string, error) {
+			return "", ErrLifecycleHookNotFound
+		},
+	}
+	queueErr := awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil)
+	for i := 0; i < queueResolveAttempts; i++ {
+		c.Assert(q.recover(context.Background(), queueErr), IsNil)
+	}
+	c.Assert(q.recover(context.Background(), queueErr), Equals, queueErr)
+}
+
+func (s *QueueTest) TestRecoverOtherError(c *C) {
+	q := lifecycleQueue{
+		resolve: func() (string, error) {
+			c.Fatal("should not be called")
+			return "", nil
+		},
+	}
+	otherErr := errors.New("something else")
+	c.Assert(q.recover(context.Background(), otherErr), Equals, otherErr)
+}
+
+func (s *QueueTest) TestRefresh(c *C) {
+	q := lifecycleQueue{
+		url:             "https://sqs.us-east-1.amazonaws.com/012345678901/old",
+		refreshInterval: time.Minute,
+		resolvedAt:      time.Now(),
+		resolve: func() (string, error) {
+			return "https://sqs.us-east-1.amazonaws.com/012345678901/new", nil
+		},
+	}
+	q.refresh()
+	c.Assert(q.url, Equals, "https://sqs.us-east-1.amazonaws.com/012345678901/old")
+
+	q.resolvedAt = time.Now().Add(-2 * time.Minute)
+	q.refresh()
+	c.Assert(q.url, Equals, "https://sqs.us-east-1.amazonaws.com/012345678901/new")
+}
+
+func (s *QueueTest) TestRecoverCancelled(c *C) {
+	queueRetryInterval = time.Hour
+	q := lifecycleQueue{
+		url: "https://sqs.us-east-1.amazonaws.com/012345678901/old",
+		resolve: func() (string, error) {
+			c.Fatal("should not be called")
+			return "", nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := q.recover(ctx, awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil))
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *QueueTest) TestExplicitQueueResolvedByName(c *C) {
+	// A queue given explicitly is looked up by its own name, not replaced
+	// by the queue of the lifecycle hook.
+	cluster := &Cluster{
+		SQS: &fakeSQS{},
+		AutoScaling: &fakeAutoScaling{hooks: []*autoscaling.LifecycleHook{{
+			NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:012345678901:production"),
+		}}},
+	}
+	resolve := cluster.queueURLResolver("https://sqs.us-east-1.amazonaws.com/012345678901/dry-run")
+	queueURL, err := resolve()
+	c.Assert(err, IsNil)
+	c.Assert(queueURL, Equals, "https://sqs.us-east-1.amazonaws.com/012345678901/dry-run")
+}
@@ -0,0 +1,77 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// MembersByAZ returns the running members of the cluster grouped by
+// availability zone, each oldest first.
+func (s *Cluster) MembersByAZ() (map[string][]*ec2.Instance, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	rv := map[string][]*ec2.Instance{}
+	for _, instance := range runningInstances(members) {
+		if instance.Placement == nil {
+			continue
+		}
+		availabilityZone := aws.StringValue(instance.Placement.AvailabilityZone)
+		rv[availabilityZone] = append(rv[availabilityZone], instance)
+	}
+	return rv, nil
+}
+
+// QuorumSize returns the number of instances that make up a majority of the
+// cluster, based on the total desired capacity of its autoscaling groups.
+func (s *Cluster) QuorumSize() (int, error) {
+	groups, err := s.AutoscalingGroups()
+	if err != nil {
+		return 0, err
+	}
+	return quorumSize(groups), nil
+}
+
+// HasQuorum returns true if at least QuorumSize instances of the cluster's
+// autoscaling groups are InService and every availability zone of the
+// groups has an InService instance. Consensus-based systems can use it to
+// refuse to bootstrap while a whole zone is missing.
+func (s *Cluster) HasQuorum() (bool, error) {
+	groups, err := s.AutoscalingGroups()
+	if err != nil {
+		return false, err
+	}
+	return hasQuorum(groups), nil
+}
+
+func quorumSize(groups []*autoscaling.Group) int {
+	desiredCapacity := 0
+	for _, group := range groups {
+		desiredCapacity += int(aws.Int64Value(group.DesiredCapacity))
+	}
+	return desiredCapacity/2 + 1
+}
+
+func hasQuorum(groups []*autoscaling.Group) bool {
+	inService := 0
+	covered := map[string]bool{}
+	for _, group := range groups {
+		for _, instance := range group.Instances {
+			if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+				inService++
+				covered[aws.StringValue(instance.AvailabilityZone)] = true
+			}
+		}
+	}
+
+	for _, group := range groups {
+		for _, availabilityZone := range group.AvailabilityZones {
+			if !covered[aws.StringValue(availabilityZone)] {
+				return false
+			}
+		}
+	}
+	return inService >= quorumSize(groups)
+}
@@ -0,0 +1,46 @@
+package ec2cluster
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	. "gopkg.in/check.v1"
+)
+
+type QuorumTest struct {
+}
+
+var _ = Suite(&QuorumTest{})
+
+func quorumGroup(desiredCapacity int64, zones []string, states ...string) *autoscaling.Group {
+	group := &autoscaling.Group{
+		DesiredCapacity:   aws.Int64(desiredCapacity),
+		AvailabilityZones: aws.StringSlice(zones),
+	}
+	for i, state := range states {
+		group.Instances = append(group.Instances, &autoscaling.Instance{
+			AvailabilityZone: aws.String(zones[i%len(zones)]),
+			LifecycleState:   aws.String(state),
+		})
+	}
+	return group
+}
+
+func (s *QuorumTest) TestQuorumSize(c *C) {
+	c.Assert(quorumSize([]*autoscaling.Group{quorumGroup(3, []string{"a"})}), Equals, 2)
+	c.Assert(quorumSize([]*autoscaling.Group{quorumGroup(4, []string{"a"})}), Equals, 3)
+	c.Assert(quorumSize([]*autoscaling.Group{
+		quorumGroup(2, []string{"a"}),
+		quorumGroup(3, []string{"b"}),
+	}), Equals, 3)
+}
+
+func (s *QuorumTest) TestHasQuorum(c *C) {
+	zones := []string{"us-east-1a", "us-east-1b", "us-east-1c"}
+	inService := autoscaling.LifecycleStateInService
+	pending := autoscaling.LifecycleStatePending
+
+	c.Assert(hasQuorum([]*autoscaling.Group{quorumGroup(3, zones, inService, inService, inService)}), Equals, true)
+	c.Assert(hasQuorum([]*autoscaling.Group{quorumGroup(3, zones, inService, inService, pending)}), Equals, false)
+	c.Assert(hasQuorum([]*autoscaling.Group{quorumGroup(3, zones[:2], inService, inService, pending)}), Equals, true)
+	c.Assert(hasQuorum([]*autoscaling.Group{quorumGroup(3, zones, inService)}), Equals, false)
+}
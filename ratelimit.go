@@ -0,0 +1,107 @@
+package ec2cluster
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RateLimiter limits the rate of AWS API calls. Wait blocks until a call
+// may be made, or returns an error if ctx is done first.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter returns a RateLimiter that allows `rate` calls per second
+// on average, with bursts of up to `burst` calls. A rate of zero or less
+// does not limit calls, and a burst of less than one is taken as one.
+func NewRateLimiter(rate float64, burst int) RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		delay := b.take()
+		if delay == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// take removes a token from the bucket and returns zero, or returns how long
+// to wait until a token is available.
+func (b *tokenBucket) take() time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimitHandler returns a request handler that waits for limiter before
+// each attempt of a request, including retries.
+func rateLimitHandler(limiter RateLimiter) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "ec2cluster.RateLimit",
+		Fn: func(r *request.Request) {
+			if err := limiter.Wait(r.Context()); err != nil {
+				r.Error = err
+			}
+		},
+	}
+}
+
+// limitRate makes requests sent with handlers wait for limiter, if it is not
+// nil.
+func limitRate(handlers *request.Handlers, limiter RateLimiter) {
+	if limiter != nil {
+		handlers.Sign.PushFrontNamed(rateLimitHandler(limiter))
+	}
+}
+
+// jitter returns interval varied randomly by up to PollJitter of its length
+// in either direction.
+func (s *Cluster) jitter(interval time.Duration) time.Duration {
+	if s.PollJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration((rand.Float64()*2-1)*s.PollJitter*float64(interval))
+}
@@ -0,0 +1,64 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RateLimitTest struct {
+}
+
+var _ = Suite(&RateLimitTest{})
+
+func (s *RateLimitTest) TestTokenBucket(c *C) {
+	limiter := NewRateLimiter(0.001, 2).(*tokenBucket)
+	c.Assert(limiter.take(), Equals, time.Duration(0))
+	c.Assert(limiter.take(), Equals, time.Duration(0))
+	c.Assert(limiter.take() > time.Minute, Equals, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(limiter.Wait(ctx), Equals, context.Canceled)
+}
+
+func (s *RateLimitTest) TestUnlimited(c *C) {
+	limiter := NewRateLimiter(0, 1).(*tokenBucket)
+	for i := 0; i < 10; i++ {
+		c.Assert(limiter.take(), Equals, time.Duration(0))
+	}
+	limiter = NewRateLimiter(-1, 1).(*tokenBucket)
+	c.Assert(limiter.take(), Equals, time.Duration(0))
+	c.Assert(limiter.take(), Equals, time.Duration(0))
+}
+
+func (s *RateLimitTest) TestZeroBurst(c *C) {
+	// A burst of zero allows one call at a time rather than none.
+	limiter := NewRateLimiter(100, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		c.Assert(limiter.Wait(ctx), IsNil)
+	}
+}
+
+func (s *RateLimitTest) TestWait(c *C) {
+	limiter := NewRateLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		c.Assert(limiter.Wait(context.Background()), IsNil)
+	}
+	c.Assert(time.Since(start) >= 15*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitTest) TestJitter(c *C) {
+	cluster := &Cluster{}
+	c.Assert(cluster.jitter(time.Second), Equals, time.Second)
+
+	cluster.PollJitter = 0.1
+	for i := 0; i < 100; i++ {
+		interval := cluster.jitter(time.Second)
+		c.Assert(interval >= 900*time.Millisecond && interval <= 1100*time.Millisecond, Equals, true)
+	}
+}
@@ -0,0 +1,193 @@
+package ec2cluster
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// EventRecord records how a lifecycle action was handled.
+type EventRecord struct {
+	Time    time.Time         `json:"time"`
+	Message *LifecycleMessage `json:"message"`
+
+	// Result is the result that the action was completed with,
+	// ResultContinue or ResultAbandon, or empty if the callback failed and
+	// the action was left in the queue.
+	Result string `json:"result,omitempty"`
+
+	// CallbackError and CompleteError are the errors returned by the
+	// callback and by CompleteLifecycleAction.
+	CallbackError string `json:"callback_error,omitempty"`
+	CompleteError string `json:"complete_error,omitempty"`
+
+	// DryRun is true if the action was handled with DryRun set, so that it
+	// was not completed and Result is the result it would have had.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Recorder stores EventRecords, for auditing or to replay them later with
+// Replay.
+type Recorder interface {
+	Record(r *EventRecord) error
+}
+
+// record passes a record of the lifecycle action m to Recorder, if set.
+// Errors are logged.
+func (s *Cluster) record(m *LifecycleMessage, result string, callbackErr, completeErr error) {
+	if s.Recorder == nil {
+		return
+	}
+	r := &EventRecord{Time: time.Now(), Message: m, Result: result, DryRun: s.DryRun}
+	if callbackErr != nil {
+		r.CallbackError = callbackErr.Error()
+	}
+	if completeErr != nil {
+		r.CompleteError = completeErr.Error()
+	}
+	if err := s.Recorder.Record(r); err != nil {
+		log.Printf("ERROR: cannot record lifecycle action for %s: %s", m.EC2InstanceID, err)
+	}
+}
+
+// Replay invokes cb for the lifecycle action of each of records, in order,
+// and returns a record of each new result. Lifecycle actions are not
+// completed, so recorded events can be used to test drain logic.
+func Replay(ctx context.Context, records []*EventRecord, cb LifecycleEventContextCallback) []*EventRecord {
+	rv := []*EventRecord{}
+	for _, record := range records {
+		if record.Message == nil {
+			continue
+		}
+		r := &EventRecord{Time: time.Now(), Message: record.Message}
+		shouldContinue, err := cb(ctx, record.Message)
+		switch {
+		case err != nil:
+			r.CallbackError = err.Error()
+		case shouldContinue:
+			r.Result = ResultContinue
+		default:
+			r.Result = ResultAbandon
+		}
+		rv = append(rv, r)
+	}
+	return rv
+}
+
+// eventRecordPrefix is the prefix, within a StateStore, of the records
+// written by S3Recorder.
+const eventRecordPrefix = "events/"
+
+// S3Recorder is a Recorder that writes each record as a JSON object to a
+// StateStore.
+type S3Recorder struct {
+	Store *StateStore
+}
+
+func (r *S3Recorder) Record(record *EventRecord) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// Keys sort in the order that the records were written.
+	key := eventRecordPrefix + record.Time.UTC().Format("20060102T150405.000000000Z") +
+		"-" + record.Message.EC2InstanceID + ".json"
+	return r.Store.write(key, buf)
+}
+
+// Records returns the records written to the store, oldest first.
+func (r *S3Recorder) Records() ([]*EventRecord, error) {
+	keys, err := r.Store.list(eventRecordPrefix)
+	if err != nil {
+		return nil, err
+	}
+	rv := []*EventRecord{}
+	for _, key := range keys {
+		buf, _, err := r.Store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		record := &EventRecord{}
+		if err := json.Unmarshal(buf, record); err != nil {
+			return nil, err
+		}
+		rv = append(rv, record)
+	}
+	return rv, nil
+}
+
+// CloudWatchLogsRecorder is a Recorder that writes each record as a JSON log
+// event to a CloudWatch Logs stream. The log group must exist; the stream is
+// created if needed.
+type CloudWatchLogsRecorder struct {
+	Cluster       *Cluster
+	LogGroupName  string
+	LogStreamName string
+
+	mu            sync.Mutex
+	streamCreated bool
+}
+
+func (r *CloudWatchLogsRecorder) Record(record *EventRecord) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logsSvc := r.Cluster.cloudWatchLogsClient()
+	if !r.streamCreated {
+		_, err := logsSvc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(r.LogGroupName),
+			LogStreamName: aws.String(r.LogStreamName),
+		})
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		r.streamCreated = true
+	}
+
+	_, err = logsSvc.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(r.LogGroupName),
+		LogStreamName: aws.String(r.LogStreamName),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{{
+			Message:   aws.String(string(buf)),
+			Timestamp: aws.Int64(record.Time.UnixNano() / int64(time.Millisecond)),
+		}},
+	})
+	return err
+}
+
+// Records returns the records in the log stream, oldest first. Log events
+// that are not records are skipped.
+func (r *CloudWatchLogsRecorder) Records() ([]*EventRecord, error) {
+	rv := []*EventRecord{}
+	err := r.Cluster.cloudWatchLogsClient().GetLogEventsPages(&cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(r.LogGroupName),
+		LogStreamName: aws.String(r.LogStreamName),
+		StartFromHead: aws.Bool(true),
+	}, func(resp *cloudwatchlogs.GetLogEventsOutput, lastPage bool) bool {
+		for _, event := range resp.Events {
+			record := &EventRecord{}
+			if err := json.Unmarshal([]byte(aws.StringValue(event.Message)), record); err != nil {
+				continue
+			}
+			rv = append(rv, record)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
@@ -0,0 +1,77 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	. "gopkg.in/check.v1"
+)
+
+type RecorderTest struct {
+}
+
+var _ = Suite(&RecorderTest{})
+
+func (s *RecorderTest) TestRecordAndReplay(c *C) {
+	sqsSvc := &fakeSQS{
+		receives: []*sqs.ReceiveMessageOutput{{
+			Messages: []*sqs.Message{
+				{
+					ReceiptHandle: aws.String("launching"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","EC2InstanceId":"i-00000001"}`),
+				},
+				{
+					ReceiptHandle: aws.String("terminating"),
+					Body:          aws.String(`{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","EC2InstanceId":"i-00000002"}`),
+				},
+			},
+		}},
+	}
+	recorder := &S3Recorder{Store: &StateStore{Cluster: &Cluster{S3: newFakeS3()}, Bucket: "bucket", Prefix: "example/"}}
+	cluster := &Cluster{SQS: sqsSvc, AutoScaling: &fakeAutoScaling{}, Recorder: recorder}
+
+	err := cluster.WatchLifecycleEvents("https://sqs.us-east-1.amazonaws.com/012345678901/example", func(m *LifecycleMessage) (bool, error) {
+		if m.LifecycleTransition == TransitionTerminating {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, errEndOfTest)
+
+	records, err := recorder.Records()
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 2)
+	c.Assert(records[0].Message.EC2InstanceID, Equals, "i-00000001")
+	c.Assert(records[0].Result, Equals, ResultContinue)
+	c.Assert(records[1].Message.EC2InstanceID, Equals, "i-00000002")
+	c.Assert(records[1].Result, Equals, "")
+	c.Assert(records[1].CallbackError, Equals, "not ready")
+
+	replayed := Replay(context.Background(), records, func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		return m.LifecycleTransition == TransitionLaunching, nil
+	})
+	c.Assert(replayed, HasLen, 2)
+	c.Assert(replayed[0].Result, Equals, ResultContinue)
+	c.Assert(replayed[1].Result, Equals, ResultAbandon)
+}
+
+func (s *RecorderTest) TestCloudWatchLogsRecorder(c *C) {
+	logsSvc := &fakeCloudWatchLogs{}
+	recorder := &CloudWatchLogsRecorder{
+		Cluster:       &Cluster{CloudWatchLogs: logsSvc},
+		LogGroupName:  "ec2cluster",
+		LogStreamName: "example",
+	}
+	cluster := &Cluster{Recorder: recorder}
+	cluster.record(&LifecycleMessage{EC2InstanceID: "i-00000001"}, ResultContinue, nil, nil)
+	cluster.record(&LifecycleMessage{EC2InstanceID: "i-00000002"}, ResultAbandon, nil, errors.New("no active lifecycle action"))
+
+	c.Assert(logsSvc.streams, Equals, 1)
+	records, err := recorder.Records()
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 2)
+	c.Assert(records[1].Message.EC2InstanceID, Equals, "i-00000002")
+	c.Assert(records[1].CompleteError, Equals, "no active lifecycle action")
+}
@@ -0,0 +1,159 @@
+package ec2cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// InstanceRefreshOptions controls how an instance refresh replaces the
+// instances in an autoscaling group.
+type InstanceRefreshOptions struct {
+	// Warmup is how long to wait after a new instance reaches the InService
+	// state before it is counted as healthy. If zero, the health check grace
+	// period of the autoscaling group is used.
+	Warmup time.Duration
+
+	// MinHealthyPercentage is the percentage of the desired capacity that
+	// must remain healthy during the refresh. If zero, AWS uses 90 percent.
+	MinHealthyPercentage int64
+
+	// SkipMatching, if true, skips replacing instances that already match
+	// the desired configuration of the autoscaling group.
+	SkipMatching bool
+}
+
+// InstanceRefreshProgress describes the state of an instance refresh.
+type InstanceRefreshProgress struct {
+	InstanceRefreshID  string
+	Status             string
+	StatusReason       string
+	PercentageComplete int64
+	InstancesToUpdate  int64
+	StartTime          time.Time
+	EndTime            time.Time
+}
+
+// Done returns true if the instance refresh has finished, whether it
+// succeeded or not.
+func (p InstanceRefreshProgress) Done() bool {
+	switch p.Status {
+	case autoscaling.InstanceRefreshStatusSuccessful,
+		autoscaling.InstanceRefreshStatusFailed,
+		autoscaling.InstanceRefreshStatusCancelled,
+		autoscaling.InstanceRefreshStatusRollbackFailed,
+		autoscaling.InstanceRefreshStatusRollbackSuccessful:
+		return true
+	}
+	return false
+}
+
+// changed returns true if p differs from last. The times are compared with
+// Time.Equal, since the same time decoded from two responses need not be
+// identical.
+func (p *InstanceRefreshProgress) changed(last *InstanceRefreshProgress) bool {
+	return p.InstanceRefreshID != last.InstanceRefreshID ||
+		p.Status != last.Status ||
+		p.StatusReason != last.StatusReason ||
+		p.PercentageComplete != last.PercentageComplete ||
+		p.InstancesToUpdate != last.InstancesToUpdate ||
+		!p.StartTime.Equal(last.StartTime) ||
+		!p.EndTime.Equal(last.EndTime)
+}
+
+// StartInstanceRefresh starts a rolling replacement of the instances in the
+// autoscaling group and returns the ID of the instance refresh.
+func (s *Cluster) StartInstanceRefresh(opts InstanceRefreshOptions) (string, error) {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return "", err
+	}
+
+	preferences := &autoscaling.RefreshPreferences{
+		SkipMatching: aws.Bool(opts.SkipMatching),
+	}
+	if opts.Warmup != 0 {
+		preferences.InstanceWarmup = aws.Int64(int64(opts.Warmup / time.Second))
+	}
+	if opts.MinHealthyPercentage != 0 {
+		preferences.MinHealthyPercentage = aws.Int64(opts.MinHealthyPercentage)
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	resp, err := autoscalingSvc.StartInstanceRefresh(&autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(groupName),
+		Preferences:          preferences,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.InstanceRefreshId), nil
+}
+
+// InstanceRefresh returns the current progress of an instance refresh.
+func (s *Cluster) InstanceRefresh(instanceRefreshID string) (*InstanceRefreshProgress, error) {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return nil, err
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	resp, err := autoscalingSvc.DescribeInstanceRefreshes(&autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(groupName),
+		InstanceRefreshIds:   []*string{aws.String(instanceRefreshID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.InstanceRefreshes) != 1 {
+		return nil, &NotFoundError{Kind: "instance refresh", ID: instanceRefreshID}
+	}
+	refresh := resp.InstanceRefreshes[0]
+	return &InstanceRefreshProgress{
+		InstanceRefreshID:  aws.StringValue(refresh.InstanceRefreshId),
+		Status:             aws.StringValue(refresh.Status),
+		StatusReason:       aws.StringValue(refresh.StatusReason),
+		PercentageComplete: aws.Int64Value(refresh.PercentageComplete),
+		InstancesToUpdate:  aws.Int64Value(refresh.InstancesToUpdate),
+		StartTime:          aws.TimeValue(refresh.StartTime),
+		EndTime:            aws.TimeValue(refresh.EndTime),
+	}, nil
+}
+
+// InstanceRefreshCallback is a function that is invoked by
+// WatchInstanceRefresh each time the progress of an instance refresh
+// changes.
+type InstanceRefreshCallback func(p InstanceRefreshProgress)
+
+// WatchInstanceRefresh polls an instance refresh every `interval` and invokes
+// cb whenever its progress changes. It returns when the refresh is done,
+// with a nil error if the refresh was successful, or when ctx is cancelled.
+func (s *Cluster) WatchInstanceRefresh(ctx context.Context, instanceRefreshID string, interval time.Duration, cb InstanceRefreshCallback) error {
+	var last *InstanceRefreshProgress
+	for {
+		progress, err := s.InstanceRefresh(instanceRefreshID)
+		if err != nil {
+			return err
+		}
+		if last == nil || progress.changed(last) {
+			cb(*progress)
+			last = progress
+		}
+		if progress.Done() {
+			if progress.Status != autoscaling.InstanceRefreshStatusSuccessful {
+				return fmt.Errorf("instance refresh %s: %s: %s", instanceRefreshID,
+					progress.Status, progress.StatusReason)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.jitter(interval)):
+		}
+	}
+}
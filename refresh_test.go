@@ -0,0 +1,105 @@
+package ec2cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	. "gopkg.in/check.v1"
+)
+
+type RefreshTest struct {
+}
+
+var _ = Suite(&RefreshTest{})
+
+func instanceRefresh(status string, percentageComplete int64, startTime time.Time) *autoscaling.InstanceRefresh {
+	return &autoscaling.InstanceRefresh{
+		InstanceRefreshId:  aws.String("refresh-1"),
+		Status:             aws.String(status),
+		PercentageComplete: aws.Int64(percentageComplete),
+		StartTime:          aws.Time(startTime),
+	}
+}
+
+func refreshCluster(autoscalingSvc *fakeAutoScaling) *Cluster {
+	autoscalingSvc.groups = []*autoscaling.Group{{AutoScalingGroupName: aws.String("example")}}
+	return &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc}
+}
+
+func (s *RefreshTest) TestStartInstanceRefresh(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := refreshCluster(autoscalingSvc)
+
+	instanceRefreshID, err := cluster.StartInstanceRefresh(InstanceRefreshOptions{
+		Warmup:               5 * time.Minute,
+		MinHealthyPercentage: 75,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(instanceRefreshID, Equals, "refresh-1")
+	c.Assert(autoscalingSvc.startedRefreshes, HasLen, 1)
+	c.Assert(*autoscalingSvc.startedRefreshes[0].AutoScalingGroupName, Equals, "example")
+	c.Assert(autoscalingSvc.startedRefreshes[0].Preferences, DeepEquals, &autoscaling.RefreshPreferences{
+		SkipMatching:         aws.Bool(false),
+		InstanceWarmup:       aws.Int64(300),
+		MinHealthyPercentage: aws.Int64(75),
+	})
+}
+
+func (s *RefreshTest) TestWatchInstanceRefresh(c *C) {
+	// The start time is decoded from each response, so the same instant
+	// may come back in another location.
+	startTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	sameStartTime := startTime.In(time.FixedZone("UTC", 0))
+	done := instanceRefresh(autoscaling.InstanceRefreshStatusSuccessful, 100, sameStartTime)
+	done.EndTime = aws.Time(startTime.Add(time.Hour))
+	autoscalingSvc := &fakeAutoScaling{refreshes: []*autoscaling.InstanceRefresh{
+		instanceRefresh(autoscaling.InstanceRefreshStatusInProgress, 0, startTime),
+		instanceRefresh(autoscaling.InstanceRefreshStatusInProgress, 0, sameStartTime),
+		instanceRefresh(autoscaling.InstanceRefreshStatusInProgress, 50, sameStartTime),
+		instanceRefresh(autoscaling.InstanceRefreshStatusInProgress, 50, startTime),
+		done,
+	}}
+	cluster := refreshCluster(autoscalingSvc)
+
+	seen := []string{}
+	err := cluster.WatchInstanceRefresh(context.Background(), "refresh-1", time.Millisecond, func(p InstanceRefreshProgress) {
+		seen = append(seen, p.Status)
+	})
+	c.Assert(err, IsNil)
+
+	// The callback is invoked only when the progress changes.
+	c.Assert(seen, DeepEquals, []string{
+		autoscaling.InstanceRefreshStatusInProgress,
+		autoscaling.InstanceRefreshStatusInProgress,
+		autoscaling.InstanceRefreshStatusSuccessful,
+	})
+}
+
+func (s *RefreshTest) TestWatchInstanceRefreshFailed(c *C) {
+	failed := instanceRefresh(autoscaling.InstanceRefreshStatusFailed, 20, time.Now())
+	failed.StatusReason = aws.String("instances failed health checks")
+	autoscalingSvc := &fakeAutoScaling{refreshes: []*autoscaling.InstanceRefresh{failed}}
+	cluster := refreshCluster(autoscalingSvc)
+
+	calls := 0
+	err := cluster.WatchInstanceRefresh(context.Background(), "refresh-1", time.Millisecond, func(p InstanceRefreshProgress) {
+		calls++
+	})
+	c.Assert(err, ErrorMatches, "instance refresh refresh-1: Failed: instances failed health checks")
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *RefreshTest) TestWatchInstanceRefreshCancelled(c *C) {
+	autoscalingSvc := &fakeAutoScaling{refreshes: []*autoscaling.InstanceRefresh{
+		instanceRefresh(autoscaling.InstanceRefreshStatusPending, 0, time.Now()),
+	}}
+	cluster := refreshCluster(autoscalingSvc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := cluster.WatchInstanceRefresh(ctx, "refresh-1", time.Hour, func(p InstanceRefreshProgress) {
+		cancel()
+	})
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,187 @@
+package ec2cluster
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Steps reported by ReplaceInstance.
+const (
+	ReplaceStepProtectingPeers       = "ProtectingPeers"
+	ReplaceStepRemovingInstance      = "RemovingInstance"
+	ReplaceStepWaitingForReplacement = "WaitingForReplacement"
+	ReplaceStepReplacementInService  = "ReplacementInService"
+	ReplaceStepUnprotectingPeers     = "UnprotectingPeers"
+)
+
+// instanceProtectionBatchSize is the largest number of instances accepted
+// by a single SetInstanceProtection call.
+const instanceProtectionBatchSize = 50
+
+// ReplaceProgress describes a step of ReplaceInstance.
+type ReplaceProgress struct {
+	Step          string
+	InstanceID    string
+	ReplacementID string
+}
+
+// ReplaceOptions controls how ReplaceInstance removes an instance.
+type ReplaceOptions struct {
+	// Detach, if true, detaches the instance from the autoscaling group and
+	// then terminates it. Otherwise the instance is terminated while it is
+	// still a member of the group, which runs the group's termination
+	// lifecycle hooks.
+	Detach bool
+
+	// ShouldDecrementDesiredCapacity, if true, reduces the desired capacity
+	// of the group so that no replacement is launched. ReplaceInstance
+	// returns as soon as the instance has been removed.
+	ShouldDecrementDesiredCapacity bool
+
+	// Timeout is how long to wait for a replacement instance to reach the
+	// InService state. If zero, twenty minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often the autoscaling group is checked while
+	// waiting for the replacement. If zero, fifteen seconds is used.
+	PollInterval time.Duration
+
+	// Progress, if not nil, is invoked as each step begins.
+	Progress func(p ReplaceProgress)
+}
+
+// ReplaceInstance removes an instance from the autoscaling group and waits
+// for the group to launch a replacement and bring it into service. While
+// the instance is replaced, the other members of the group are protected
+// from scale in so that the group does not remove a healthy peer instead.
+// Returns the ID of the replacement instance.
+func (s *Cluster) ReplaceInstance(instanceID string, opts ReplaceOptions) (string, error) {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return "", err
+	}
+
+	progress := func(step, replacementID string) {
+		if opts.Progress != nil {
+			opts.Progress(ReplaceProgress{Step: step, InstanceID: instanceID, ReplacementID: replacementID})
+		}
+	}
+
+	group, err := s.describeAutoscalingGroup(groupName)
+	if err != nil {
+		return "", err
+	}
+	existing := map[string]bool{}
+	peers := []string{}
+	for _, instance := range group.Instances {
+		existing[*instance.InstanceId] = true
+		if *instance.InstanceId == instanceID || aws.BoolValue(instance.ProtectedFromScaleIn) {
+			continue
+		}
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			peers = append(peers, *instance.InstanceId)
+		}
+	}
+	if !existing[instanceID] {
+		return "", fmt.Errorf("instance %s is not a member of %s", instanceID, groupName)
+	}
+
+	// The peers are unprotected even if protecting them fails part way,
+	// since the batches before the failure have already been protected.
+	// None of them were protected to begin with.
+	defer func() {
+		progress(ReplaceStepUnprotectingPeers, "")
+		if err := s.setInstanceProtection(groupName, peers, false); err != nil {
+			log.Printf("ERROR: SetInstanceProtection: %s", err)
+		}
+	}()
+	progress(ReplaceStepProtectingPeers, "")
+	if err := s.setInstanceProtection(groupName, peers, true); err != nil {
+		return "", err
+	}
+
+	progress(ReplaceStepRemovingInstance, "")
+	autoscalingSvc := s.autoscalingClient()
+	if opts.Detach {
+		_, err = autoscalingSvc.DetachInstances(&autoscaling.DetachInstancesInput{
+			AutoScalingGroupName:           aws.String(groupName),
+			InstanceIds:                    []*string{aws.String(instanceID)},
+			ShouldDecrementDesiredCapacity: aws.Bool(opts.ShouldDecrementDesiredCapacity),
+		})
+		if err == nil {
+			ec2svc := s.ec2Client()
+			_, err = ec2svc.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{aws.String(instanceID)},
+			})
+		}
+	} else {
+		_, err = autoscalingSvc.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     aws.String(instanceID),
+			ShouldDecrementDesiredCapacity: aws.Bool(opts.ShouldDecrementDesiredCapacity),
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	if opts.ShouldDecrementDesiredCapacity {
+		return "", nil
+	}
+
+	progress(ReplaceStepWaitingForReplacement, "")
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 20 * time.Minute
+	}
+	pollInterval := opts.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 15 * time.Second
+	}
+	replacementID := ""
+	err = waitUntil(timeout, pollInterval, func() (bool, error) {
+		group, err := s.describeAutoscalingGroup(groupName)
+		if err != nil {
+			return false, err
+		}
+		for _, instance := range group.Instances {
+			if existing[*instance.InstanceId] {
+				continue
+			}
+			if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+				replacementID = *instance.InstanceId
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	progress(ReplaceStepReplacementInService, replacementID)
+	return replacementID, nil
+}
+
+// setInstanceProtection sets or clears scale in protection for each of the
+// instances, in batches no larger than the API allows.
+func (s *Cluster) setInstanceProtection(groupName string, instanceIDs []string, protected bool) error {
+	autoscalingSvc := s.autoscalingClient()
+	for start := 0; start < len(instanceIDs); start += instanceProtectionBatchSize {
+		end := start + instanceProtectionBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		_, err := autoscalingSvc.SetInstanceProtection(&autoscaling.SetInstanceProtectionInput{
+			AutoScalingGroupName: aws.String(groupName),
+			InstanceIds:          aws.StringSlice(instanceIDs[start:end]),
+			ProtectedFromScaleIn: aws.Bool(protected),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
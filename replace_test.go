@@ -0,0 +1,117 @@
+package ec2cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	. "gopkg.in/check.v1"
+)
+
+type ReplaceTest struct {
+}
+
+var _ = Suite(&ReplaceTest{})
+
+func (s *ReplaceTest) TestReplaceInstance(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		Instances: []*autoscaling.Instance{
+			groupInstance("i-00000001", "us-east-1a", false),
+			groupInstance("i-00000002", "us-east-1a", false),
+			groupInstance("i-00000003", "us-east-1b", true),
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	replaceInZone(autoscalingSvc, group, "us-east-1a")
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	steps := []string{}
+	replacementID, err := cluster.ReplaceInstance("i-00000001", ReplaceOptions{
+		PollInterval: time.Millisecond,
+		Progress: func(p ReplaceProgress) {
+			steps = append(steps, p.Step+" "+p.ReplacementID)
+			if p.Step == ReplaceStepRemovingInstance {
+				// The peer is protected while the instance is replaced.
+				c.Assert(*group.Instances[1].ProtectedFromScaleIn, Equals, true)
+			}
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(replacementID, Equals, "i-r00000001")
+	c.Assert(autoscalingSvc.removed, DeepEquals, []string{"i-00000001"})
+	c.Assert(steps, DeepEquals, []string{
+		ReplaceStepProtectingPeers + " ",
+		ReplaceStepRemovingInstance + " ",
+		ReplaceStepWaitingForReplacement + " ",
+		ReplaceStepReplacementInService + " i-r00000001",
+		ReplaceStepUnprotectingPeers + " ",
+	})
+
+	// Only the peer that ReplaceInstance protected is unprotected.
+	protected := map[string]bool{}
+	for _, instance := range group.Instances {
+		protected[*instance.InstanceId] = *instance.ProtectedFromScaleIn
+	}
+	c.Assert(protected, DeepEquals, map[string]bool{
+		"i-00000002":  false,
+		"i-00000003":  true,
+		"i-r00000001": false,
+	})
+}
+
+func (s *ReplaceTest) TestReplaceInstanceDecrement(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		Instances: []*autoscaling.Instance{
+			groupInstance("i-00000001", "us-east-1a", false),
+			groupInstance("i-00000002", "us-east-1a", false),
+		},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	ec2Svc := &fakeEC2{}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: ec2Svc}
+
+	replacementID, err := cluster.ReplaceInstance("i-00000001", ReplaceOptions{Detach: true, ShouldDecrementDesiredCapacity: true})
+	c.Assert(err, IsNil)
+	c.Assert(replacementID, Equals, "")
+	c.Assert(autoscalingSvc.removed, DeepEquals, []string{"i-00000001"})
+	c.Assert(ec2Svc.terminated, DeepEquals, []string{"i-00000001"})
+	c.Assert(*group.Instances[1].ProtectedFromScaleIn, Equals, false)
+}
+
+func (s *ReplaceTest) TestReplaceInstanceNotMember(c *C) {
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("example"),
+		Instances:            []*autoscaling.Instance{groupInstance("i-00000001", "us-east-1a", false)},
+	}
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	_, err := cluster.ReplaceInstance("i-00000002", ReplaceOptions{})
+	c.Assert(err, ErrorMatches, "instance i-00000002 is not a member of example")
+	c.Assert(autoscalingSvc.removed, HasLen, 0)
+}
+
+func (s *ReplaceTest) TestReplaceInstanceProtectionFailure(c *C) {
+	// There are more peers than fit in one SetInstanceProtection call, and
+	// the second batch fails.
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String("example")}
+	for i := 0; i <= instanceProtectionBatchSize+1; i++ {
+		group.Instances = append(group.Instances, groupInstance(fmt.Sprintf("i-%08d", i), "us-east-1a", false))
+	}
+	protectErr := errors.New("throttled")
+	autoscalingSvc := &fakeAutoScaling{groups: []*autoscaling.Group{group}, protectionErrs: []error{nil, protectErr}}
+	cluster := &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc, EC2: &fakeEC2{}}
+
+	_, err := cluster.ReplaceInstance("i-00000000", ReplaceOptions{})
+	c.Assert(err, Equals, protectErr)
+	c.Assert(autoscalingSvc.removed, HasLen, 0)
+
+	// The peers of the first batch are unprotected again.
+	for _, instance := range group.Instances {
+		c.Assert(*instance.ProtectedFromScaleIn, Equals, false, Commentf("%s", *instance.InstanceId))
+	}
+}
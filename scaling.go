@@ -0,0 +1,154 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// Autoscaling processes that can be suspended with SuspendProcesses.
+const (
+	ProcessLaunch            = "Launch"
+	ProcessTerminate         = "Terminate"
+	ProcessAddToLoadBalancer = "AddToLoadBalancer"
+	ProcessAlarmNotification = "AlarmNotification"
+	ProcessAZRebalance       = "AZRebalance"
+	ProcessHealthCheck       = "HealthCheck"
+	ProcessInstanceRefresh   = "InstanceRefresh"
+	ProcessReplaceUnhealthy  = "ReplaceUnhealthy"
+	ProcessScheduledActions  = "ScheduledActions"
+)
+
+// ScalingActivity describes a scaling activity of an autoscaling group, such
+// as launching or terminating an instance.
+type ScalingActivity struct {
+	ActivityID    string
+	Description   string
+	Cause         string
+	StatusCode    string
+	StatusMessage string
+	Progress      int64
+	StartTime     time.Time
+	EndTime       time.Time
+}
+
+// autoscalingGroupName returns the name of the autoscaling group of the
+// current instance.
+func (s *Cluster) autoscalingGroupName() (string, error) {
+	asg, err := s.AutoscalingGroup()
+	if err != nil {
+		return "", err
+	}
+	if asg == nil {
+		return "", ErrNotInAutoscalingGroup
+	}
+	return *asg.AutoScalingGroupName, nil
+}
+
+// SetDesiredCapacity sets the desired capacity of the autoscaling group. If
+// honorCooldown is true, the request fails if the group is in its cooldown
+// period.
+func (s *Cluster) SetDesiredCapacity(desiredCapacity int64, honorCooldown bool) error {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return err
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	_, err = autoscalingSvc.SetDesiredCapacity(&autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(groupName),
+		DesiredCapacity:      aws.Int64(desiredCapacity),
+		HonorCooldown:        aws.Bool(honorCooldown),
+	})
+	if err != nil {
+		return err
+	}
+	s.autoScalingGroup = nil
+	return nil
+}
+
+// SuspendProcesses suspends the specified processes of the autoscaling group,
+// for example ProcessTerminate to pause scale in during a maintenance window.
+// If no processes are specified, all processes are suspended.
+func (s *Cluster) SuspendProcesses(processes ...string) error {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return err
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	_, err = autoscalingSvc.SuspendProcesses(scalingProcessQuery(groupName, processes))
+	if err != nil {
+		return err
+	}
+	s.autoScalingGroup = nil
+	return nil
+}
+
+// ResumeProcesses resumes the specified suspended processes of the
+// autoscaling group. If no processes are specified, all processes are
+// resumed.
+func (s *Cluster) ResumeProcesses(processes ...string) error {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return err
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	_, err = autoscalingSvc.ResumeProcesses(scalingProcessQuery(groupName, processes))
+	if err != nil {
+		return err
+	}
+	s.autoScalingGroup = nil
+	return nil
+}
+
+// scalingProcessQuery returns the query for processes of the group. With no
+// processes, ScalingProcesses is left out rather than sent as an empty list,
+// since only a missing list means every process.
+func scalingProcessQuery(groupName string, processes []string) *autoscaling.ScalingProcessQuery {
+	query := &autoscaling.ScalingProcessQuery{AutoScalingGroupName: aws.String(groupName)}
+	if len(processes) > 0 {
+		query.ScalingProcesses = aws.StringSlice(processes)
+	}
+	return query
+}
+
+// ScalingActivities returns up to maxActivities of the most recent scaling
+// activities of the autoscaling group, newest first. If maxActivities is
+// zero or less, every activity that is still reported is returned.
+func (s *Cluster) ScalingActivities(maxActivities int) ([]ScalingActivity, error) {
+	groupName, err := s.autoscalingGroupName()
+	if err != nil {
+		return nil, err
+	}
+
+	autoscalingSvc := s.autoscalingClient()
+	rv := []ScalingActivity{}
+	full := func() bool { return maxActivities > 0 && len(rv) >= maxActivities }
+	err = autoscalingSvc.DescribeScalingActivitiesPages(&autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(groupName),
+	}, func(resp *autoscaling.DescribeScalingActivitiesOutput, lastPage bool) bool {
+		for _, activity := range resp.Activities {
+			if full() {
+				return false
+			}
+			rv = append(rv, ScalingActivity{
+				ActivityID:    aws.StringValue(activity.ActivityId),
+				Description:   aws.StringValue(activity.Description),
+				Cause:         aws.StringValue(activity.Cause),
+				StatusCode:    aws.StringValue(activity.StatusCode),
+				StatusMessage: aws.StringValue(activity.StatusMessage),
+				Progress:      aws.Int64Value(activity.Progress),
+				StartTime:     aws.TimeValue(activity.StartTime),
+				EndTime:       aws.TimeValue(activity.EndTime),
+			})
+		}
+		return !full()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
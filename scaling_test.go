@@ -0,0 +1,111 @@
+package ec2cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type ScalingTest struct {
+}
+
+var _ = Suite(&ScalingTest{})
+
+func scalingCluster(autoscalingSvc *fakeAutoScaling) *Cluster {
+	autoscalingSvc.groups = []*autoscaling.Group{{
+		AutoScalingGroupName: aws.String("example"),
+		DesiredCapacity:      aws.Int64(3),
+	}}
+	return &Cluster{AutoScalingGroupName: "example", AutoScaling: autoscalingSvc}
+}
+
+func (s *ScalingTest) TestSetDesiredCapacity(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := scalingCluster(autoscalingSvc)
+
+	group, err := cluster.AutoscalingGroup()
+	c.Assert(err, IsNil)
+	c.Assert(*group.DesiredCapacity, Equals, int64(3))
+
+	c.Assert(cluster.SetDesiredCapacity(5, false), IsNil)
+	c.Assert(autoscalingSvc.desiredCapacities, DeepEquals, []int64{5})
+
+	// The cached group is dropped so that it reflects the change.
+	c.Assert(cluster.autoScalingGroup, IsNil)
+}
+
+func (s *ScalingTest) TestSuspendAndResumeProcesses(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := scalingCluster(autoscalingSvc)
+
+	c.Assert(cluster.SuspendProcesses(ProcessTerminate, ProcessAZRebalance), IsNil)
+	c.Assert(autoscalingSvc.suspended, DeepEquals, []string{ProcessTerminate, ProcessAZRebalance})
+	c.Assert(cluster.autoScalingGroup, IsNil)
+
+	c.Assert(cluster.ResumeProcesses(ProcessTerminate), IsNil)
+	c.Assert(autoscalingSvc.suspended, DeepEquals, []string{ProcessAZRebalance})
+}
+
+func (s *ScalingTest) TestSuspendAndResumeAllProcesses(c *C) {
+	autoscalingSvc := &fakeAutoScaling{suspended: []string{ProcessTerminate}}
+	cluster := scalingCluster(autoscalingSvc)
+
+	// ScalingProcesses is left out, rather than sent as an empty list, so
+	// that every process is suspended or resumed.
+	c.Assert(cluster.SuspendProcesses(), IsNil)
+	c.Assert(cluster.ResumeProcesses(), IsNil)
+	c.Assert(autoscalingSvc.processQueries, HasLen, 2)
+	c.Assert(autoscalingSvc.processQueries[0].ScalingProcesses, IsNil)
+	c.Assert(autoscalingSvc.processQueries[1].ScalingProcesses, IsNil)
+	c.Assert(autoscalingSvc.suspended, HasLen, 0)
+}
+
+func (s *ScalingTest) TestNotInAutoscalingGroup(c *C) {
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()))
+	cluster := &Cluster{InstanceID: "i-00000001", EC2: ec2Svc}
+
+	c.Assert(cluster.SetDesiredCapacity(1, false), Equals, ErrNotInAutoscalingGroup)
+	c.Assert(cluster.SuspendProcesses(), Equals, ErrNotInAutoscalingGroup)
+	_, err := cluster.ScalingActivities(1)
+	c.Assert(err, Equals, ErrNotInAutoscalingGroup)
+}
+
+func (s *ScalingTest) TestScalingActivities(c *C) {
+	autoscalingSvc := &fakeAutoScaling{}
+	cluster := scalingCluster(autoscalingSvc)
+	for i := 5; i > 0; i-- {
+		autoscalingSvc.activities = append(autoscalingSvc.activities, &autoscaling.Activity{
+			ActivityId: aws.String(fmt.Sprintf("activity-%d", i)),
+			StatusCode: aws.String(autoscaling.ScalingActivityStatusCodeSuccessful),
+			Progress:   aws.Int64(100),
+		})
+	}
+
+	activityIDs := func(activities []ScalingActivity) []string {
+		rv := []string{}
+		for _, activity := range activities {
+			rv = append(rv, activity.ActivityID)
+		}
+		return rv
+	}
+
+	// Only the pages needed for the requested activities are read.
+	activities, err := cluster.ScalingActivities(3)
+	c.Assert(err, IsNil)
+	c.Assert(activityIDs(activities), DeepEquals, []string{"activity-5", "activity-4", "activity-3"})
+	c.Assert(activities[0].StatusCode, Equals, autoscaling.ScalingActivityStatusCodeSuccessful)
+	c.Assert(activities[0].Progress, Equals, int64(100))
+	c.Assert(autoscalingSvc.activityPages, Equals, 2)
+
+	// Without a limit, every activity is returned.
+	for _, maxActivities := range []int{0, -1} {
+		activities, err = cluster.ScalingActivities(maxActivities)
+		c.Assert(err, IsNil)
+		c.Assert(activityIDs(activities), DeepEquals, []string{"activity-5", "activity-4", "activity-3", "activity-2", "activity-1"})
+	}
+}
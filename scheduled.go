@@ -0,0 +1,138 @@
+package ec2cluster
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Transitions reported to a LifecyleEventCallback by WatchScheduledEvents,
+// one for each kind of EC2 scheduled event.
+const (
+	TransitionInstanceStop       = "ec2:" + ec2.EventCodeInstanceStop
+	TransitionInstanceRetirement = "ec2:" + ec2.EventCodeInstanceRetirement
+	TransitionInstanceReboot     = "ec2:" + ec2.EventCodeInstanceReboot
+	TransitionSystemReboot       = "ec2:" + ec2.EventCodeSystemReboot
+	TransitionSystemMaintenance  = "ec2:" + ec2.EventCodeSystemMaintenance
+)
+
+// describeInstanceStatusBatchSize is the largest number of instance IDs
+// accepted by a single DescribeInstanceStatus call.
+const describeInstanceStatusBatchSize = 100
+
+// IsScheduledEvent returns true if m was produced by WatchScheduledEvents
+// rather than by an autoscaling lifecycle hook.
+func IsScheduledEvent(m *LifecycleMessage) bool {
+	return strings.HasPrefix(m.LifecycleTransition, "ec2:")
+}
+
+// WatchScheduledEvents polls every `interval` for EC2 scheduled events, such
+// as instance retirement or system reboots, affecting members of the cluster
+// and invokes cb for each one. This lets services drain before AWS-initiated
+// maintenance using the same callback used with WatchLifecycleEvents.
+//
+// The message passed to cb has a LifecycleTransition of the form
+// `ec2:<event code>`, for example TransitionInstanceRetirement, a Time of
+// the start of the event window and a RequestID of the event ID. There is
+// no lifecycle action to complete, so shouldContinue is ignored. If cb
+// returns an error the event is delivered again on the next poll, otherwise
+// it is delivered once. Errors polling for events are logged and the poll
+// is retried after `interval`. WatchScheduledEvents runs until ctx is
+// cancelled.
+func (s *Cluster) WatchScheduledEvents(ctx context.Context, interval time.Duration, cb LifecyleEventCallback) error {
+	handled := map[string]bool{}
+	for {
+		messages, err := s.scheduledEvents()
+		if err != nil {
+			log.Printf("ERROR: cannot describe scheduled events: %s", err)
+		} else {
+			// Events that are no longer reported are forgotten, so that
+			// handled holds only the current events.
+			reported := map[string]bool{}
+			for _, m := range messages {
+				key := m.EC2InstanceID + "/" + m.RequestID
+				reported[key] = true
+				if handled[key] {
+					continue
+				}
+				if _, err := cb(m); err != nil {
+					continue
+				}
+				handled[key] = true
+			}
+			for key := range handled {
+				if !reported[key] {
+					delete(handled, key)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.jitter(interval)):
+		}
+	}
+}
+
+// scheduledEvents returns a message for each pending scheduled event
+// affecting a running member of the cluster.
+func (s *Cluster) scheduledEvents() ([]*LifecycleMessage, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+	groupNames := map[string]string{}
+	instanceIDs := []string{}
+	for _, member := range members {
+		if member.State == nil || aws.StringValue(member.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		instanceIDs = append(instanceIDs, *member.InstanceId)
+		for _, tag := range member.Tags {
+			if aws.StringValue(tag.Key) == autoscalingGroupNameTag {
+				groupNames[*member.InstanceId] = aws.StringValue(tag.Value)
+			}
+		}
+	}
+
+	ec2svc := s.ec2Client()
+	messages := []*LifecycleMessage{}
+	for start := 0; start < len(instanceIDs); start += describeInstanceStatusBatchSize {
+		end := start + describeInstanceStatusBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		err := ec2svc.DescribeInstanceStatusPages(&ec2.DescribeInstanceStatusInput{
+			InstanceIds: aws.StringSlice(instanceIDs[start:end]),
+		}, func(resp *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+			for _, status := range resp.InstanceStatuses {
+				for _, event := range status.Events {
+					// Events that are over keep being reported for a while,
+					// with a description that starts `[Completed]` or
+					// `[Canceled]`.
+					if strings.HasPrefix(aws.StringValue(event.Description), "[") {
+						continue
+					}
+					messages = append(messages, &LifecycleMessage{
+						AutoScalingGroupName: groupNames[*status.InstanceId],
+						Service:              "AWS EC2",
+						Time:                 aws.TimeValue(event.NotBefore),
+						LifecycleTransition:  "ec2:" + aws.StringValue(event.Code),
+						RequestID:            aws.StringValue(event.InstanceEventId),
+						EC2InstanceID:        *status.InstanceId,
+					})
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
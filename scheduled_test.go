@@ -0,0 +1,80 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type ScheduledTest struct {
+}
+
+var _ = Suite(&ScheduledTest{})
+
+func scheduledEvent(instanceEventID, code, description string) *ec2.InstanceStatusEvent {
+	return &ec2.InstanceStatusEvent{
+		InstanceEventId: aws.String(instanceEventID),
+		Code:            aws.String(code),
+		Description:     aws.String(description),
+		NotBefore:       aws.Time(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+func (s *ScheduledTest) TestWatchScheduledEvents(c *C) {
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(
+		fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()),
+		fakeInstance("i-00000002", ec2.InstanceStateNameStopped, time.Now()))
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: ec2Svc}
+
+	retirement := &ec2.InstanceStatus{
+		InstanceId: aws.String("i-00000001"),
+		Events: []*ec2.InstanceStatusEvent{
+			scheduledEvent("instance-event-1", ec2.EventCodeInstanceRetirement, "The instance is running on degraded hardware"),
+			scheduledEvent("instance-event-0", ec2.EventCodeSystemReboot, "[Completed] Scheduled reboot"),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	polls := 0
+	ec2Svc.onDescribeStatus = func() error {
+		polls++
+		switch polls {
+		case 1, 3, 4:
+			ec2Svc.instanceStatuses = []*ec2.InstanceStatus{retirement}
+		case 2:
+			return errors.New("RequestLimitExceeded")
+		case 5:
+			ec2Svc.instanceStatuses = nil
+		case 6:
+			ec2Svc.instanceStatuses = []*ec2.InstanceStatus{retirement}
+		default:
+			cancel()
+		}
+		return nil
+	}
+
+	delivered := []int{}
+	err := cluster.WatchScheduledEvents(ctx, time.Millisecond, func(m *LifecycleMessage) (bool, error) {
+		c.Assert(m.LifecycleTransition, Equals, TransitionInstanceRetirement)
+		c.Assert(m.EC2InstanceID, Equals, "i-00000001")
+		c.Assert(m.RequestID, Equals, "instance-event-1")
+		c.Assert(IsScheduledEvent(m), Equals, true)
+		delivered = append(delivered, polls)
+		if polls == 1 {
+			return false, errors.New("not ready")
+		}
+		return true, nil
+	})
+	c.Assert(err, Equals, context.Canceled)
+
+	// The failed delivery is retried after the failed poll, and the event is
+	// then not delivered again while it is reported. Once it is no longer
+	// reported it is forgotten, so it would be delivered again if it came
+	// back.
+	c.Assert(delivered, DeepEquals, []int{1, 3, 6})
+}
@@ -0,0 +1,204 @@
+package ec2cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Snapshot is a point-in-time view of the cluster that can be saved and
+// loaded again, so that a service can start with the last known members of
+// the cluster when the AWS API is briefly unavailable.
+type Snapshot struct {
+	// Generation is incremented each time a snapshot is taken in which the
+	// set of members differs from the previous snapshot.
+	Generation int64     `json:"generation"`
+	Time       time.Time `json:"time"`
+
+	// Leader is the instance ID of the leader, as returned by Leader, or
+	// empty if the cluster had no running members.
+	Leader string `json:"leader,omitempty"`
+
+	// Members are the members of the cluster, oldest first.
+	Members []SnapshotMember `json:"members"`
+}
+
+// SnapshotMember describes a cluster member in a Snapshot.
+type SnapshotMember struct {
+	InstanceID       string            `json:"instance_id"`
+	State            string            `json:"state"`
+	AvailabilityZone string            `json:"availability_zone,omitempty"`
+	PrivateIPAddress string            `json:"private_ip_address,omitempty"`
+	PublicIPAddress  string            `json:"public_ip_address,omitempty"`
+	IPv6Addresses    []string          `json:"ipv6_addresses,omitempty"`
+	DNSName          string            `json:"dns_name,omitempty"`
+	LaunchTime       time.Time         `json:"launch_time"`
+	Tags             map[string]string `json:"tags,omitempty"`
+
+	PlacementGroup        string `json:"placement_group,omitempty"`
+	PartitionNumber       int64  `json:"partition_number,omitempty"`
+	CapacityReservationID string `json:"capacity_reservation_id,omitempty"`
+}
+
+// Snapshot returns a snapshot of the current members of the cluster. The
+// generation continues from the previous snapshot taken by, or restored
+// with RestoreSnapshot into, this Cluster.
+func (s *Cluster) Snapshot() (*Snapshot, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Time:    time.Now(),
+		Members: []SnapshotMember{},
+	}
+	if running := runningInstances(members); len(running) > 0 {
+		snapshot.Leader = aws.StringValue(running[0].InstanceId)
+	}
+	for _, instance := range members {
+		snapshot.Members = append(snapshot.Members, newSnapshotMember(instance))
+	}
+
+	snapshot.Generation = 1
+	if s.snapshot != nil {
+		snapshot.Generation = s.snapshot.Generation
+		if !sameMembers(s.snapshot, snapshot) {
+			snapshot.Generation++
+		}
+	}
+	s.snapshot = snapshot
+	return snapshot, nil
+}
+
+// RestoreSnapshot makes snapshot the previous snapshot of the cluster, so
+// that the generation of snapshots taken afterwards continues from it.
+func (s *Cluster) RestoreSnapshot(snapshot *Snapshot) {
+	s.snapshot = snapshot
+}
+
+func newSnapshotMember(instance *ec2.Instance) SnapshotMember {
+	m := SnapshotMember{
+		InstanceID:       aws.StringValue(instance.InstanceId),
+		PrivateIPAddress: aws.StringValue(instance.PrivateIpAddress),
+		PublicIPAddress:  aws.StringValue(instance.PublicIpAddress),
+		DNSName:          DualStackDNSName(instance),
+		LaunchTime:       aws.TimeValue(instance.LaunchTime),
+	}
+	if ipv6Addresses := InstanceIPv6Addresses(instance); len(ipv6Addresses) > 0 {
+		m.IPv6Addresses = ipv6Addresses
+	}
+	if instance.State != nil {
+		m.State = aws.StringValue(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		m.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	placement := InstancePlacement(instance)
+	m.PlacementGroup = placement.PlacementGroup
+	m.PartitionNumber = placement.PartitionNumber
+	m.CapacityReservationID = placement.CapacityReservationID
+	if len(instance.Tags) > 0 {
+		m.Tags = map[string]string{}
+		for _, tag := range instance.Tags {
+			m.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return m
+}
+
+// sameMembers returns true if a and b have the same members in the same
+// states.
+func sameMembers(a, b *Snapshot) bool {
+	if len(a.Members) != len(b.Members) {
+		return false
+	}
+	for i := range a.Members {
+		if a.Members[i].InstanceID != b.Members[i].InstanceID || a.Members[i].State != b.Members[i].State {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes the snapshot to path as JSON. The file is replaced
+// atomically, so a reader never sees a partially written snapshot.
+func (snapshot *Snapshot) Save(path string) error {
+	buf, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalSnapshot(buf)
+}
+
+// SaveSnapshotS3 writes snapshot as JSON to key in bucket. S3 replaces an
+// object in a single write, so a reader never sees a partially written
+// snapshot.
+func (s *Cluster) SaveSnapshotS3(snapshot *Snapshot, bucket, key string) error {
+	buf, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.s3Client().PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf),
+		ContentType: aws.String("application/json"),
+	})
+	return wrapAPIError("PutObject", err)
+}
+
+// LoadSnapshotS3 reads a snapshot written by SaveSnapshotS3. If there is no
+// snapshot at key, the error satisfies IsNotFound.
+func (s *Cluster) LoadSnapshotS3(bucket, key string) (*Snapshot, error) {
+	resp, err := s.s3Client().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapAPIError("GetObject", err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalSnapshot(buf)
+}
+
+func unmarshalSnapshot(buf []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(buf, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,91 @@
+package ec2cluster
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type SnapshotTest struct {
+}
+
+var _ = Suite(&SnapshotTest{})
+
+func (s *SnapshotTest) TestSnapshot(c *C) {
+	now := time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC)
+	ec2Svc := &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now),
+			fakeInstance("i-00000001", ec2.InstanceStateNamePending, now.Add(-time.Hour)),
+		},
+	}
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: ec2Svc}
+
+	snapshot, err := cluster.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Generation, Equals, int64(1))
+	c.Assert(snapshot.Leader, Equals, "i-00000002")
+	c.Assert(snapshot.Members, HasLen, 2)
+	c.Assert(snapshot.Members[0].InstanceID, Equals, "i-00000001")
+	c.Assert(snapshot.Members[0].Tags, DeepEquals, map[string]string{"app": "example"})
+
+	snapshot, err = cluster.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Generation, Equals, int64(1))
+
+	ec2Svc.instances[1].State.Name = aws.String(ec2.InstanceStateNameRunning)
+	snapshot, err = cluster.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Generation, Equals, int64(2))
+	c.Assert(snapshot.Leader, Equals, "i-00000001")
+}
+
+func (s *SnapshotTest) TestSaveAndLoad(c *C) {
+	path := filepath.Join(c.MkDir(), "cluster.json")
+	snapshot := &Snapshot{
+		Generation: 7,
+		Time:       time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC),
+		Leader:     "i-00000001",
+		Members: []SnapshotMember{
+			{InstanceID: "i-00000001", State: "running", PrivateIPAddress: "10.0.0.1"},
+		},
+	}
+	c.Assert(snapshot.Save(path), IsNil)
+
+	loaded, err := LoadSnapshot(path)
+	c.Assert(err, IsNil)
+	c.Assert(loaded, DeepEquals, snapshot)
+
+	// The generation continues from a restored snapshot.
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: &fakeEC2{}}
+	cluster.RestoreSnapshot(loaded)
+	next, err := cluster.Snapshot()
+	c.Assert(err, IsNil)
+	c.Assert(next.Generation, Equals, int64(8))
+}
+
+func (s *SnapshotTest) TestSaveAndLoadS3(c *C) {
+	s3Svc := newFakeS3()
+	cluster := &Cluster{TagName: "app", TagValue: "example", EC2: &fakeEC2{}, S3: s3Svc}
+
+	_, err := cluster.LoadSnapshotS3("example-bucket", "cluster.json")
+	c.Assert(IsNotFound(err), Equals, true)
+
+	snapshot := &Snapshot{
+		Generation: 3,
+		Time:       time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC),
+		Leader:     "i-00000001",
+		Members: []SnapshotMember{
+			{InstanceID: "i-00000001", State: "running", PrivateIPAddress: "10.0.0.1"},
+		},
+	}
+	c.Assert(cluster.SaveSnapshotS3(snapshot, "example-bucket", "cluster.json"), IsNil)
+	c.Assert(s3Svc.objects["cluster.json"], Not(HasLen), 0)
+
+	loaded, err := cluster.LoadSnapshotS3("example-bucket", "cluster.json")
+	c.Assert(err, IsNil)
+	c.Assert(loaded, DeepEquals, snapshot)
+}
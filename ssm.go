@@ -0,0 +1,142 @@
+package ec2cluster
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// SSMCommandError is returned by SSMRunner.Run when the command does not
+// succeed on the instance.
+type SSMCommandError struct {
+	InstanceID string
+	CommandID  string
+
+	// Status is the final status of the command invocation, such as
+	// `Failed` or `TimedOut`.
+	Status string
+
+	// Output is the standard error of the command, truncated by SSM.
+	Output string
+}
+
+func (e *SSMCommandError) Error() string {
+	return fmt.Sprintf("command %s on %s: %s: %s", e.CommandID, e.InstanceID, e.Status, e.Output)
+}
+
+// SSMRunner runs an SSM document, such as `AWS-RunShellScript`, on each
+// terminating instance and waits for it to finish before the lifecycle
+// action is continued, for example to stop services and flush buffers. It
+// is useful when the lifecycle event watcher runs centrally rather than on
+// every instance. The instances must run the SSM agent.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents.
+type SSMRunner struct {
+	Cluster *Cluster
+
+	// DocumentName is the name of the SSM document to run.
+	DocumentName string
+
+	// Parameters are the parameters of the document, for example
+	// `{"commands": {"systemctl stop myapp"}}` for `AWS-RunShellScript`.
+	Parameters map[string][]string
+
+	// Timeout is how long to wait for the command to finish. When it
+	// elapses, the lifecycle action is continued anyway. If zero, five
+	// minutes is used.
+	Timeout time.Duration
+
+	// PollInterval is how often the status of the command is checked. If
+	// zero, five seconds is used.
+	PollInterval time.Duration
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that runs the document on
+// each terminating instance. If the command fails, the lifecycle action is
+// abandoned. Other events are continued immediately.
+func (r *SSMRunner) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	if m.LifecycleTransition != TransitionTerminating {
+		return true, nil
+	}
+	err := r.Run(m.EC2InstanceID)
+	if err == ErrTimeout {
+		log.Printf("timed out waiting for %s on %s, continuing", r.DocumentName, m.EC2InstanceID)
+		return true, nil
+	}
+	if commandErr, ok := err.(*SSMCommandError); ok {
+		log.Printf("ERROR: %s", commandErr)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run runs the document on the instance and waits for it to finish. It
+// returns an SSMCommandError if the command does not succeed, or ErrTimeout
+// if Timeout elapses first.
+func (r *SSMRunner) Run(instanceID string) error {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	pollInterval := r.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	// SSM does not accept a delivery timeout of less than 30 seconds.
+	timeoutSeconds := int64(timeout / time.Second)
+	if timeoutSeconds < 30 {
+		timeoutSeconds = 30
+	}
+
+	parameters := map[string][]*string{}
+	for name, values := range r.Parameters {
+		parameters[name] = aws.StringSlice(values)
+	}
+	ssmSvc := r.Cluster.ssmClient()
+	resp, err := ssmSvc.SendCommand(&ssm.SendCommandInput{
+		DocumentName:   aws.String(r.DocumentName),
+		InstanceIds:    []*string{aws.String(instanceID)},
+		Parameters:     parameters,
+		TimeoutSeconds: aws.Int64(timeoutSeconds),
+	})
+	if err != nil {
+		return err
+	}
+	commandID := aws.StringValue(resp.Command.CommandId)
+
+	return waitUntil(timeout, pollInterval, func() (bool, error) {
+		invocation, err := ssmSvc.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(commandID),
+			InstanceId: aws.String(instanceID),
+		})
+		if err != nil {
+			// The invocation is not visible for a moment after the
+			// command is sent.
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ssm.ErrCodeInvocationDoesNotExist {
+				return false, nil
+			}
+			return false, err
+		}
+
+		switch status := aws.StringValue(invocation.Status); status {
+		case ssm.CommandInvocationStatusSuccess:
+			return true, nil
+		case ssm.CommandInvocationStatusCancelled, ssm.CommandInvocationStatusTimedOut, ssm.CommandInvocationStatusFailed:
+			return false, &SSMCommandError{
+				InstanceID: instanceID,
+				CommandID:  commandID,
+				Status:     status,
+				Output:     aws.StringValue(invocation.StandardErrorContent),
+			}
+		}
+		return false, nil
+	})
+}
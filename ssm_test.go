@@ -0,0 +1,53 @@
+package ec2cluster
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	. "gopkg.in/check.v1"
+)
+
+type SSMTest struct {
+}
+
+var _ = Suite(&SSMTest{})
+
+func (s *SSMTest) TestRun(c *C) {
+	ssmSvc := &fakeSSM{statuses: []string{ssm.CommandInvocationStatusInProgress, ssm.CommandInvocationStatusSuccess}}
+	r := &SSMRunner{
+		Cluster:      &Cluster{SSM: ssmSvc},
+		DocumentName: "AWS-RunShellScript",
+		Parameters:   map[string][]string{"commands": {"systemctl stop myapp"}},
+		PollInterval: time.Millisecond,
+	}
+
+	shouldContinue, err := r.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+	c.Assert(ssmSvc.sent, HasLen, 1)
+	c.Assert(*ssmSvc.sent[0].InstanceIds[0], Equals, "i-00000001")
+	c.Assert(*ssmSvc.sent[0].Parameters["commands"][0], Equals, "systemctl stop myapp")
+}
+
+func (s *SSMTest) TestRunFails(c *C) {
+	ssmSvc := &fakeSSM{statuses: []string{ssm.CommandInvocationStatusFailed}}
+	r := &SSMRunner{Cluster: &Cluster{SSM: ssmSvc}, DocumentName: "AWS-RunShellScript", PollInterval: time.Millisecond}
+
+	err := r.Run("i-00000001")
+	commandErr, ok := err.(*SSMCommandError)
+	c.Assert(ok, Equals, true)
+	c.Assert(commandErr.Status, Equals, ssm.CommandInvocationStatusFailed)
+	c.Assert(commandErr.Output, Equals, "exit status 1")
+
+	// A failed command abandons the lifecycle action.
+	ssmSvc.polled = false
+	shouldContinue, err := r.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, false)
+}
@@ -0,0 +1,210 @@
+package ec2cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrStateNotFound is returned by StateStore.Get when the key does not
+// exist.
+var ErrStateNotFound = errors.New("state not found")
+
+// ErrStateConflict is returned by StateStore.Put when the key was changed
+// by another writer since it was read.
+var ErrStateConflict = errors.New("state was changed concurrently")
+
+// joinInfoPrefix is the prefix, within a StateStore, of the join
+// information published by each member.
+const joinInfoPrefix = "members/"
+
+// StateStore stores state shared by the members of the cluster, such as
+// bootstrap flags, join tokens or leader records, as objects in an S3
+// bucket. Writes are conditional, so it can be used for coordination by
+// clusters that cannot use DynamoDB.
+type StateStore struct {
+	Cluster *Cluster
+
+	// Bucket is the name of the S3 bucket.
+	Bucket string
+
+	// Prefix is prepended to every key. If empty, `ec2cluster/<tag value>/`
+	// is used, so that several clusters can share a bucket.
+	Prefix string
+}
+
+func (st *StateStore) key(key string) (string, error) {
+	if st.Prefix != "" {
+		return st.Prefix + key, nil
+	}
+	tagValue, err := st.Cluster.tagValue()
+	if err != nil {
+		return "", err
+	}
+	return "ec2cluster/" + tagValue + "/" + key, nil
+}
+
+// Get returns the value of key and its version, which is passed to Put to
+// update the value only if it has not changed since.
+func (st *StateStore) Get(key string) (value []byte, version string, err error) {
+	objectKey, err := st.key(key)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := st.Cluster.s3Client().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, "", ErrStateNotFound
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	value, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return value, aws.StringValue(resp.ETag), nil
+}
+
+// Put sets the value of key if its current version is version, returning
+// the new version. If version is empty, the value is set only if key does
+// not exist yet. If another writer has changed the value, Put returns
+// ErrStateConflict.
+func (st *StateStore) Put(key string, value []byte, version string) (string, error) {
+	objectKey, err := st.key(key)
+	if err != nil {
+		return "", err
+	}
+	condition := map[string]string{"If-None-Match": "*"}
+	if version != "" {
+		condition = map[string]string{"If-Match": version}
+	}
+	resp, err := st.Cluster.s3Client().PutObjectWithContext(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(value),
+	}, request.WithSetRequestHeaders(condition))
+	if err != nil {
+		if isConditionFailed(err) {
+			return "", ErrStateConflict
+		}
+		return "", err
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+// Delete removes key.
+func (st *StateStore) Delete(key string) error {
+	objectKey, err := st.key(key)
+	if err != nil {
+		return err
+	}
+	_, err = st.Cluster.s3Client().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
+// PublishJoinInfo stores info, for example the address and port that peers
+// should use to join, for the current instance. Call it once the instance
+// has launched; use HandleLifecycleEvent to remove it on termination.
+func (st *StateStore) PublishJoinInfo(info []byte) error {
+	return st.write(joinInfoPrefix+st.Cluster.InstanceID, info)
+}
+
+// write sets the value of key unconditionally, for values that have a
+// single writer.
+func (st *StateStore) write(key string, value []byte) error {
+	objectKey, err := st.key(key)
+	if err != nil {
+		return err
+	}
+	_, err = st.Cluster.s3Client().PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+// JoinInfo returns the join information published by each member, by
+// instance ID.
+func (st *StateStore) JoinInfo() (map[string][]byte, error) {
+	keys, err := st.list(joinInfoPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := map[string][]byte{}
+	for _, key := range keys {
+		instanceID := strings.TrimPrefix(key, joinInfoPrefix)
+		info, _, err := st.Get(key)
+		if err == ErrStateNotFound {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		rv[instanceID] = info
+	}
+	return rv, nil
+}
+
+// list returns the keys that start with prefix, in lexical order.
+func (st *StateStore) list(prefix string) ([]string, error) {
+	objectPrefix, err := st.key(prefix)
+	if err != nil {
+		return nil, err
+	}
+	storePrefix := strings.TrimSuffix(objectPrefix, prefix)
+
+	keys := []string{}
+	err = st.Cluster.s3Client().ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(st.Bucket),
+		Prefix: aws.String(objectPrefix),
+	}, func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range resp.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(object.Key), storePrefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that removes the join
+// information of each terminating instance.
+func (st *StateStore) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	if m.LifecycleTransition != TransitionTerminating {
+		return true, nil
+	}
+	if err := st.Delete(joinInfoPrefix + m.EC2InstanceID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isConditionFailed returns true if err is the error S3 returns when the
+// condition of a conditional write is not met.
+func isConditionFailed(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	return false
+}
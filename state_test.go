@@ -0,0 +1,70 @@
+package ec2cluster
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type StateTest struct {
+}
+
+var _ = Suite(&StateTest{})
+
+func (s *StateTest) TestConditionalPut(c *C) {
+	s3Svc := newFakeS3()
+	store := &StateStore{
+		Cluster: &Cluster{TagName: "app", TagValue: "example", S3: s3Svc},
+		Bucket:  "bucket",
+	}
+
+	_, _, err := store.Get("leader")
+	c.Assert(err, Equals, ErrStateNotFound)
+
+	version, err := store.Put("leader", []byte("i-00000001"), "")
+	c.Assert(err, IsNil)
+	c.Assert(s3Svc.objects["ec2cluster/example/leader"], DeepEquals, []byte("i-00000001"))
+
+	_, err = store.Put("leader", []byte("i-00000002"), "")
+	c.Assert(err, Equals, ErrStateConflict)
+
+	value, readVersion, err := store.Get("leader")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "i-00000001")
+	c.Assert(readVersion, Equals, version)
+
+	_, err = store.Put("leader", []byte("i-00000002"), readVersion)
+	c.Assert(err, IsNil)
+	_, err = store.Put("leader", []byte("i-00000003"), readVersion)
+	c.Assert(err, Equals, ErrStateConflict)
+}
+
+func (s *StateTest) TestJoinInfo(c *C) {
+	s3Svc := newFakeS3()
+	store := &StateStore{
+		Cluster: &Cluster{InstanceID: "i-00000001", S3: s3Svc},
+		Bucket:  "bucket",
+		Prefix:  "example/",
+	}
+	c.Assert(store.PublishJoinInfo([]byte("10.0.0.1:7946")), IsNil)
+	store.Cluster.InstanceID = "i-00000002"
+	c.Assert(store.PublishJoinInfo([]byte("10.0.0.2:7946")), IsNil)
+
+	info, err := store.JoinInfo()
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, map[string][]byte{
+		"i-00000001": []byte("10.0.0.1:7946"),
+		"i-00000002": []byte("10.0.0.2:7946"),
+	})
+
+	shouldContinue, err := store.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+
+	info, err = store.JoinInfo()
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, map[string][]byte{
+		"i-00000002": []byte("10.0.0.2:7946"),
+	})
+}
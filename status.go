@@ -0,0 +1,191 @@
+package ec2cluster
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatcherStatus describes the lifecycle events handled by a callback
+// wrapped with StatusHandler.Watch.
+type WatcherStatus struct {
+	// InProgress is the number of events whose callback is running.
+	InProgress int `json:"in_progress"`
+
+	// Continued, Abandoned and Failed count the events whose callback
+	// returned true, returned false, or returned an error.
+	Continued int64 `json:"continued"`
+	Abandoned int64 `json:"abandoned"`
+	Failed    int64 `json:"failed"`
+
+	// LastEvent is the time the last event was received, and LastError
+	// the error returned by the callback for the last failed event.
+	LastEvent time.Time `json:"last_event,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// StatusHandler is an http.Handler that serves this instance's view of the
+// cluster as JSON, for operators and load balancers. It serves:
+//
+//   - /cluster/members, a Snapshot of the members of the cluster.
+//   - /cluster/leader, the leader and whether it is the current instance.
+//   - /cluster/health, the result of Checks and the WatcherStatus. The
+//     status is 503 if any check fails.
+//   - /cluster/watcher, the WatcherStatus.
+//
+// Mount it with `http.Handle("/cluster/", handler)`. To report the status
+// of a lifecycle event watcher, wrap its callback with Watch.
+type StatusHandler struct {
+	Cluster *Cluster
+
+	// Checks are the health checks run for /cluster/health.
+	Checks []HealthCheck
+
+	// RefreshInterval is how long the members of the cluster are cached
+	// between requests, so that frequent health checks do not exhaust the
+	// EC2 API limits. If zero, 30 seconds is used.
+	RefreshInterval time.Duration
+
+	// snapshotMu guards snapshot. It is separate from mu so that Watch is
+	// not blocked while the members are described.
+	snapshotMu sync.Mutex
+	snapshot   *Snapshot
+
+	mu      sync.Mutex
+	watcher WatcherStatus
+}
+
+// Watch returns a LifecycleEventContextCallback that invokes cb and records
+// the result in the WatcherStatus.
+func (h *StatusHandler) Watch(cb LifecycleEventContextCallback) LifecycleEventContextCallback {
+	return func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		h.mu.Lock()
+		h.watcher.InProgress++
+		h.watcher.LastEvent = time.Now()
+		h.mu.Unlock()
+
+		shouldContinue, err := cb(ctx, m)
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.watcher.InProgress--
+		switch {
+		case err != nil:
+			h.watcher.Failed++
+			h.watcher.LastError = err.Error()
+		case shouldContinue:
+			h.watcher.Continued++
+		default:
+			h.watcher.Abandoned++
+		}
+		return shouldContinue, err
+	}
+}
+
+// WatcherStatus returns the status of the callbacks wrapped with Watch.
+func (h *StatusHandler) WatcherStatus() WatcherStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.watcher
+}
+
+// clusterSnapshot returns a snapshot of the cluster, taking a new one if
+// the cached snapshot is older than RefreshInterval.
+func (h *StatusHandler) clusterSnapshot() (*Snapshot, error) {
+	refreshInterval := h.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = 30 * time.Second
+	}
+
+	h.snapshotMu.Lock()
+	defer h.snapshotMu.Unlock()
+	if h.snapshot != nil && time.Since(h.snapshot.Time) < refreshInterval {
+		return h.snapshot, nil
+	}
+	snapshot, err := h.Cluster.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	h.snapshot = snapshot
+	return snapshot, nil
+}
+
+// leaderStatus is the response to /cluster/leader.
+type leaderStatus struct {
+	Leader     string `json:"leader"`
+	InstanceID string `json:"instance_id"`
+	IsLeader   bool   `json:"is_leader"`
+}
+
+// healthStatus is the response to /cluster/health.
+type healthStatus struct {
+	Healthy bool          `json:"healthy"`
+	Errors  []string      `json:"errors,omitempty"`
+	Watcher WatcherStatus `json:"watcher"`
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch strings.TrimPrefix(req.URL.Path, "/cluster") {
+	case "/members":
+		snapshot, err := h.clusterSnapshot()
+		if err != nil {
+			h.serveError(w, err)
+			return
+		}
+		h.serveJSON(w, http.StatusOK, snapshot)
+
+	case "/leader":
+		snapshot, err := h.clusterSnapshot()
+		if err != nil {
+			h.serveError(w, err)
+			return
+		}
+		h.serveJSON(w, http.StatusOK, leaderStatus{
+			Leader:     snapshot.Leader,
+			InstanceID: h.Cluster.InstanceID,
+			IsLeader:   snapshot.Leader != "" && snapshot.Leader == h.Cluster.InstanceID,
+		})
+
+	case "/health":
+		status := healthStatus{Healthy: true, Watcher: h.WatcherStatus()}
+		for _, check := range h.Checks {
+			if err := check(); err != nil {
+				status.Healthy = false
+				status.Errors = append(status.Errors, err.Error())
+			}
+		}
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		h.serveJSON(w, code, status)
+
+	case "/watcher":
+		h.serveJSON(w, http.StatusOK, h.WatcherStatus())
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (h *StatusHandler) serveError(w http.ResponseWriter, err error) {
+	log.Printf("ERROR: %s", err)
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+func (h *StatusHandler) serveJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: %s", err)
+	}
+}
@@ -0,0 +1,91 @@
+package ec2cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type StatusTest struct {
+}
+
+var _ = Suite(&StatusTest{})
+
+// getJSON requests path from h and decodes the JSON response into v.
+func getJSON(c *C, h http.Handler, path string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if v != nil && rec.Code != http.StatusNotFound {
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), v), IsNil)
+	}
+	return rec.Code
+}
+
+func (s *StatusTest) TestMembersAndLeader(c *C) {
+	now := time.Date(2016, 2, 26, 21, 9, 59, 0, time.UTC)
+	ec2Svc := &fakeEC2{
+		instances: []*ec2.Instance{
+			fakeInstance("i-00000001", ec2.InstanceStateNameRunning, now.Add(-time.Hour)),
+			fakeInstance("i-00000002", ec2.InstanceStateNameRunning, now),
+		},
+	}
+	h := &StatusHandler{Cluster: &Cluster{InstanceID: "i-00000002", TagName: "app", TagValue: "example", EC2: ec2Svc}}
+
+	snapshot := Snapshot{}
+	c.Assert(getJSON(c, h, "/cluster/members", &snapshot), Equals, http.StatusOK)
+	c.Assert(snapshot.Members, HasLen, 2)
+
+	leader := leaderStatus{}
+	c.Assert(getJSON(c, h, "/cluster/leader", &leader), Equals, http.StatusOK)
+	c.Assert(leader, DeepEquals, leaderStatus{Leader: "i-00000001", InstanceID: "i-00000002", IsLeader: false})
+
+	// The members are cached for RefreshInterval.
+	ec2Svc.instances = ec2Svc.instances[1:]
+	c.Assert(getJSON(c, h, "/cluster/members", &snapshot), Equals, http.StatusOK)
+	c.Assert(snapshot.Members, HasLen, 2)
+
+	c.Assert(getJSON(c, h, "/cluster/unknown", nil), Equals, http.StatusNotFound)
+}
+
+func (s *StatusTest) TestHealthAndWatcher(c *C) {
+	var checkErr error
+	h := &StatusHandler{Cluster: &Cluster{}, Checks: []HealthCheck{func() error { return checkErr }}}
+
+	cb := h.Watch(func(ctx context.Context, m *LifecycleMessage) (bool, error) {
+		switch m.EC2InstanceID {
+		case "i-00000001":
+			return true, nil
+		case "i-00000002":
+			return false, nil
+		}
+		return false, errors.New("cannot drain")
+	})
+	for _, instanceID := range []string{"i-00000001", "i-00000002", "i-00000003"} {
+		cb(context.Background(), &LifecycleMessage{EC2InstanceID: instanceID})
+	}
+
+	health := healthStatus{}
+	c.Assert(getJSON(c, h, "/cluster/health", &health), Equals, http.StatusOK)
+	c.Assert(health.Healthy, Equals, true)
+	c.Assert(health.Watcher.Continued, Equals, int64(1))
+	c.Assert(health.Watcher.Abandoned, Equals, int64(1))
+	c.Assert(health.Watcher.Failed, Equals, int64(1))
+	c.Assert(health.Watcher.LastError, Equals, "cannot drain")
+
+	checkErr = errors.New("disk full")
+	health = healthStatus{}
+	c.Assert(getJSON(c, h, "/cluster/health", &health), Equals, http.StatusServiceUnavailable)
+	c.Assert(health.Healthy, Equals, false)
+	c.Assert(health.Errors, DeepEquals, []string{"disk full"})
+
+	watcher := WatcherStatus{}
+	c.Assert(getJSON(c, h, "/cluster/watcher", &watcher), Equals, http.StatusOK)
+	c.Assert(watcher.InProgress, Equals, 0)
+	c.Assert(watcher.Failed, Equals, int64(1))
+}
@@ -0,0 +1,162 @@
+package ec2cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// tagBatchSize is the number of instances passed to a single CreateTags
+// call when tagging many instances at once.
+const tagBatchSize = 100
+
+// tagBatchInterval is the pause between consecutive CreateTags batches,
+// which keeps large clusters from exhausting the EC2 request rate limit.
+var tagBatchInterval = 200 * time.Millisecond
+
+// SetTag sets the tag `key` to `value` on the current instance. Other
+// members of the cluster can discover the value with GetTag or
+// ListClusterTags.
+func (s *Cluster) SetTag(key, value string) error {
+	return s.SetTags([]string{s.InstanceID}, map[string]string{key: value})
+}
+
+// SetTags applies tags to each of the specified instances. The instances are
+// tagged in batches to stay within the EC2 API rate limits.
+func (s *Cluster) SetTags(instanceIDs []string, tags map[string]string) error {
+	ec2Tags := []*ec2.Tag{}
+	for key, value := range tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	ec2svc := s.ec2Client()
+	for start := 0; start < len(instanceIDs); start += tagBatchSize {
+		if start > 0 {
+			time.Sleep(tagBatchInterval)
+		}
+		end := start + tagBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		_, err := ec2svc.CreateTags(&ec2.CreateTagsInput{
+			Resources: aws.StringSlice(instanceIDs[start:end]),
+			Tags:      ec2Tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the cached instance no longer reflects our tags
+	for _, instanceID := range instanceIDs {
+		if instanceID == s.InstanceID {
+			s.instance = nil
+		}
+	}
+	return nil
+}
+
+// GetTag returns the value of the tag `key` on the specified instance. If the
+// instance does not have the tag, returns an empty string and false.
+func (s *Cluster) GetTag(instanceID, key string) (string, bool, error) {
+	ec2svc := s.ec2Client()
+	resp, err := ec2svc.DescribeTags(&ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("resource-id"),
+				Values: []*string{aws.String(instanceID)},
+			},
+			&ec2.Filter{
+				Name:   aws.String("key"),
+				Values: []*string{aws.String(key)},
+			},
+		},
+	})
+	if err != nil {
+		return "", false, err
+	}
+	for _, tag := range resp.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// ListClusterTags returns a map from instance ID to the value of the tag
+// `key` for each member of the cluster that has the tag.
+func (s *Cluster) ListClusterTags(key string) (map[string]string, error) {
+	members, err := s.Members()
+	if err != nil {
+		return nil, err
+	}
+
+	rv := map[string]string{}
+	for _, member := range members {
+		for _, tag := range member.Tags {
+			if aws.StringValue(tag.Key) == key {
+				rv[aws.StringValue(member.InstanceId)] = aws.StringValue(tag.Value)
+			}
+		}
+	}
+	return rv, nil
+}
+
+// TagsCallback is a function that is invoked by WatchTags with a map from
+// instance ID to tag value. If the function returns an error, watching
+// stops and the error is returned from WatchTags.
+type TagsCallback func(tags map[string]string) error
+
+// WatchTags polls the tag `key` on each member of the cluster every
+// `interval` and invokes cb with the current values whenever they change.
+// The callback is always invoked for the first poll. WatchTags runs until
+// ctx is cancelled or an error occurs.
+func (s *Cluster) WatchTags(ctx context.Context, key string, interval time.Duration, cb TagsCallback) error {
+	var lastTags map[string]string
+	for {
+		tags, err := s.ListClusterTags(key)
+		if err != nil {
+			return err
+		}
+		if lastTags == nil || !reflect.DeepEqual(tags, lastTags) {
+			if err := cb(tags); err != nil {
+				return err
+			}
+			lastTags = tags
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.jitter(interval)):
+		}
+	}
+}
+
+// tagFilters returns EC2 filters that match resources having every tag
+// in selector. The filters are sorted by tag name so that requests are
+// deterministic.
+func tagFilters(selector map[string]string) []*ec2.Filter {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filters := []*ec2.Filter{}
+	for _, key := range keys {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String(fmt.Sprintf("tag:%s", key)),
+			Values: []*string{aws.String(selector[key])},
+		})
+	}
+	return filters
+}
@@ -0,0 +1,140 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type TagsTest struct {
+}
+
+var _ = Suite(&TagsTest{})
+
+// tagsCluster returns a cluster whose members are tagged app=example, the
+// first of which is the current instance.
+func tagsCluster(instances ...*ec2.Instance) (*Cluster, *fakeEC2) {
+	ec2Svc := &fakeEC2{}
+	ec2Svc.setInstances(instances...)
+	return &Cluster{InstanceID: *instances[0].InstanceId, TagName: "app", EC2: ec2Svc}, ec2Svc
+}
+
+func (s *TagsTest) TestSetTagAndGetTag(c *C) {
+	cluster, ec2Svc := tagsCluster(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()))
+	instance, err := cluster.Instance()
+	c.Assert(err, IsNil)
+	c.Assert(instance, NotNil)
+
+	c.Assert(cluster.SetTag("role", "leader"), IsNil)
+	c.Assert(ec2Svc.tagged, HasLen, 1)
+	c.Assert(aws.StringValueSlice(ec2Svc.tagged[0].Resources), DeepEquals, []string{"i-00000001"})
+
+	// The cached instance is dropped so that it reflects the new tag.
+	c.Assert(cluster.instance, IsNil)
+
+	value, ok, err := cluster.GetTag("i-00000001", "role")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, "leader")
+
+	_, ok, err = cluster.GetTag("i-00000001", "missing")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	_, ok, err = cluster.GetTag("i-00000002", "role")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *TagsTest) TestSetTagsBatches(c *C) {
+	defer func(interval time.Duration) { tagBatchInterval = interval }(tagBatchInterval)
+	tagBatchInterval = 0
+
+	instances := []*ec2.Instance{}
+	instanceIDs := []string{}
+	for i := 0; i <= tagBatchSize; i++ {
+		instanceID := fmt.Sprintf("i-%08d", i)
+		instances = append(instances, fakeInstance(instanceID, ec2.InstanceStateNameRunning, time.Now()))
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	cluster, ec2Svc := tagsCluster(instances...)
+
+	c.Assert(cluster.SetTags(instanceIDs, map[string]string{"role": "worker"}), IsNil)
+	c.Assert(ec2Svc.tagged, HasLen, 2)
+	c.Assert(ec2Svc.tagged[0].Resources, HasLen, tagBatchSize)
+	c.Assert(aws.StringValueSlice(ec2Svc.tagged[1].Resources), DeepEquals, []string{instanceIDs[tagBatchSize]})
+}
+
+func (s *TagsTest) TestListClusterTags(c *C) {
+	untagged := fakeInstance("i-00000003", ec2.InstanceStateNameRunning, time.Now())
+	cluster, ec2Svc := tagsCluster(
+		fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()),
+		fakeInstance("i-00000002", ec2.InstanceStateNameRunning, time.Now()),
+		untagged)
+	c.Assert(cluster.SetTags([]string{"i-00000001", "i-00000002"}, map[string]string{"role": "worker"}), IsNil)
+
+	tags, err := cluster.ListClusterTags("role")
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"i-00000001": "worker", "i-00000002": "worker"})
+
+	// The members are found by the cluster tag of the current instance.
+	c.Assert(ec2Svc.describeFilters, HasLen, 1)
+	c.Assert(ec2Svc.describeFilters[0], DeepEquals, []*ec2.Filter{{
+		Name:   aws.String("tag:app"),
+		Values: aws.StringSlice([]string{"example"}),
+	}})
+}
+
+func (s *TagsTest) TestWatchTags(c *C) {
+	cluster, ec2Svc := tagsCluster(
+		fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()),
+		fakeInstance("i-00000002", ec2.InstanceStateNameRunning, time.Now()))
+	c.Assert(cluster.SetTags([]string{"i-00000001", "i-00000002"}, map[string]string{"role": "worker"}), IsNil)
+
+	// The tag of the second instance changes before the third poll.
+	polls := 0
+	ec2Svc.onDescribeInstances = func() {
+		polls++
+		if polls == 3 {
+			ec2Svc.instances[1].Tags = setTags(ec2Svc.instances[1].Tags, []*ec2.Tag{{Key: aws.String("role"), Value: aws.String("leader")}})
+		}
+	}
+
+	errStop := errors.New("stop")
+	seen := []map[string]string{}
+	err := cluster.WatchTags(context.Background(), "role", time.Millisecond, func(tags map[string]string) error {
+		seen = append(seen, tags)
+		if len(seen) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, errStop)
+
+	// The callback is invoked for the first poll and then only when the
+	// tags change, so not for the second poll.
+	c.Assert(polls, Equals, 3)
+	c.Assert(seen, DeepEquals, []map[string]string{
+		{"i-00000001": "worker", "i-00000002": "worker"},
+		{"i-00000001": "worker", "i-00000002": "leader"},
+	})
+}
+
+func (s *TagsTest) TestWatchTagsCancelled(c *C) {
+	cluster, _ := tagsCluster(fakeInstance("i-00000001", ec2.InstanceStateNameRunning, time.Now()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := cluster.WatchTags(ctx, "role", time.Hour, func(tags map[string]string) error {
+		calls++
+		c.Assert(tags, HasLen, 0)
+		cancel()
+		return nil
+	})
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(calls, Equals, 1)
+}
@@ -0,0 +1,133 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// ErrVisibilityRenewalFailed is returned in place of the result of a
+// callback that was aborted because the visibility timeout of its message
+// could not be extended.
+var ErrVisibilityRenewalFailed = errors.New("cannot extend message visibility timeout")
+
+// CallbackPanicError is returned in place of the result of a lifecycle
+// event callback that panicked. The lifecycle action is not completed and
+// its message is left in the queue.
+type CallbackPanicError struct {
+	// Value is the value that the callback panicked with.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("lifecycle event callback panicked: %v", e.Value)
+}
+
+// callCallback invokes cb for m, converting a panic into a
+// CallbackPanicError, which is logged and counted in Metrics, so that a
+// faulty callback does not stop the watcher.
+func (s *Cluster) callCallback(ctx context.Context, m *LifecycleMessage, cb LifecycleEventContextCallback) (shouldContinue bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := &CallbackPanicError{Value: r, Stack: debug.Stack()}
+			log.Printf("ERROR: %s: %s\n%s", m.EC2InstanceID, panicErr, panicErr.Stack)
+			s.incrCounter(MetricCallbackPanic)
+			shouldContinue, err = false, panicErr
+		}
+	}()
+	return cb(ctx, m)
+}
+
+// runCallback invokes cb for m. While cb runs, the visibility timeout of
+// messageWrapper, and of the pending messages of the same batch that are
+// waiting to be handled after it, is extended every
+// VisibilityRenewalInterval. If renewal of messageWrapper fails and
+// AbortOnVisibilityRenewalError is set, the context passed to cb is
+// cancelled and ErrVisibilityRenewalFailed is returned. The context passed
+// to cb also carries messageWrapper; see messageFromContext.
+func (s *Cluster) runCallback(ctx context.Context, sqsSvc sqsiface.SQSAPI, queueURL string, messageWrapper *sqs.Message, pending []*sqs.Message, m *LifecycleMessage, cb LifecycleEventContextCallback) (bool, error) {
+	ctx = context.WithValue(ctx, queuedMessageKey{}, queuedMessage{queueURL: queueURL, messageWrapper: messageWrapper})
+	if s.VisibilityRenewalInterval <= 0 {
+		return s.callCallback(ctx, m, cb)
+	}
+
+	cbCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	aborted := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if s.renewVisibility(cbCtx, sqsSvc, queueURL, messageWrapper, pending, m, done) {
+			close(aborted)
+			cancel()
+		}
+	}()
+
+	shouldContinue, err := s.callCallback(cbCtx, m, cb)
+	close(done)
+	<-stopped
+	select {
+	case <-aborted:
+		return false, ErrVisibilityRenewalFailed
+	default:
+	}
+	return shouldContinue, err
+}
+
+// renewVisibility extends the visibility timeout of messageWrapper and
+// pending every VisibilityRenewalInterval until done is closed. It returns
+// true if the callback should be aborted because renewal of messageWrapper
+// failed. Failures to renew pending are only logged, since they are not
+// being handled yet.
+func (s *Cluster) renewVisibility(ctx context.Context, sqsSvc sqsiface.SQSAPI, queueURL string, messageWrapper *sqs.Message, pending []*sqs.Message, m *LifecycleMessage, done <-chan struct{}) bool {
+	visibilityTimeout := int64(2 * s.VisibilityRenewalInterval / time.Second)
+	if visibilityTimeout < 1 {
+		visibilityTimeout = 1
+	}
+
+	ticker := time.NewTicker(s.VisibilityRenewalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		changeMessageVisibility(sqsSvc, queueURL, pending, aws.Int64(visibilityTimeout))
+		_, err := sqsSvc.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     messageWrapper.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(visibilityTimeout),
+		})
+		if err == nil {
+			s.progress()
+			continue
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		log.Printf("ERROR: ChangeMessageVisibility: %s: %s", m.EC2InstanceID, err)
+		s.incrCounter(MetricVisibilityRenewalFailed)
+		if s.VisibilityRenewalErrorCallback != nil {
+			s.VisibilityRenewalErrorCallback(m, err)
+		}
+		if s.AbortOnVisibilityRenewalError {
+			return true
+		}
+	}
+}
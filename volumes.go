@@ -0,0 +1,273 @@
+package ec2cluster
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrNoVolumeAvailable is returned by VolumeClaimer.Claim when there is no
+// unattached volume matching the selector in the instance's availability
+// zone.
+var ErrNoVolumeAvailable = errors.New("no matching volume is available")
+
+// VolumeClaimer attaches EBS volumes from a tagged pool to cluster
+// instances as they launch and detaches them as they terminate. This is
+// useful for running stateful services in an autoscaling group, where each
+// new instance should pick up the data left behind by an old one.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEvents to claim volumes
+// automatically, or call Claim and Release directly.
+type VolumeClaimer struct {
+	Cluster *Cluster
+
+	// Selector is the set of tags that a volume must have to be claimed.
+	Selector map[string]string
+
+	// Device is the device name to expose the volume to the instance as,
+	// for example `/dev/xvdf`.
+	Device string
+
+	// ClaimTag, if not empty, is the name of a tag which is set to the
+	// instance ID on a volume when it is claimed and removed when it is
+	// released.
+	ClaimTag string
+
+	// DevicePath, if not empty, is a path that must exist before Claim
+	// returns. It is only checked when the volume is attached to the
+	// current instance. On instance types that expose EBS volumes as NVMe
+	// devices this may differ from Device.
+	DevicePath string
+
+	// Timeout is how long to wait for a volume to attach or detach. If
+	// zero, five minutes is used.
+	Timeout time.Duration
+}
+
+const volumePollInterval = 5 * time.Second
+
+func (v *VolumeClaimer) timeout() time.Duration {
+	if v.Timeout == 0 {
+		return 5 * time.Minute
+	}
+	return v.Timeout
+}
+
+// Claim attaches an unattached volume matching Selector in the same
+// availability zone as the instance and waits for the attachment to
+// complete. If a matching volume is already attached to the instance
+// then that volume is returned.
+func (v *VolumeClaimer) Claim(instanceID string) (*ec2.Volume, error) {
+	attached, err := v.attachedVolumes(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(attached) > 0 {
+		return attached[0], nil
+	}
+
+	instance, err := v.Cluster.describeInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	ec2svc := v.Cluster.ec2Client()
+	candidates, err := v.describeVolumes(append(tagFilters(v.Selector),
+		&ec2.Filter{
+			Name:   aws.String("availability-zone"),
+			Values: []*string{instance.Placement.AvailabilityZone},
+		},
+		&ec2.Filter{
+			Name:   aws.String("status"),
+			Values: []*string{aws.String(ec2.VolumeStateAvailable)},
+		}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byVolumeID(candidates))
+
+	// Another instance may be claiming from the same pool at the same time,
+	// so if an attach fails we move on to the next candidate.
+	for _, volume := range candidates {
+		_, err := ec2svc.AttachVolume(&ec2.AttachVolumeInput{
+			Device:     aws.String(v.Device),
+			InstanceId: aws.String(instanceID),
+			VolumeId:   volume.VolumeId,
+		})
+		if err != nil {
+			log.Printf("AttachVolume %s: %s", *volume.VolumeId, err)
+			continue
+		}
+
+		if err := v.waitForAttachment(instanceID, *volume.VolumeId); err != nil {
+			return nil, err
+		}
+
+		if v.ClaimTag != "" {
+			_, err := ec2svc.CreateTags(&ec2.CreateTagsInput{
+				Resources: []*string{volume.VolumeId},
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String(v.ClaimTag), Value: aws.String(instanceID)},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return volume, nil
+	}
+	return nil, ErrNoVolumeAvailable
+}
+
+// Release detaches each volume matching Selector from the instance and
+// waits for the volumes to become available.
+func (v *VolumeClaimer) Release(instanceID string) error {
+	attached, err := v.attachedVolumes(instanceID)
+	if err != nil {
+		return err
+	}
+
+	ec2svc := v.Cluster.ec2Client()
+	for _, volume := range attached {
+		_, err := ec2svc.DetachVolume(&ec2.DetachVolumeInput{
+			InstanceId: aws.String(instanceID),
+			VolumeId:   volume.VolumeId,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = waitUntil(v.timeout(), volumePollInterval, func() (bool, error) {
+			current, err := v.describeVolume(*volume.VolumeId)
+			if err != nil {
+				return false, err
+			}
+			return aws.StringValue(current.State) == ec2.VolumeStateAvailable, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if v.ClaimTag != "" {
+			_, err := ec2svc.DeleteTags(&ec2.DeleteTagsInput{
+				Resources: []*string{volume.VolumeId},
+				Tags:      []*ec2.Tag{&ec2.Tag{Key: aws.String(v.ClaimTag)}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HandleLifecycleEvent is a LifecyleEventCallback that claims a volume for
+// launching instances and releases the volumes of terminating instances.
+// If no volume can be claimed for a launching instance, the lifecycle
+// action is abandoned.
+func (v *VolumeClaimer) HandleLifecycleEvent(m *LifecycleMessage) (bool, error) {
+	switch m.LifecycleTransition {
+	case TransitionLaunching:
+		_, err := v.Claim(m.EC2InstanceID)
+		if err == ErrNoVolumeAvailable {
+			log.Printf("ERROR: cannot claim volume for %s: %s", m.EC2InstanceID, err)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	case TransitionTerminating:
+		if err := v.Release(m.EC2InstanceID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// attachedVolumes returns the volumes matching Selector that are attached
+// (or attaching) to the instance.
+func (v *VolumeClaimer) attachedVolumes(instanceID string) ([]*ec2.Volume, error) {
+	volumes, err := v.describeVolumes(append(tagFilters(v.Selector),
+		&ec2.Filter{
+			Name:   aws.String("attachment.instance-id"),
+			Values: []*string{aws.String(instanceID)},
+		}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byVolumeID(volumes))
+	return volumes, nil
+}
+
+// describeVolumes returns every volume matching filters.
+func (v *VolumeClaimer) describeVolumes(filters []*ec2.Filter) ([]*ec2.Volume, error) {
+	ec2svc := v.Cluster.ec2Client()
+	rv := []*ec2.Volume{}
+	err := ec2svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{
+		Filters: filters,
+	}, func(resp *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		rv = append(rv, resp.Volumes...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func (v *VolumeClaimer) describeVolume(volumeID string) (*ec2.Volume, error) {
+	ec2svc := v.Cluster.ec2Client()
+	resp, err := ec2svc.DescribeVolumes(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(volumeID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Volumes) != 1 {
+		return nil, &NotFoundError{Kind: "volume", ID: volumeID}
+	}
+	return resp.Volumes[0], nil
+}
+
+// waitForAttachment waits until the volume is attached to the instance and,
+// if the instance is the current one, until DevicePath exists.
+func (v *VolumeClaimer) waitForAttachment(instanceID, volumeID string) error {
+	err := waitUntil(v.timeout(), volumePollInterval, func() (bool, error) {
+		volume, err := v.describeVolume(volumeID)
+		if err != nil {
+			return false, err
+		}
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) == instanceID &&
+				aws.StringValue(attachment.State) == ec2.VolumeAttachmentStateAttached {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if v.DevicePath == "" || instanceID != v.Cluster.InstanceID {
+		return nil
+	}
+	return waitUntil(v.timeout(), time.Second, func() (bool, error) {
+		_, err := os.Stat(v.DevicePath)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+type byVolumeID []*ec2.Volume
+
+func (a byVolumeID) Len() int           { return len(a) }
+func (a byVolumeID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byVolumeID) Less(i, j int) bool { return *a[i].VolumeId < *a[j].VolumeId }
@@ -0,0 +1,123 @@
+package ec2cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "gopkg.in/check.v1"
+)
+
+type VolumesTest struct {
+}
+
+var _ = Suite(&VolumesTest{})
+
+func poolVolume(volumeID, availabilityZone, attachedTo string) *ec2.Volume {
+	volume := &ec2.Volume{
+		VolumeId:         aws.String(volumeID),
+		AvailabilityZone: aws.String(availabilityZone),
+		State:            aws.String(ec2.VolumeStateAvailable),
+		Tags:             []*ec2.Tag{{Key: aws.String("pool"), Value: aws.String("data")}},
+	}
+	if attachedTo != "" {
+		volume.State = aws.String(ec2.VolumeStateInUse)
+		volume.Attachments = []*ec2.VolumeAttachment{{
+			InstanceId: aws.String(attachedTo),
+			VolumeId:   aws.String(volumeID),
+			State:      aws.String(ec2.VolumeAttachmentStateAttached),
+		}}
+	}
+	return volume
+}
+
+// volumeClaimer returns a VolumeClaimer for the volumes of the data pool.
+// Claims are made for i-00000002, which is in us-east-1a and is not the
+// current instance.
+func volumeClaimer(volumes ...*ec2.Volume) (*VolumeClaimer, *fakeEC2) {
+	ec2Svc := &fakeEC2{volumes: volumes}
+	ec2Svc.setInstances(fakeInstance("i-00000002", ec2.InstanceStateNameRunning, time.Now()))
+	v := &VolumeClaimer{
+		Cluster:  &Cluster{InstanceID: "i-00000001", EC2: ec2Svc},
+		Selector: map[string]string{"pool": "data"},
+		Device:   "/dev/xvdf",
+		ClaimTag: "claimed-by",
+	}
+	return v, ec2Svc
+}
+
+func (s *VolumesTest) TestClaim(c *C) {
+	other := poolVolume("vol-00000001", "us-east-1a", "i-00000003")
+	otherZone := poolVolume("vol-00000002", "us-east-1b", "")
+	raced := poolVolume("vol-00000003", "us-east-1a", "")
+	available := poolVolume("vol-00000004", "us-east-1a", "")
+	untagged := poolVolume("vol-00000000", "us-east-1a", "")
+	untagged.Tags = nil
+	v, ec2Svc := volumeClaimer(available, raced, otherZone, other, untagged)
+
+	// Another instance attaches the first candidate before us, so the next
+	// one is claimed.
+	ec2Svc.attachErrs = map[string]error{"vol-00000003": errors.New("VolumeInUse")}
+
+	volume, err := v.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*volume.VolumeId, Equals, "vol-00000004")
+	c.Assert(*available.Attachments[0].InstanceId, Equals, "i-00000002")
+	c.Assert(*available.Attachments[0].Device, Equals, "/dev/xvdf")
+	c.Assert(ec2Svc.tagged, HasLen, 1)
+	c.Assert(aws.StringValueSlice(ec2Svc.tagged[0].Resources), DeepEquals, []string{"vol-00000004"})
+	c.Assert(*ec2Svc.tagged[0].Tags[0].Key, Equals, "claimed-by")
+	c.Assert(*ec2Svc.tagged[0].Tags[0].Value, Equals, "i-00000002")
+
+	for _, volume := range []*ec2.Volume{otherZone, raced, untagged} {
+		c.Assert(*volume.State, Equals, ec2.VolumeStateAvailable)
+	}
+	c.Assert(*other.Attachments[0].InstanceId, Equals, "i-00000003")
+}
+
+func (s *VolumesTest) TestClaimAlreadyAttached(c *C) {
+	attached := poolVolume("vol-00000002", "us-east-1a", "i-00000002")
+	available := poolVolume("vol-00000001", "us-east-1a", "")
+	v, ec2Svc := volumeClaimer(available, attached)
+
+	volume, err := v.Claim("i-00000002")
+	c.Assert(err, IsNil)
+	c.Assert(*volume.VolumeId, Equals, "vol-00000002")
+	c.Assert(*available.State, Equals, ec2.VolumeStateAvailable)
+	c.Assert(ec2Svc.tagged, HasLen, 0)
+}
+
+func (s *VolumesTest) TestClaimNoneAvailable(c *C) {
+	v, _ := volumeClaimer(poolVolume("vol-00000001", "us-east-1a", "i-00000003"))
+
+	_, err := v.Claim("i-00000002")
+	c.Assert(err, Equals, ErrNoVolumeAvailable)
+
+	// The launch is abandoned rather than retried.
+	shouldContinue, err := v.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionLaunching,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, false)
+}
+
+func (s *VolumesTest) TestRelease(c *C) {
+	attached := poolVolume("vol-00000001", "us-east-1a", "i-00000002")
+	attached.Tags = append(attached.Tags, &ec2.Tag{Key: aws.String("claimed-by"), Value: aws.String("i-00000002")})
+	other := poolVolume("vol-00000002", "us-east-1a", "i-00000003")
+	v, _ := volumeClaimer(attached, other)
+
+	shouldContinue, err := v.HandleLifecycleEvent(&LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000002",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shouldContinue, Equals, true)
+
+	c.Assert(*attached.State, Equals, ec2.VolumeStateAvailable)
+	c.Assert(attached.Attachments, HasLen, 0)
+	c.Assert(attached.Tags, DeepEquals, []*ec2.Tag{{Key: aws.String("pool"), Value: aws.String("data")}})
+	c.Assert(*other.Attachments[0].InstanceId, Equals, "i-00000003")
+}
@@ -0,0 +1,49 @@
+package ec2cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned when an operation gives up waiting for AWS to
+// reach the expected state.
+var ErrTimeout = errors.New("timed out waiting for condition")
+
+// waitUntil invokes f every interval until it returns true or an error,
+// or until timeout elapses, in which case ErrTimeout is returned.
+func waitUntil(timeout, interval time.Duration, f func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := f()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(interval)
+	}
+}
+
+// waitContext invokes f every interval until it returns true or an error,
+// or until ctx is done, in which case the context's error is returned.
+func waitContext(ctx context.Context, interval time.Duration, f func() (bool, error)) error {
+	for {
+		ok, err := f()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ec2cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers set on the requests made by Webhook.
+const (
+	WebhookSignatureHeader = "X-Ec2cluster-Signature"
+	WebhookTimestampHeader = "X-Ec2cluster-Timestamp"
+)
+
+// WebhookStatusError is returned by Webhook.Post when the endpoint keeps
+// responding with a status that should be retried.
+type WebhookStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *WebhookStatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Webhook hands lifecycle events to an HTTP endpoint, so that programs that
+// do not link this package, such as sidecars or existing drain services,
+// can handle them. Each event is POSTed to URL as the JSON lifecycle hook
+// message, and the response status decides the lifecycle action:
+//
+//   - 2xx continues the lifecycle action.
+//   - Any other 4xx, except 408 and 429, abandons it.
+//   - 408, 429, 5xx and network errors are retried. When MaxAttempts is
+//     reached the event is left in the queue and received again later.
+//
+// If Secret is set, each request carries the time it was sent in the
+// X-Ec2cluster-Timestamp header and an HMAC-SHA256 of that time and the
+// body in the X-Ec2cluster-Signature header. Receivers check it with
+// VerifyWebhookSignature.
+//
+// Pass HandleLifecycleEvent to WatchLifecycleEventsContext.
+type Webhook struct {
+	URL    string
+	Secret []byte
+
+	// Client is the HTTP client used to make requests. If nil, a client
+	// with a timeout of one minute is used.
+	Client *http.Client
+
+	// MaxAttempts is how many times a request is made before giving up. If
+	// zero, three attempts are made.
+	MaxAttempts int
+
+	// RetryInterval is how long to wait after the first failed attempt. It
+	// doubles after each further attempt. If zero, one second is used.
+	RetryInterval time.Duration
+}
+
+var defaultWebhookClient = &http.Client{Timeout: time.Minute}
+
+// HandleLifecycleEvent is a LifecycleEventContextCallback that posts each
+// lifecycle event to the webhook.
+func (w *Webhook) HandleLifecycleEvent(ctx context.Context, m *LifecycleMessage) (bool, error) {
+	return w.Post(ctx, m)
+}
+
+// Post sends the lifecycle event m to the webhook and returns true if the
+// lifecycle action should be continued or false if it should be abandoned.
+// It returns an error if the endpoint cannot be reached or keeps asking for
+// the event to be retried.
+func (w *Webhook) Post(ctx context.Context, m *LifecycleMessage) (bool, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return false, err
+	}
+
+	maxAttempts := w.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	retryInterval := w.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		shouldContinue, retry, err := w.post(ctx, body)
+		if !retry {
+			return shouldContinue, err
+		}
+		if attempt >= maxAttempts {
+			return false, err
+		}
+		log.Printf("ERROR: webhook for %s: %s, retrying", m.EC2InstanceID, err)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+		retryInterval *= 2
+	}
+}
+
+// post makes one request to the webhook. It returns retry if the request
+// should be made again.
+func (w *Webhook) post(ctx context.Context, body []byte) (shouldContinue bool, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(w.Secret, timestamp, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// When ctx is done the watcher is stopping, and the event is left in
+		// the queue rather than retried here.
+		return false, ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return false, true, &WebhookStatusError{URL: w.URL, StatusCode: resp.StatusCode}
+	default:
+		return false, false, nil
+	}
+}
+
+// webhookSignature returns the value of the signature header for a request
+// with the given timestamp and body.
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature returns true if the signature and timestamp headers
+// of a request made by Webhook match its body, and the timestamp is within
+// maxAge of the current time. It is for HTTP handlers that receive
+// lifecycle events.
+func VerifyWebhookSignature(secret []byte, header http.Header, body []byte, maxAge time.Duration) bool {
+	timestamp := header.Get(WebhookTimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(sent, 0))
+	if age > maxAge || age < -maxAge {
+		return false
+	}
+	expected := webhookSignature(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(header.Get(WebhookSignatureHeader)))
+}
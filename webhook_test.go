@@ -0,0 +1,109 @@
+package ec2cluster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type WebhookTest struct {
+}
+
+var _ = Suite(&WebhookTest{})
+
+// webhookServer responds to each request with the next of statuses, and
+// records the requests it receives.
+type webhookServer struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (w *webhookServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.requests = append(w.requests, req)
+	w.bodies = append(w.bodies, body)
+	status := w.statuses[0]
+	if len(w.statuses) > 1 {
+		w.statuses = w.statuses[1:]
+	}
+	rw.WriteHeader(status)
+}
+
+func (s *WebhookTest) TestStatuses(c *C) {
+	m := &LifecycleMessage{
+		LifecycleTransition: TransitionTerminating,
+		EC2InstanceID:       "i-00000001",
+	}
+	for _, tc := range []struct {
+		statuses       []int
+		shouldContinue bool
+		err            bool
+		requests       int
+	}{
+		{statuses: []int{http.StatusNoContent}, shouldContinue: true, requests: 1},
+		{statuses: []int{http.StatusConflict}, shouldContinue: false, requests: 1},
+		{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, shouldContinue: true, requests: 3},
+		{statuses: []int{http.StatusInternalServerError}, err: true, requests: 3},
+	} {
+		server := &webhookServer{statuses: tc.statuses}
+		ts := httptest.NewServer(server)
+		w := &Webhook{URL: ts.URL, RetryInterval: time.Millisecond}
+
+		shouldContinue, err := w.HandleLifecycleEvent(context.Background(), m)
+		ts.Close()
+		if tc.err {
+			c.Assert(err, FitsTypeOf, &WebhookStatusError{})
+			c.Assert(err.(*WebhookStatusError).StatusCode, Equals, http.StatusInternalServerError)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		c.Assert(shouldContinue, Equals, tc.shouldContinue)
+		c.Assert(server.requests, HasLen, tc.requests)
+
+		posted := LifecycleMessage{}
+		c.Assert(json.Unmarshal(server.bodies[0], &posted), IsNil)
+		c.Assert(posted.EC2InstanceID, Equals, "i-00000001")
+	}
+}
+
+func (s *WebhookTest) TestSignature(c *C) {
+	server := &webhookServer{statuses: []int{http.StatusOK}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	secret := []byte("s3cret")
+	w := &Webhook{URL: ts.URL, Secret: secret}
+
+	_, err := w.Post(context.Background(), &LifecycleMessage{EC2InstanceID: "i-00000001"})
+	c.Assert(err, IsNil)
+	c.Assert(server.requests, HasLen, 1)
+	header, body := server.requests[0].Header, server.bodies[0]
+	c.Assert(VerifyWebhookSignature(secret, header, body, time.Minute), Equals, true)
+	c.Assert(VerifyWebhookSignature([]byte("wrong"), header, body, time.Minute), Equals, false)
+	c.Assert(VerifyWebhookSignature(secret, header, append(body, ' '), time.Minute), Equals, false)
+
+	// Old requests are rejected so that they cannot be replayed.
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header = http.Header{}
+	header.Set(WebhookTimestampHeader, old)
+	header.Set(WebhookSignatureHeader, webhookSignature(secret, old, body))
+	c.Assert(VerifyWebhookSignature(secret, header, body, time.Minute), Equals, false)
+}
+
+func (s *WebhookTest) TestCancel(c *C) {
+	w := &Webhook{URL: "http://127.0.0.1:1/", RetryInterval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := w.Post(ctx, &LifecycleMessage{})
+	c.Assert(err, NotNil)
+}